@@ -0,0 +1,378 @@
+// Package bdd implements reduced ordered binary decision diagrams of boolean
+// expr trees.
+package bdd
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+// Node is a function represented by a Manager. Two nodes of the same
+// Manager are equal if and only if they represent the same function.
+type Node int
+
+const (
+	False Node = iota
+	True
+)
+
+const terminal = math.MaxInt32 // level of False and True
+
+type node struct {
+	level     int
+	low, high Node // cofactors for the variable set to false and true
+}
+
+// binop is a boolean operator applied by Manager.apply.
+type binop int
+
+const (
+	and binop = iota
+	or
+	xor
+	not
+)
+
+type key struct {
+	op   binop
+	f, g Node
+}
+
+// Manager keeps the nodes of diagrams that share a variable order. The
+// first variable is the top of every diagram.
+type Manager struct {
+	names  []string
+	levels map[string]int
+	leaves []expr.Expr // expression of each variable
+	uses   []int       // references to each variable by FromExpr
+	nodes  []node
+	unique map[node]Node
+	cache  map[key]Node
+}
+
+// New returns a Manager with the given variable order. Variables which are
+// not in order are appended as they are found.
+func New(order ...string) *Manager {
+	m := &Manager{
+		levels: make(map[string]int),
+		nodes:  []node{{terminal, False, False}, {terminal, True, True}},
+		unique: make(map[node]Node),
+		cache:  make(map[key]Node),
+	}
+	for _, name := range order {
+		m.Var(name)
+	}
+	return m
+}
+
+// Vars returns the variables in order.
+func (m *Manager) Vars() []string {
+	return append([]string(nil), m.names...)
+}
+
+// Var returns the function which is true when the variable name is.
+func (m *Manager) Var(name string) Node {
+	return m.variable(name, nil)
+}
+
+func (m *Manager) variable(name string, leaf expr.Expr) Node {
+	l, ok := m.levels[name]
+	if !ok {
+		l = len(m.names)
+		m.names = append(m.names, name)
+		m.levels[name] = l
+		m.leaves = append(m.leaves, nil)
+		m.uses = append(m.uses, 0)
+	}
+	if m.leaves[l] == nil {
+		m.leaves[l] = leaf
+	}
+	return m.mk(l, False, True)
+}
+
+func (m *Manager) level(f Node) int {
+	return m.nodes[f].level
+}
+
+// mk returns the node testing the variable at level, keeping the diagram
+// reduced.
+func (m *Manager) mk(level int, low, high Node) Node {
+	if low == high {
+		return low
+	}
+	n := node{level, low, high}
+	if f, ok := m.unique[n]; ok {
+		return f
+	}
+	f := Node(len(m.nodes))
+	m.nodes = append(m.nodes, n)
+	m.unique[n] = f
+	return f
+}
+
+// cofactors returns f with the variable at level set to false and true.
+func (m *Manager) cofactors(f Node, level int) (Node, Node) {
+	n := m.nodes[f]
+	if n.level != level {
+		return f, f
+	}
+	return n.low, n.high
+}
+
+// Not returns the complement of f.
+func (m *Manager) Not(f Node) Node {
+	switch f {
+	case False:
+		return True
+	case True:
+		return False
+	}
+	k := key{not, f, f}
+	if r, ok := m.cache[k]; ok {
+		return r
+	}
+	n := m.nodes[f]
+	r := m.mk(n.level, m.Not(n.low), m.Not(n.high))
+	m.cache[k] = r
+	return r
+}
+
+// Apply combines f and g with a logical or bitwise operator: && and &, ||
+// and |, ^ and !=, or ~^ and ==.
+func (m *Manager) Apply(op expr.Op, f, g Node) Node {
+	switch op {
+	case expr.OpAnd, expr.OpBitAnd:
+		return m.apply(and, f, g)
+	case expr.OpOr, expr.OpBitOr:
+		return m.apply(or, f, g)
+	case expr.OpBitXor, expr.OpNe:
+		return m.apply(xor, f, g)
+	case expr.OpBitXnor, expr.OpEq:
+		return m.Not(m.apply(xor, f, g))
+	}
+	panic("bdd: not a boolean operator: " + op.String())
+}
+
+// And returns the conjunction of f and g.
+func (m *Manager) And(f, g Node) Node {
+	return m.apply(and, f, g)
+}
+
+// Or returns the disjunction of f and g.
+func (m *Manager) Or(f, g Node) Node {
+	return m.apply(or, f, g)
+}
+
+// Xor returns the exclusive or of f and g.
+func (m *Manager) Xor(f, g Node) Node {
+	return m.apply(xor, f, g)
+}
+
+// Ite returns the function f ? g : h.
+func (m *Manager) Ite(f, g, h Node) Node {
+	return m.Or(m.And(f, g), m.And(m.Not(f), h))
+}
+
+func (m *Manager) apply(op binop, f, g Node) Node {
+	switch op {
+	case and:
+		switch {
+		case f == False || g == False:
+			return False
+		case f == True || f == g:
+			return g
+		case g == True:
+			return f
+		}
+	case or:
+		switch {
+		case f == True || g == True:
+			return True
+		case f == False || f == g:
+			return g
+		case g == False:
+			return f
+		}
+	case xor:
+		switch {
+		case f == g:
+			return False
+		case f == False:
+			return g
+		case g == False:
+			return f
+		case f == True:
+			return m.Not(g)
+		case g == True:
+			return m.Not(f)
+		}
+	}
+	if f > g {
+		f, g = g, f // all operators commute
+	}
+	k := key{op, f, g}
+	if r, ok := m.cache[k]; ok {
+		return r
+	}
+	l := min(m.level(f), m.level(g))
+	f0, f1 := m.cofactors(f, l)
+	g0, g1 := m.cofactors(g, l)
+	r := m.mk(l, m.apply(op, f0, g0), m.apply(op, f1, g1))
+	m.cache[k] = r
+	return r
+}
+
+// Restrict returns f with the variable name set to value.
+func (m *Manager) Restrict(f Node, name string, value bool) Node {
+	l, ok := m.levels[name]
+	if !ok {
+		return f
+	}
+	done := make(map[Node]Node)
+	var restrict func(f Node) Node
+	restrict = func(f Node) Node {
+		n := m.nodes[f]
+		switch {
+		case n.level > l:
+			return f
+		case n.level == l && value:
+			return n.high
+		case n.level == l:
+			return n.low
+		}
+		if r, ok := done[f]; ok {
+			return r
+		}
+		r := m.mk(n.level, restrict(n.low), restrict(n.high))
+		done[f] = r
+		return r
+	}
+	return restrict(f)
+}
+
+// Exists returns the function which is true when f is for some value of
+// the variables names.
+func (m *Manager) Exists(f Node, names ...string) Node {
+	return m.quantify(or, f, names)
+}
+
+// Forall returns the function which is true when f is for every value of
+// the variables names.
+func (m *Manager) Forall(f Node, names ...string) Node {
+	return m.quantify(and, f, names)
+}
+
+func (m *Manager) quantify(op binop, f Node, names []string) Node {
+	levels := make(map[int]bool)
+	for _, name := range names {
+		if l, ok := m.levels[name]; ok {
+			levels[l] = true
+		}
+	}
+	done := make(map[Node]Node)
+	var quantify func(f Node) Node
+	quantify = func(f Node) Node {
+		n := m.nodes[f]
+		if n.level == terminal {
+			return f
+		}
+		if r, ok := done[f]; ok {
+			return r
+		}
+		low, high := quantify(n.low), quantify(n.high)
+		var r Node
+		if levels[n.level] {
+			r = m.apply(op, low, high)
+		} else {
+			r = m.mk(n.level, low, high)
+		}
+		done[f] = r
+		return r
+	}
+	return quantify(f)
+}
+
+// Eval returns the value of f for an assignment of its variables. Missing
+// variables are false.
+func (m *Manager) Eval(f Node, assignment map[string]bool) bool {
+	for f != False && f != True {
+		n := m.nodes[f]
+		if assignment[m.names[n.level]] {
+			f = n.high
+		} else {
+			f = n.low
+		}
+	}
+	return f == True
+}
+
+// Size returns the number of decision nodes of f.
+func (m *Manager) Size(f Node) int {
+	seen := make(map[Node]bool)
+	var size func(f Node)
+	size = func(f Node) {
+		if f == False || f == True || seen[f] {
+			return
+		}
+		seen[f] = true
+		size(m.nodes[f].low)
+		size(m.nodes[f].high)
+	}
+	size(f)
+	return len(seen)
+}
+
+// SatCount returns the number of assignments of all the variables of the
+// Manager which satisfy f.
+func (m *Manager) SatCount(f Node) *big.Int {
+	n := len(m.names)
+	level := func(f Node) int {
+		return min(m.level(f), n)
+	}
+	done := make(map[Node]*big.Int)
+	var count func(f Node) *big.Int
+	count = func(f Node) *big.Int {
+		switch f {
+		case False:
+			return big.NewInt(0)
+		case True:
+			return big.NewInt(1)
+		}
+		if c, ok := done[f]; ok {
+			return c
+		}
+		nd := m.nodes[f]
+		low := new(big.Int).Lsh(count(nd.low), uint(level(nd.low)-nd.level-1))
+		high := new(big.Int).Lsh(count(nd.high), uint(level(nd.high)-nd.level-1))
+		c := low.Add(low, high)
+		done[f] = c
+		return c
+	}
+	return new(big.Int).Lsh(count(f), uint(level(f)))
+}
+
+// AllSat calls fn with each assignment of all the variables of the Manager
+// which satisfies f, until fn returns false. The map is reused between
+// calls.
+func (m *Manager) AllSat(f Node, fn func(assignment map[string]bool) bool) {
+	assignment := make(map[string]bool, len(m.names))
+	var enumerate func(f Node, level int) bool
+	enumerate = func(f Node, level int) bool {
+		if f == False {
+			return true
+		}
+		if level == len(m.names) {
+			return fn(assignment)
+		}
+		low, high := m.cofactors(f, level)
+		assignment[m.names[level]] = false
+		if !enumerate(low, level+1) {
+			return false
+		}
+		assignment[m.names[level]] = true
+		return enumerate(high, level+1)
+	}
+	enumerate(f, 0)
+}
@@ -0,0 +1,94 @@
+package bdd
+
+import (
+	"testing"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+func TestCanonical(t *testing.T) {
+	m := New("Sel", "A", "B")
+	sel, a, b := m.Var("Sel"), m.Var("A"), m.Var("B")
+	mux := m.Ite(sel, b, a)
+	if sop := m.Or(m.And(sel, b), m.And(m.Not(sel), a)); sop != mux {
+		t.Fatal(sop, mux)
+	}
+	if m.Not(m.Not(mux)) != mux {
+		t.Fatal("double negation")
+	}
+	if m.Xor(mux, mux) != False || m.Or(mux, m.Not(mux)) != True {
+		t.Fatal("complement")
+	}
+	if m.Apply(expr.OpEq, a, b) != m.Not(m.Apply(expr.OpNe, a, b)) {
+		t.Fatal("equality")
+	}
+	if m.Size(mux) != 3 {
+		t.Fatal(m.Size(mux))
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	m := New("Sel", "A", "B")
+	sel, a, b := m.Var("Sel"), m.Var("A"), m.Var("B")
+	mux := m.Ite(sel, b, a)
+	if m.Restrict(mux, "Sel", true) != b || m.Restrict(mux, "Sel", false) != a {
+		t.Fatal("restrict")
+	}
+	if m.Restrict(mux, "C", true) != mux {
+		t.Fatal("restrict of unknown variable")
+	}
+	if m.Exists(mux, "Sel") != m.Or(a, b) {
+		t.Fatal("exists")
+	}
+	if m.Forall(mux, "Sel") != m.And(a, b) {
+		t.Fatal("forall")
+	}
+	if m.Exists(mux, "Sel", "A", "B") != True || m.Forall(mux, "A", "B") != False {
+		t.Fatal("quantify all")
+	}
+}
+
+func TestSatCount(t *testing.T) {
+	m := New("A", "B", "C", "D")
+	a, d := m.Var("A"), m.Var("D")
+	for _, c := range []struct {
+		f Node
+		n int64
+	}{
+		{False, 0},
+		{True, 16},
+		{a, 8},
+		{d, 8},
+		{m.And(a, d), 4},
+		{m.Or(a, d), 12},
+		{m.Xor(a, m.Var("C")), 8},
+	} {
+		if n := m.SatCount(c.f); n.Int64() != c.n {
+			t.Fatal(c.f, n, c.n)
+		}
+	}
+}
+
+func TestAllSat(t *testing.T) {
+	m := New("A", "B", "C")
+	f := m.Or(m.And(m.Var("A"), m.Var("B")), m.Var("C"))
+	n := 0
+	m.AllSat(f, func(assignment map[string]bool) bool {
+		if len(assignment) != 3 || !m.Eval(f, assignment) {
+			t.Fatal(assignment)
+		}
+		n++
+		return true
+	})
+	if int64(n) != m.SatCount(f).Int64() {
+		t.Fatal(n)
+	}
+	n = 0
+	m.AllSat(f, func(map[string]bool) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,327 @@
+package bdd
+
+import (
+	"fmt"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+// FromExpr returns the diagram of the boolean expression e. Its leaves
+// are 1-bit Vars and constants, and single bits of wider Vars, which
+// become variables named like "a[3]". Operators must be logical, bitwise
+// or equalities on single bits, conditionals, casts and reductions of Vars.
+func (m *Manager) FromExpr(e expr.Expr) (Node, error) {
+	done := make(map[expr.Expr]Node)
+	return m.fromExpr(e, done)
+}
+
+func errorf(e expr.Expr, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", expr.Format(e), fmt.Sprintf(format, args...))
+}
+
+// use returns the variable name, counting its references.
+func (m *Manager) use(name string, leaf expr.Expr) Node {
+	f := m.variable(name, leaf)
+	m.uses[m.levels[name]]++
+	return f
+}
+
+// bit returns the variable of the bit i of v.
+func (m *Manager) bit(v *expr.Var, i uint) Node {
+	if v.Value == nil || v.Value.Width() == 1 {
+		return m.use(v.Name, v)
+	}
+	name := fmt.Sprintf("%s[%d]", v.Name, i)
+	return m.use(name, expr.Index(v, expr.NewSigned(32, int64(i))))
+}
+
+// bits returns the variables of all bits of v, least significant first.
+func (m *Manager) bits(v *expr.Var) []Node {
+	w := uint(1)
+	if v.Value != nil {
+		w = v.Value.Width()
+	}
+	bits := make([]Node, w)
+	for i := range bits {
+		bits[i] = m.bit(v, uint(i))
+	}
+	return bits
+}
+
+func (m *Manager) fromExpr(e expr.Expr, done map[expr.Expr]Node) (Node, error) {
+	if f, ok := done[e]; ok {
+		return f, nil
+	}
+	f, err := m.convert(e, done)
+	if err != nil {
+		return False, err
+	}
+	if _, ok := e.(*expr.Var); !ok {
+		done[e] = f // Vars are converted again to count their references
+	}
+	return f, nil
+}
+
+func (m *Manager) convert(e expr.Expr, done map[expr.Expr]Node) (Node, error) {
+	switch e := e.(type) {
+	case *expr.Var:
+		if e.Value != nil && e.Value.Width() != 1 {
+			return False, errorf(e, "%d-bit vector is not boolean", e.Value.Width())
+		}
+		return m.use(e.Name, e), nil
+	case expr.Value:
+		if e.Width() != 1 {
+			return False, errorf(e, "%d-bit vector is not boolean", e.Width())
+		}
+		switch {
+		case !expr.LogicOf(e).Known():
+			return False, errorf(e, "unknown value")
+		case e.True():
+			return True, nil
+		}
+		return False, nil
+	case *expr.UnaryExpr:
+		if v, ok := e.Expr.(*expr.Var); ok && e.Op != expr.OpNot && e.Op != expr.OpBitNot && e.Op != expr.OpNeg {
+			return m.reduce(e.Op, m.bits(v)), nil
+		}
+		x, err := m.fromExpr(e.Expr, done)
+		if err != nil {
+			return False, err
+		}
+		switch e.Op {
+		case expr.OpNot, expr.OpBitNot, expr.OpRedNand, expr.OpRedNor, expr.OpRedXnor:
+			return m.Not(x), nil
+		}
+		return x, nil // reductions and negation of a single bit
+	case *expr.BinaryExpr:
+		switch e.Op {
+		case expr.OpAnd, expr.OpOr, expr.OpBitAnd, expr.OpBitOr, expr.OpBitXor, expr.OpBitXnor, expr.OpEq, expr.OpNe:
+		default:
+			return False, errorf(e, "operator %s is not boolean", e.Op)
+		}
+		x, err := m.fromExpr(e.Expr1, done)
+		if err != nil {
+			return False, err
+		}
+		y, err := m.fromExpr(e.Expr2, done)
+		if err != nil {
+			return False, err
+		}
+		return m.Apply(e.Op, x, y), nil
+	case *expr.IfExpr:
+		var fs [3]Node
+		for i, x := range []expr.Expr{e.Cond, e.If, e.Else} {
+			f, err := m.fromExpr(x, done)
+			if err != nil {
+				return False, err
+			}
+			fs[i] = f
+		}
+		return m.Ite(fs[0], fs[1], fs[2]), nil
+	case *expr.CastExpr:
+		return m.fromExpr(e.Expr, done)
+	case *expr.SizeExpr:
+		if e.Width != 1 {
+			return False, errorf(e, "%d-bit vector is not boolean", e.Width)
+		}
+		return m.fromExpr(e.Expr, done)
+	case *expr.IndexExpr:
+		v, ok := e.Expr.(*expr.Var)
+		i, isConst := e.Index.(expr.Value)
+		if !ok || !isConst || !expr.LogicOf(i).Known() {
+			return False, errorf(e, "only constant bits of a signal are supported")
+		}
+		n := i.Uint()
+		if v.Value == nil || n >= uint64(v.Value.Width()) {
+			return False, errorf(e, "index out of range")
+		}
+		return m.bit(v, uint(n)), nil
+	case *expr.SliceExpr:
+		v, ok := e.Expr.(*expr.Var)
+		if !ok || e.High != e.Low || v.Value == nil || e.Low >= v.Value.Width() {
+			return False, errorf(e, "only single bits of a signal are supported")
+		}
+		return m.bit(v, e.Low), nil
+	}
+	return False, errorf(e, "not a boolean expression")
+}
+
+// reduce applies a reduction operator to bits.
+func (m *Manager) reduce(op expr.Op, bits []Node) Node {
+	var r Node
+	var b binop
+	switch op {
+	case expr.OpRedAnd, expr.OpRedNand:
+		r, b = True, and
+	case expr.OpRedOr, expr.OpRedNor:
+		r, b = False, or
+	default:
+		r, b = False, xor
+	}
+	for _, x := range bits {
+		r = m.apply(b, r, x)
+	}
+	switch op {
+	case expr.OpRedNand, expr.OpRedNor, expr.OpRedXnor:
+		r = m.Not(r)
+	}
+	return r
+}
+
+// leaf returns the expression of the variable at level.
+func (m *Manager) leaf(level int) expr.Expr {
+	if m.leaves[level] == nil {
+		m.leaves[level] = expr.NewVar(m.names[level], expr.F)
+	}
+	return m.leaves[level]
+}
+
+// Expr returns a small expression of f over the leaves found by FromExpr.
+// It is the smaller of an irredundant sum of products and of a nesting of
+// the decisions of the diagram.
+func (m *Manager) Expr(f Node) expr.Expr {
+	sop := m.sopExpr(f)
+	dec := m.decisionExpr(f)
+	if size(dec) < size(sop) {
+		return dec
+	}
+	return sop
+}
+
+func size(e expr.Expr) int {
+	n := 0
+	expr.Walk(e, func(expr.Expr, []expr.Expr) error {
+		n++
+		return nil
+	})
+	return n
+}
+
+// decisionExpr writes each decision node as the simplest of an operator
+// or a conditional, sharing the expressions of shared nodes.
+func (m *Manager) decisionExpr(f Node) expr.Expr {
+	done := make(map[Node]expr.Expr)
+	negs := make(map[int]expr.Expr)
+	neg := func(level int) expr.Expr {
+		if negs[level] == nil {
+			negs[level] = expr.Not(m.leaf(level))
+		}
+		return negs[level]
+	}
+	var convert func(f Node) expr.Expr
+	convert = func(f Node) expr.Expr {
+		switch f {
+		case False:
+			return expr.F
+		case True:
+			return expr.T
+		}
+		if e, ok := done[f]; ok {
+			return e
+		}
+		n := m.nodes[f]
+		v := m.leaf(n.level)
+		var e expr.Expr
+		switch {
+		case n.low == False && n.high == True:
+			e = v
+		case n.low == True && n.high == False:
+			e = neg(n.level)
+		case n.low == False:
+			e = expr.And(v, convert(n.high))
+		case n.high == False:
+			e = expr.And(neg(n.level), convert(n.low))
+		case n.high == True:
+			e = expr.Or(v, convert(n.low))
+		case n.low == True:
+			e = expr.Or(neg(n.level), convert(n.high))
+		case n.high == m.Not(n.low):
+			e = expr.BitXor(v, convert(n.low))
+		default:
+			e = &expr.IfExpr{Cond: v, If: convert(n.high), Else: convert(n.low)}
+		}
+		done[f] = e
+		return e
+	}
+	return convert(f)
+}
+
+type literal struct {
+	level int
+	neg   bool
+}
+
+// sopExpr returns an irredundant sum of products of f, following the
+// algorithm of Minato and Morreale.
+func (m *Manager) sopExpr(f Node) expr.Expr {
+	cubes, _ := m.isop(f, f, make(map[[2]Node]cover))
+	if len(cubes) == 0 {
+		return expr.F
+	}
+	var sum expr.Expr
+	for _, cube := range cubes {
+		var product expr.Expr
+		for _, lit := range cube {
+			var x expr.Expr = m.leaf(lit.level)
+			if lit.neg {
+				x = expr.Not(x)
+			}
+			if product == nil {
+				product = x
+			} else {
+				product = expr.And(product, x)
+			}
+		}
+		if product == nil {
+			return expr.T
+		}
+		if sum == nil {
+			sum = product
+		} else {
+			sum = expr.Or(sum, product)
+		}
+	}
+	return sum
+}
+
+type cover struct {
+	cubes [][]literal
+	f     Node
+}
+
+// isop returns the cubes of a cover of a function between lower and upper
+// and the diagram of the cover.
+func (m *Manager) isop(lower, upper Node, done map[[2]Node]cover) ([][]literal, Node) {
+	switch {
+	case lower == False:
+		return nil, False
+	case upper == True:
+		return [][]literal{nil}, True
+	}
+	k := [2]Node{lower, upper}
+	if c, ok := done[k]; ok {
+		return c.cubes, c.f
+	}
+
+	l := min(m.level(lower), m.level(upper))
+	l0, l1 := m.cofactors(lower, l)
+	u0, u1 := m.cofactors(upper, l)
+
+	c0, r0 := m.isop(m.And(l0, m.Not(u1)), u0, done)
+	c1, r1 := m.isop(m.And(l1, m.Not(u0)), u1, done)
+	lstar := m.Or(m.And(l0, m.Not(r0)), m.And(l1, m.Not(r1)))
+	cs, rs := m.isop(lstar, m.And(u0, u1), done)
+
+	var cubes [][]literal
+	for _, c := range c0 {
+		cubes = append(cubes, append([]literal{{l, true}}, c...))
+	}
+	for _, c := range c1 {
+		cubes = append(cubes, append([]literal{{l, false}}, c...))
+	}
+	cubes = append(cubes, cs...)
+	r := m.mk(l, m.Or(r0, rs), m.Or(r1, rs))
+
+	done[k] = cover{cubes, r}
+	return cubes, r
+}
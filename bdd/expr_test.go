@@ -0,0 +1,133 @@
+package bdd
+
+import (
+	"testing"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+func mux2(sel, a, b expr.Expr) expr.Expr {
+	return &expr.IfExpr{Cond: sel, If: b, Else: a}
+}
+
+// vectors checks e against f for every assignment of the Vars vars.
+func vectors(t *testing.T, m *Manager, f Node, e expr.Expr, vars ...*expr.Var) {
+	n := 0
+	for _, g := range []Node{f, m.Not(f)} {
+		m.AllSat(g, func(assignment map[string]bool) bool {
+			for _, v := range vars {
+				v.Value = expr.NewVector(1, 0)
+				if assignment[v.Name] {
+					v.Value = expr.NewVector(1, 1)
+				}
+			}
+			if e.Eval().True() != (g == f) {
+				t.Fatal(expr.Format(e), assignment)
+			}
+			n++
+			return true
+		})
+	}
+	if n != 1<<uint(len(m.Vars())) {
+		t.Fatal(n)
+	}
+}
+
+func TestMux2(t *testing.T) {
+	sel, a, b := expr.NewVar("Sel", expr.F), expr.NewVar("A", expr.F), expr.NewVar("B", expr.F)
+	m := New()
+	f, err := m.FromExpr(mux2(sel, a, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := m.FromExpr(expr.Or(expr.And(sel, b), expr.And(expr.Not(sel), a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != g {
+		t.Fatal("not equivalent")
+	}
+	vectors(t, m, f, mux2(sel, a, b), sel, a, b)
+}
+
+func TestMux4(t *testing.T) {
+	var in [4]*expr.Var
+	for i := range in {
+		in[i] = expr.NewVar(string(rune('A'+i)), expr.F)
+	}
+	s0, s1 := expr.NewVar("S0", expr.F), expr.NewVar("S1", expr.F)
+	e := mux2(s1, mux2(s0, in[0], in[1]), mux2(s0, in[2], in[3]))
+	m, f, err := Build(e, Sifting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SatCount(f).Int64() != 32 {
+		t.Fatal(m.SatCount(f))
+	}
+	vectors(t, m, f, e, in[0], in[1], in[2], in[3], s0, s1)
+}
+
+func TestFromExprVector(t *testing.T) {
+	a := expr.NewVar("a", expr.NewVector(4, 0))
+	m := New()
+	f, err := m.FromExpr(expr.RedXor(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := m.FromExpr(expr.BitXor(expr.BitXor(expr.Index(a, expr.NewVector(8, 0)), expr.Slice(a, 1, 1)),
+		expr.BitXor(expr.Index(a, expr.NewVector(8, 2)), expr.Index(a, expr.NewVector(8, 3)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != g || m.SatCount(f).Int64() != 8 {
+		t.Fatal(m.Vars(), m.SatCount(f))
+	}
+	if vars := m.Vars(); len(vars) != 4 || vars[3] != "a[3]" {
+		t.Fatal(vars)
+	}
+}
+
+func TestFromExprErrors(t *testing.T) {
+	a := expr.NewVar("a", expr.NewVector(4, 0))
+	b := expr.NewVar("b", expr.F)
+	for _, e := range []expr.Expr{
+		a,
+		expr.Add(b, b),
+		expr.Index(a, expr.NewVector(8, 4)),
+		expr.Index(a, b),
+		expr.Slice(a, 2, 1),
+		expr.NewVector(8, 1),
+	} {
+		if _, err := New().FromExpr(e); err == nil {
+			t.Fatal(expr.Format(e))
+		}
+	}
+}
+
+func TestExpr(t *testing.T) {
+	sel, a, b, c := expr.NewVar("Sel", expr.F), expr.NewVar("A", expr.F), expr.NewVar("B", expr.F), expr.NewVar("C", expr.F)
+	for _, c := range []struct {
+		e    expr.Expr
+		want string
+	}{
+		{expr.And(a, expr.Or(a, b)), "A"},
+		{expr.Or(expr.And(a, b), expr.And(a, expr.Not(b))), "A"},
+		{expr.And(a, expr.Not(a)), "1'b0"},
+		{expr.BitXor(a, b), "A ^ B"},
+		{mux2(sel, a, b), "Sel ? B : A"},
+		{expr.Or(expr.And(a, b), c), "A && B || C"},
+	} {
+		m := New()
+		f, err := m.FromExpr(c.e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := m.Expr(f)
+		if s := expr.Format(e); s != c.want {
+			t.Errorf("%s: got %s, want %s", expr.Format(c.e), s, c.want)
+		}
+		if g, err := m.FromExpr(e); err != nil || g != f {
+			t.Fatal(expr.Format(e), err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package bdd
+
+import (
+	"sort"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+// An Ordering returns a variable order for the diagram of an expression.
+type Ordering func(e expr.Expr) ([]string, error)
+
+// DepthFirst orders the variables of e as they are first found from left
+// to right, which keeps variables of the same subexpression together.
+func DepthFirst(e expr.Expr) ([]string, error) {
+	m := New()
+	if _, err := m.FromExpr(e); err != nil {
+		return nil, err
+	}
+	return m.Vars(), nil
+}
+
+// ByFrequency places the variables referenced most often by e on top,
+// keeping the depth-first order among equally used ones.
+func ByFrequency(e expr.Expr) ([]string, error) {
+	m := New()
+	if _, err := m.FromExpr(e); err != nil {
+		return nil, err
+	}
+	order := m.Vars()
+	sort.SliceStable(order, func(i, j int) bool {
+		return m.uses[m.levels[order[i]]] > m.uses[m.levels[order[j]]]
+	})
+	return order, nil
+}
+
+// Sifting starts from the ByFrequency order and moves each variable, most
+// used first, to the position where the diagram of e is smallest.
+func Sifting(e expr.Expr) ([]string, error) {
+	order, err := ByFrequency(e)
+	if err != nil {
+		return nil, err
+	}
+	best, err := sizeWith(e, order)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range append([]string(nil), order...) {
+		rest := remove(order, v)
+		for i := 0; i <= len(rest); i++ {
+			candidate := insert(rest, i, v)
+			size, _ := sizeWith(e, candidate)
+			if size < best {
+				best, order = size, candidate
+			}
+		}
+	}
+	return order, nil
+}
+
+func sizeWith(e expr.Expr, order []string) (int, error) {
+	m := New(order...)
+	f, err := m.FromExpr(e)
+	if err != nil {
+		return 0, err
+	}
+	return m.Size(f), nil
+}
+
+func remove(order []string, v string) []string {
+	var r []string
+	for _, x := range order {
+		if x != v {
+			r = append(r, x)
+		}
+	}
+	return r
+}
+
+func insert(order []string, i int, v string) []string {
+	r := append([]string(nil), order[:i]...)
+	r = append(r, v)
+	return append(r, order[i:]...)
+}
+
+// Build returns the diagram of e in a new Manager whose variable order is
+// given by ordering.
+func Build(e expr.Expr, ordering Ordering) (*Manager, Node, error) {
+	order, err := ordering(e)
+	if err != nil {
+		return nil, False, err
+	}
+	m := New(order...)
+	f, err := m.FromExpr(e)
+	return m, f, err
+}
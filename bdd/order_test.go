@@ -0,0 +1,51 @@
+package bdd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+// pairs returns a0 && b0 || a1 && b1 || ..., whose diagram is linear when
+// each ai is next to bi and exponential when all a come first.
+func pairs(n int) (expr.Expr, []string) {
+	var e expr.Expr
+	var bad []string
+	for i := 0; i < n; i++ {
+		a := expr.NewVar(fmt.Sprint("a", i), expr.F)
+		b := expr.NewVar(fmt.Sprint("b", i), expr.F)
+		if e == nil {
+			e = expr.And(a, b)
+		} else {
+			e = expr.Or(e, expr.And(a, b))
+		}
+		bad = append(bad, a.Name)
+	}
+	for i := 0; i < n; i++ {
+		bad = append(bad, fmt.Sprint("b", i))
+	}
+	return e, bad
+}
+
+func TestOrdering(t *testing.T) {
+	e, bad := pairs(4)
+	badSize, _ := sizeWith(e, bad)
+	for _, ordering := range []Ordering{DepthFirst, ByFrequency, Sifting} {
+		m, f, err := Build(e, ordering)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Size(f) != 8 || badSize <= 8 {
+			t.Fatal(m.Vars(), m.Size(f), badSize)
+		}
+	}
+}
+
+func TestByFrequency(t *testing.T) {
+	a, b, c := expr.NewVar("a", expr.F), expr.NewVar("b", expr.F), expr.NewVar("c", expr.F)
+	order, err := ByFrequency(expr.Or(expr.And(a, b), expr.And(expr.Not(b), expr.Or(c, b))))
+	if err != nil || fmt.Sprint(order) != "[b a c]" {
+		t.Fatal(order, err)
+	}
+}
@@ -9,6 +9,11 @@ const (
 	IntType
 )
 
+// Signed reports whether values of the type are two's complement numbers.
+func (dt DataType) Signed() bool {
+	return dt == IntType
+}
+
 var basicTypeToDataType = map[string]DataType{
 	"bool": BoolType,
 	"uint": UintType,
@@ -0,0 +1,410 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the width and signedness of the value of an expression.
+type Type struct {
+	Width  uint
+	Signed bool
+}
+
+func (t Type) String() string {
+	if t.Signed {
+		return fmt.Sprintf("signed [%d]", t.Width)
+	}
+	return fmt.Sprintf("[%d]", t.Width)
+}
+
+func TypeOf(v Value) Type {
+	return Type{v.Width(), v.Signed()}
+}
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a problem found by Check in the node Expr.
+type Diagnostic struct {
+	Severity
+	Expr Expr
+	Msg  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v: %s: %s", d.Severity, Format(d.Expr), d.Msg)
+}
+
+// Errors returns the diagnostics which are errors.
+func Errors(diags []Diagnostic) []Diagnostic {
+	var errs []Diagnostic
+	for _, d := range diags {
+		if d.Severity == Error {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// Check infers the self-determined type of e, following the Verilog width
+// and signedness rules, and reports the constructs of e which are invalid
+// or likely mistakes: implicit extensions, mixed signedness, vectors used
+// as booleans, booleans extended into vectors, out of range selects and
+// cycles. Expressions with errors must not be simulated nor emitted.
+func Check(e Expr) (Type, []Diagnostic) {
+	c := &checker{types: make(map[Expr]Type), visiting: make(map[Expr]bool)}
+	t := c.check(e)
+	return t, c.diags
+}
+
+// CheckAssign checks e as the right hand side of an assignment to a signal
+// of type t. Besides the diagnostics of Check, it reports the truncation of
+// wider values and the operations which Verilog evaluates with the width of
+// the assignment, keeping carries which Eval discards.
+func CheckAssign(t Type, e Expr) []Diagnostic {
+	c := &checker{types: make(map[Expr]Type), visiting: make(map[Expr]bool)}
+	et := c.check(e)
+	if len(Errors(c.diags)) > 0 {
+		return c.diags
+	}
+	if et.Width > t.Width {
+		c.warnf(e, "value of %d bits truncated to %d bits", et.Width, t.Width)
+	} else if et.Width < t.Width {
+		c.context(e, t.Width, make(map[Expr]bool))
+	}
+	return c.diags
+}
+
+// Sized returns e with the size casts which make Verilog compute the value
+// of Eval when e is assigned to a signal of type t. Verilog evaluates the
+// operators with the width of the widest operand of the whole expression,
+// including the signal, and as unsigned numbers if any operand is unsigned,
+// while Eval takes the width and the sign of the operands of each operator.
+// The operators which would be evaluated differently are cast to their own
+// width, e.g. a + b becomes 8'(a + b) when a and b have 8 bits and the signal
+// 16. The original tree is left untouched, and e is returned as is if Check
+// reports errors.
+func Sized(t Type, e Expr) Expr {
+	c := &checker{types: make(map[Expr]Type), visiting: make(map[Expr]bool)}
+	et := c.check(e)
+	if len(Errors(c.diags)) > 0 {
+		return e
+	}
+	s := &sizer{c.types, make(map[sizedExpr]Expr)}
+	return s.size(e, Type{max(t.Width, et.Width), et.Signed})
+}
+
+type sizer struct {
+	types map[Expr]Type
+	done  map[sizedExpr]Expr
+}
+
+type sizedExpr struct {
+	e   Expr
+	ctx Type
+}
+
+// size returns e evaluated with the width and the sign of ctx.
+func (s *sizer) size(e Expr, ctx Type) Expr {
+	k := sizedExpr{e, ctx}
+	if r, ok := s.done[k]; ok {
+		return r
+	}
+	var r Expr
+	if t := s.types[e]; t != ctx && contextDetermined(e) {
+		r = &SizeExpr{s.operands(e, t), t.Width}
+	} else {
+		r = s.operands(e, ctx)
+	}
+	s.done[k] = r
+	return r
+}
+
+// contextDetermined reports whether Verilog evaluates the operator e with
+// the width and the sign of the expression around it.
+func contextDetermined(e Expr) bool {
+	switch e := e.(type) {
+	case *UnaryExpr:
+		return e.Op == OpBitNot || e.Op == OpNeg
+	case *BinaryExpr:
+		return e.Op.Width(0, 0) != 1
+	case *IfExpr:
+		return true
+	case Value:
+		// negative literals are negations in Verilog, e.g. -3'sd1
+		return strings.HasPrefix(literal(e), "-")
+	}
+	return false
+}
+
+// operands returns e with its operands sized for the evaluation of e with
+// the width and the sign of ctx.
+func (s *sizer) operands(e Expr, ctx Type) Expr {
+	children := Children(e)
+	if len(children) == 0 {
+		return e
+	}
+	sized := make([]Expr, len(children))
+	for i, x := range children {
+		xctx := s.types[x] // self-determined
+		switch e := e.(type) {
+		case *UnaryExpr:
+			if contextDetermined(e) {
+				xctx = ctx
+			}
+		case *BinaryExpr:
+			switch {
+			case e.Op == OpAnd, e.Op == OpOr:
+			case e.Op.Width(0, 0) == 1:
+				// comparisons evaluate their operands together
+				t1, t2 := s.types[e.Expr1], s.types[e.Expr2]
+				xctx = Type{max(t1.Width, t2.Width), t1.Signed && t2.Signed}
+			case i == 0 || e.Op != OpShl && e.Op != OpShr && e.Op != OpAShl && e.Op != OpAShr:
+				xctx = ctx
+			}
+		case *IfExpr:
+			if i > 0 {
+				xctx = ctx
+			}
+		case *SizeExpr:
+			xctx.Width = max(xctx.Width, e.Width)
+		}
+		sized[i] = s.size(x, xctx)
+	}
+	return WithChildren(e, sized)
+}
+
+type checker struct {
+	types    map[Expr]Type
+	visiting map[Expr]bool
+	diags    []Diagnostic
+}
+
+func (c *checker) warnf(e Expr, format string, args ...interface{}) {
+	c.diags = append(c.diags, Diagnostic{Warning, e, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) errorf(e Expr, format string, args ...interface{}) {
+	c.diags = append(c.diags, Diagnostic{Error, e, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) check(e Expr) Type {
+	if e == nil {
+		c.errorf(e, "missing operand")
+		return Type{}
+	}
+	if t, ok := c.types[e]; ok {
+		return t
+	}
+	if c.visiting[e] {
+		c.errorf(e, "expression depends on itself")
+		return Type{}
+	}
+	c.visiting[e] = true
+	t := c.infer(e)
+	delete(c.visiting, e)
+	c.types[e] = t
+	return t
+}
+
+// boolean reports whether an operand of type t is meant as a truth value.
+func (c *checker) boolean(e Expr, t Type) bool {
+	if v, ok := e.(*Var); ok {
+		e = v.Value
+	}
+	_, ok := e.(*Bool)
+	return ok || t.Width == 1 && boolean(e)
+}
+
+func (c *checker) condition(e Expr, t Type, what string) {
+	if t.Width > 1 {
+		c.warnf(e, "%d-bit vector used as %s", t.Width, what)
+	}
+}
+
+// operands checks the extension of the operands of an operator whose
+// operands are evaluated together with the given width. Extending a
+// constant keeps its value, so it is not reported.
+func (c *checker) operands(e Expr, op string, width uint, signed bool, xs ...Expr) {
+	for _, x := range xs {
+		t := c.types[x]
+		_, constant := x.(Value)
+		if t.Width < width && !constant {
+			if c.boolean(x, t) {
+				c.warnf(x, "boolean operand of %s extended to %d bits", op, width)
+			} else {
+				c.warnf(x, "operand of %s extended from %d to %d bits", op, t.Width, width)
+			}
+		}
+		if t.Signed && !signed {
+			c.warnf(x, "signed operand of %s treated as unsigned", op)
+		}
+	}
+}
+
+func (c *checker) infer(e Expr) Type {
+	switch e := e.(type) {
+	case Value:
+		return TypeOf(e)
+	case *Var:
+		if e.Value == nil {
+			c.errorf(e, "signal %s has no value", e.Name)
+			return Type{}
+		}
+		return TypeOf(e.Value)
+	case *UnaryExpr:
+		t := c.check(e.Expr)
+		switch e.Op {
+		case OpNot:
+			c.condition(e.Expr, t, "operand of !")
+			return Type{1, false}
+		case OpBitNot, OpNeg:
+			return t
+		}
+		return Type{1, false}
+	case *BinaryExpr:
+		t1, t2 := c.check(e.Expr1), c.check(e.Expr2)
+		op := e.Op.String()
+		switch e.Op {
+		case OpAnd, OpOr:
+			c.condition(e.Expr1, t1, "operand of "+op)
+			c.condition(e.Expr2, t2, "operand of "+op)
+			return Type{1, false}
+		case OpShl, OpShr, OpAShl, OpAShr:
+			if v, ok := e.Expr2.(Value); ok && !unknown(v) && shiftAmount(vectorOf(v).WithSign(false)) >= t1.Width {
+				c.warnf(e, "shift by %v discards all %d bits", vectorOf(v).Big(), t1.Width)
+			}
+			if t2.Signed {
+				c.warnf(e.Expr2, "signed shift amount treated as unsigned")
+			}
+			return t1
+		case OpDiv, OpMod:
+			if v, ok := e.Expr2.(Value); ok && !unknown(v) && !v.True() {
+				c.warnf(e, "division by zero gives X")
+			}
+		}
+		signed := t1.Signed && t2.Signed
+		width := max(t1.Width, t2.Width)
+		c.operands(e, op, width, signed, e.Expr1, e.Expr2)
+		return Type{e.Op.Width(t1.Width, t2.Width), signed && e.Op.Width(0, 0) != 1}
+	case *IfExpr:
+		tc := c.check(e.Cond)
+		t1, t2 := c.check(e.If), c.check(e.Else)
+		c.condition(e.Cond, tc, "condition")
+		signed := t1.Signed && t2.Signed
+		width := max(t1.Width, t2.Width)
+		if t1.Width != t2.Width {
+			c.warnf(e, "branches of ?: have %d and %d bits", t1.Width, t2.Width)
+		} else {
+			c.operands(e, "?:", width, signed, e.If, e.Else)
+		}
+		return Type{width, signed}
+	case *SliceExpr:
+		t := c.check(e.Expr)
+		if e.High < e.Low {
+			c.errorf(e, "slice [%d:%d] is reversed", e.High, e.Low)
+			return Type{}
+		}
+		if e.High >= t.Width {
+			c.errorf(e, "slice [%d:%d] out of range of %d bits", e.High, e.Low, t.Width)
+		}
+		return Type{e.High - e.Low + 1, false}
+	case *IndexExpr:
+		t := c.check(e.Expr)
+		ti := c.check(e.Index)
+		if v, ok := e.Index.(Value); ok && !unknown(v) {
+			if i := shiftAmount(vectorOf(v).WithSign(false)); i >= t.Width {
+				c.errorf(e, "index %d out of range of %d bits", i, t.Width)
+			}
+		}
+		if ti.Signed {
+			c.warnf(e.Index, "signed index treated as unsigned")
+		}
+		return Type{1, false}
+	case *ConcatExpr:
+		var width uint
+		for _, x := range e.Exprs {
+			width += c.check(x).Width
+		}
+		if len(e.Exprs) == 0 {
+			c.errorf(e, "empty concatenation")
+		}
+		return Type{width, false}
+	case *ReplicateExpr:
+		t := c.check(e.Expr)
+		if e.Count == 0 {
+			c.errorf(e, "replication count must be positive")
+		}
+		return Type{t.Width * e.Count, false}
+	case *CastExpr:
+		t := c.check(e.Expr)
+		return Type{t.Width, e.Signed}
+	case *SizeExpr:
+		t := c.check(e.Expr)
+		if e.Width == 0 {
+			c.errorf(e, "size cast to 0 bits")
+			return Type{}
+		}
+		if t.Width < e.Width {
+			c.context(e.Expr, e.Width, make(map[Expr]bool))
+		}
+		return Type{e.Width, t.Signed}
+	}
+	c.errorf(e, "cannot infer the type of %T", e)
+	return Type{}
+}
+
+// context visits the operands whose width Verilog takes from the
+// assignment and reports where a wider evaluation changes the result.
+func (c *checker) context(e Expr, width uint, visited map[Expr]bool) {
+	if visited[e] {
+		return
+	}
+	visited[e] = true
+
+	t := c.types[e]
+	switch e := e.(type) {
+	case *UnaryExpr:
+		switch e.Op {
+		case OpNeg:
+			c.widened(e, t, width)
+			fallthrough
+		case OpBitNot:
+			c.context(e.Expr, width, visited)
+		}
+	case *BinaryExpr:
+		switch e.Op {
+		case OpAdd, OpSub, OpMul, OpShl, OpAShl:
+			c.widened(e, t, width)
+			fallthrough
+		case OpBitAnd, OpBitOr, OpBitXor, OpBitXnor, OpDiv, OpMod:
+			c.context(e.Expr1, width, visited)
+			if e.Op != OpShl && e.Op != OpAShl {
+				c.context(e.Expr2, width, visited)
+			}
+		case OpShr, OpAShr:
+			c.context(e.Expr1, width, visited)
+		}
+	case *IfExpr:
+		c.context(e.If, width, visited)
+		c.context(e.Else, width, visited)
+	}
+}
+
+func (c *checker) widened(e Expr, t Type, width uint) {
+	if t.Width < width {
+		c.warnf(e, "evaluated in %d bits, but Verilog uses the %d bits of the assignment", t.Width, width)
+	}
+}
@@ -0,0 +1,197 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func hasDiag(diags []Diagnostic, s Severity, msg string) bool {
+	for _, d := range diags {
+		if d.Severity == s && strings.Contains(d.Msg, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckTypes(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	b := NewVar("b", NewVector(8, 2))
+	s := NewVar("s", NewSigned(8, -1))
+	c := NewVar("c", T)
+
+	tests := []struct {
+		e Expr
+		t Type
+	}{
+		{a, Type{8, false}},
+		{Add(a, b), Type{8, false}},
+		{Add(s, s), Type{8, true}},
+		{Add(s, a), Type{8, false}},
+		{Less(s, s), Type{1, false}},
+		{And(c, c), Type{1, false}},
+		{RedXor(a), Type{1, false}},
+		{Neg(s), Type{8, true}},
+		{Shl(s, NewVector(3, 2)), Type{8, true}},
+		{Concat(a, b, c), Type{17, false}},
+		{Replicate(3, a), Type{24, false}},
+		{Slice(a, 3, 0), Type{4, false}},
+		{Index(a, NewVector(3, 7)), Type{1, false}},
+		{AsSigned(a), Type{8, true}},
+		{&IfExpr{c, s, s}, Type{8, true}},
+		{&IfExpr{c, a, Concat(a, b)}, Type{16, false}},
+	}
+	for _, test := range tests {
+		typ, diags := Check(test.e)
+		if typ != test.t {
+			t.Fatal(test.e, typ, test.t)
+		}
+		if len(Errors(diags)) > 0 {
+			t.Fatal(diags)
+		}
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	n := NewVar("n", NewVector(4, 1))
+	s := NewVar("s", NewSigned(8, -1))
+	c := NewVar("c", T)
+
+	tests := []struct {
+		e   Expr
+		msg string
+	}{
+		{Add(a, n), "operand of + extended from 4 to 8 bits"},
+		{BitAnd(c, a), "boolean operand of & extended to 8 bits"},
+		{Add(s, a), "signed operand of + treated as unsigned"},
+		{And(a, c), "8-bit vector used as operand of &&"},
+		{Not(a), "8-bit vector used as operand of !"},
+		{&IfExpr{a, c, c}, "8-bit vector used as condition"},
+		{&IfExpr{c, a, n}, "branches of ?: have 8 and 4 bits"},
+		{Shl(a, NewVector(4, 8)), "shift by 8 discards all 8 bits"},
+		{Shr(a, s), "signed shift amount"},
+		{Div(a, NewVector(8, 0)), "division by zero"},
+	}
+	for _, test := range tests {
+		_, diags := Check(test.e)
+		if !hasDiag(diags, Warning, test.msg) {
+			t.Fatal(test.msg, diags)
+		}
+		if len(Errors(diags)) > 0 {
+			t.Fatal(diags)
+		}
+	}
+
+	// matching operands and constants are fine
+	if _, diags := Check(Add(a, NewVar("b", NewVector(8, 0)))); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if _, diags := Check(Add(a, NewVector(4, 1))); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+
+	cycle := &BinaryExpr{a, nil, OpAdd}
+	cycle.Expr2 = cycle
+
+	tests := []struct {
+		e   Expr
+		msg string
+	}{
+		{NewVar("x", nil), "signal x has no value"},
+		{&BinaryExpr{a, nil, OpAdd}, "missing operand"},
+		{&SliceExpr{a, 8, 4}, "out of range"},
+		{&SliceExpr{a, 2, 4}, "reversed"},
+		{Index(a, NewVector(4, 9)), "index 9 out of range"},
+		{&ConcatExpr{}, "empty concatenation"},
+		{&ReplicateExpr{a, 0}, "replication count"},
+		{cycle, "depends on itself"},
+	}
+	for _, test := range tests {
+		if _, diags := Check(test.e); !hasDiag(diags, Error, test.msg) {
+			t.Fatal(test.msg, diags)
+		}
+	}
+}
+
+func TestCheckAssign(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	b := NewVar("b", NewVector(8, 2))
+
+	diags := CheckAssign(Type{4, false}, a)
+	if !hasDiag(diags, Warning, "value of 8 bits truncated to 4 bits") {
+		t.Fatal(diags)
+	}
+
+	// the carry is kept by Verilog, not by Eval
+	diags = CheckAssign(Type{9, false}, BitOr(Add(a, b), b))
+	if !hasDiag(diags, Warning, "evaluated in 8 bits") {
+		t.Fatal(diags)
+	}
+
+	// concatenation operands are self-determined
+	if diags := CheckAssign(Type{9, false}, Concat(Add(a, b))); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if diags := CheckAssign(Type{8, false}, Add(a, b)); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+
+	// a size cast as wide as its operand keeps it from the assignment,
+	// but a wider one does not
+	if diags := CheckAssign(Type{9, false}, Size(8, Add(a, b))); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if diags := CheckAssign(Type{8, false}, Size(9, Add(a, b))); !hasDiag(diags, Warning, "evaluated in 8 bits") {
+		t.Fatal(diags)
+	}
+}
+
+func TestSized(t *testing.T) {
+	a := NewVar("a", NewVector(8, 200))
+	b := NewVar("b", NewVector(8, 100))
+	c := NewVar("c", NewVector(16, 1))
+	s := NewVar("s", NewSigned(8, -16))
+
+	for _, tc := range []struct {
+		t    Type
+		e    Expr
+		repr string
+	}{
+		{Type{8, false}, Add(a, b), "a + b"},
+		{Type{16, false}, Add(a, b), "8'(a + b)"},
+		{Type{16, false}, BitNot(a), "8'(~a)"},
+		{Type{16, false}, Add(Add(a, b), c), "8'(a + b) + c"},
+		{Type{1, false}, Less(Add(a, b), c), "8'(a + b) < c"},
+		{Type{16, false}, Concat(Add(a, b)), "{a + b}"},
+		{Type{8, false}, Add(AShr(s, NewVector(2, 1)), a), "8'(s >>> 2'd1) + a"},
+		{Type{16, false}, &IfExpr{Less(a, b), Sub(a, b), c}, "a < b ? 8'(a - b) : c"},
+		{Type{4, false}, Size(16, Add(a, b)), "16'(8'(a + b))"},
+		{Type{1, false}, Less(c, NewSigned(3, -1)), "c < 3'(-3'sd1)"},
+	} {
+		r := Sized(tc.t, tc.e)
+		if repr := Format(r); repr != tc.repr {
+			t.Fatal(repr, tc.repr)
+		}
+		if !Eq(r.Eval(), tc.e.Eval()) {
+			t.Fatal(tc.repr, r.Eval(), tc.e.Eval())
+		}
+		// Verilog evaluates the result as Eval does
+		for _, d := range CheckAssign(tc.t, r) {
+			if strings.Contains(d.Msg, "Verilog uses") {
+				t.Fatal(tc.repr, d)
+			}
+		}
+	}
+
+	// the original tree is kept
+	e := Add(Add(a, b), c)
+	Sized(Type{16, false}, e)
+	if _, ok := e.Expr1.(*BinaryExpr); !ok {
+		t.Fatal(Format(e))
+	}
+}
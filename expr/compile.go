@@ -0,0 +1,582 @@
+package expr
+
+import "math/bits"
+
+// Program is an expression compiled by Compile to a list of instructions
+// over preallocated four-state registers. Each node of the expression has
+// a single register, so shared subexpressions are evaluated once per Run,
+// and running a program does not allocate.
+type Program struct {
+	regs  []LogicVector
+	code  []instr
+	loads []load
+	out   int
+}
+
+type opcode uint8
+
+const (
+	opUnary opcode = iota
+	opBinary
+	opIf
+	opSlice
+	opIndex
+	opConcat
+	opReplicate
+	opExtend
+)
+
+// instr computes the register dst from the registers a, b and c. Operands
+// of arithmetic, bitwise and comparison operators are extended to the same
+// width by previous instructions, so they only need to know whether the
+// operands are signed.
+type instr struct {
+	code    opcode
+	op      Op
+	signed  bool
+	dst     int
+	a, b, c int
+	args    []int // concatenated registers or scratch registers
+	n       uint  // low bit of a slice or replication count
+}
+
+// load copies the value of a Var into a register before each run.
+type load struct {
+	v   *Var
+	dst int
+}
+
+// Compile lowers e into a Program. The width and signedness of every node
+// are taken from Check, and Compile panics if e has errors. Values are
+// copied into the program once, while the Value of each Var is read again
+// by every Run, so a Var must keep its width between runs.
+func Compile(e Expr) *Program {
+	check := &checker{types: make(map[Expr]Type), visiting: make(map[Expr]bool)}
+	check.check(e)
+	if errs := Errors(check.diags); len(errs) > 0 {
+		panic(errs[0].String())
+	}
+
+	c := &compiler{types: check.types, regs: make(map[Expr]int), consts: make(map[int]Value)}
+	out := c.compile(e)
+
+	var words uint
+	for _, t := range c.regTypes {
+		words += 2 * numWords(t.Width)
+	}
+	arena := make([]uint64, words)
+	p := &Program{regs: make([]LogicVector, len(c.regTypes)), code: c.code, loads: c.loads, out: out}
+	for i, t := range c.regTypes {
+		n := numWords(t.Width)
+		p.regs[i] = LogicVector{arena[:n:n], arena[n : 2*n : 2*n], t.Width, t.Signed}
+		arena = arena[2*n:]
+	}
+	for dst, v := range c.consts {
+		loadValue(&p.regs[dst], v)
+	}
+	return p
+}
+
+type compiler struct {
+	types    map[Expr]Type
+	regs     map[Expr]int // register of each compiled node
+	regTypes []Type
+	code     []instr
+	consts   map[int]Value
+	loads    []load
+}
+
+func (c *compiler) reg(t Type) int {
+	c.regTypes = append(c.regTypes, t)
+	return len(c.regTypes) - 1
+}
+
+// operand returns the register of x extended to width bits.
+func (c *compiler) operand(x Expr, width uint, signed bool) int {
+	r := c.compile(x)
+	t := c.types[x]
+	if t.Width >= width {
+		return r
+	}
+	dst := c.reg(Type{width, t.Signed})
+	c.code = append(c.code, instr{code: opExtend, signed: signed, dst: dst, a: r})
+	return dst
+}
+
+func (c *compiler) compile(e Expr) int {
+	if r, ok := c.regs[e]; ok {
+		return r
+	}
+
+	t := c.types[e]
+	in := instr{}
+	switch e := e.(type) {
+	case Value:
+		r := c.reg(t)
+		c.consts[r] = e
+		c.regs[e] = r
+		return r
+	case *Var:
+		r := c.reg(t)
+		c.loads = append(c.loads, load{e, r})
+		c.regs[e] = r
+		return r
+	case *UnaryExpr:
+		in = instr{code: opUnary, op: e.Op, a: c.compile(e.Expr)}
+	case *BinaryExpr:
+		t1, t2 := c.types[e.Expr1], c.types[e.Expr2]
+		in = instr{code: opBinary, op: e.Op, signed: t1.Signed && t2.Signed}
+		switch e.Op {
+		case OpAnd, OpOr:
+			in.a, in.b = c.compile(e.Expr1), c.compile(e.Expr2)
+		case OpShl, OpShr, OpAShl, OpAShr:
+			in.a, in.b = c.compile(e.Expr1), c.compile(e.Expr2)
+			in.signed = t1.Signed
+		default:
+			w := max(t1.Width, t2.Width)
+			in.a, in.b = c.operand(e.Expr1, w, in.signed), c.operand(e.Expr2, w, in.signed)
+			if e.Op == OpDiv || e.Op == OpMod {
+				// quotient, remainder and magnitudes of the operands
+				scratch := Type{w, false}
+				in.args = []int{c.reg(scratch), c.reg(scratch), c.reg(scratch), c.reg(scratch)}
+			}
+		}
+	case *IfExpr:
+		in = instr{code: opIf, a: c.compile(e.Cond)}
+		in.b = c.operand(e.If, t.Width, t.Signed)
+		in.c = c.operand(e.Else, t.Width, t.Signed)
+	case *SliceExpr:
+		in = instr{code: opSlice, a: c.compile(e.Expr), n: e.Low}
+	case *IndexExpr:
+		in = instr{code: opIndex, a: c.compile(e.Expr), b: c.compile(e.Index)}
+	case *ConcatExpr:
+		in = instr{code: opConcat, args: make([]int, len(e.Exprs))}
+		for i, x := range e.Exprs {
+			in.args[i] = c.compile(x)
+		}
+	case *ReplicateExpr:
+		in = instr{code: opReplicate, a: c.compile(e.Expr), n: e.Count}
+	case *CastExpr:
+		in = instr{code: opExtend, a: c.compile(e.Expr)}
+	case *SizeExpr:
+		in = instr{code: opExtend, a: c.compile(e.Expr), signed: t.Signed}
+	}
+	in.dst = c.reg(t)
+	c.code = append(c.code, in)
+	c.regs[e] = in.dst
+	return in.dst
+}
+
+// Run evaluates the program with the current values of its Vars. The
+// result is a four-state vector owned by the program, which is overwritten
+// by the next Run.
+func (p *Program) Run() Value {
+	for _, l := range p.loads {
+		loadValue(&p.regs[l.dst], l.v.Value)
+	}
+	for i := range p.code {
+		p.exec(&p.code[i])
+	}
+	return &p.regs[p.out]
+}
+
+func (p *Program) exec(in *instr) {
+	dst := &p.regs[in.dst]
+	switch in.code {
+	case opUnary:
+		unaryInto(in.op, dst, &p.regs[in.a])
+	case opBinary:
+		p.binary(in, dst, &p.regs[in.a], &p.regs[in.b])
+	case opIf:
+		switch p.regs[in.a].reduceOr() {
+		case L1:
+			copyReg(dst, &p.regs[in.b])
+		case L0:
+			copyReg(dst, &p.regs[in.c])
+		default:
+			mergeInto(dst, &p.regs[in.b], &p.regs[in.c])
+		}
+	case opSlice:
+		src := &p.regs[in.a]
+		copyBits(dst.aval, 0, src.aval, in.n, dst.width)
+		copyBits(dst.bval, 0, src.bval, in.n, dst.width)
+		if in.n+dst.width > src.width {
+			// bits past the width of the operand read as X
+			from := uint(0)
+			if src.width > in.n {
+				from = src.width - in.n
+			}
+			fillBits(dst.aval, from, dst.width)
+			fillBits(dst.bval, from, dst.width)
+		}
+	case opIndex:
+		src, index := &p.regs[in.a], &p.regs[in.b]
+		if !index.Known() {
+			setLogic(dst, LX)
+			break
+		}
+		n := shiftAmount(&Vector{index.aval, index.width, false})
+		if n >= src.width {
+			setLogic(dst, LX)
+			break
+		}
+		setLogic(dst, src.Bit(n))
+	case opConcat:
+		off := dst.width
+		for _, r := range in.args {
+			src := &p.regs[r]
+			off -= src.width
+			copyBits(dst.aval, off, src.aval, 0, src.width)
+			copyBits(dst.bval, off, src.bval, 0, src.width)
+		}
+	case opReplicate:
+		src := &p.regs[in.a]
+		for i := uint(0); i < in.n; i++ {
+			copyBits(dst.aval, i*src.width, src.aval, 0, src.width)
+			copyBits(dst.bval, i*src.width, src.bval, 0, src.width)
+		}
+	case opExtend:
+		src := &p.regs[in.a]
+		extendInto(dst, src.aval, src.bval, src.width, in.signed)
+	}
+}
+
+func unaryInto(op Op, dst, a *LogicVector) {
+	switch op {
+	case OpNot:
+		setLogic(dst, logicNot(a.reduceOr()))
+	case OpRedAnd, OpRedOr, OpRedXor:
+		setLogic(dst, reduce(op, a))
+	case OpRedNand:
+		setLogic(dst, logicNot(reduce(OpRedAnd, a)))
+	case OpRedNor:
+		setLogic(dst, logicNot(reduce(OpRedOr, a)))
+	case OpRedXnor:
+		setLogic(dst, logicNot(reduce(OpRedXor, a)))
+	case OpBitNot:
+		for i := range dst.aval {
+			dst.aval[i] = ^a.aval[i] | a.bval[i]
+			dst.bval[i] = a.bval[i]
+		}
+		dst.truncate()
+	case OpNeg:
+		if !a.Known() {
+			setUnknown(dst)
+			return
+		}
+		var borrow uint64
+		for i := range dst.aval {
+			dst.aval[i], borrow = bits.Sub64(0, a.aval[i], borrow)
+			dst.bval[i] = 0
+		}
+		dst.truncate()
+	}
+}
+
+func (p *Program) binary(in *instr, dst, a, b *LogicVector) {
+	switch in.op {
+	case OpAnd:
+		setLogic(dst, logicAnd(a.reduceOr(), b.reduceOr()))
+		return
+	case OpOr:
+		setLogic(dst, logicOr(a.reduceOr(), b.reduceOr()))
+		return
+	case OpBitAnd, OpBitOr, OpBitXor, OpBitXnor:
+		bitwiseInto(in.op, dst, a, b)
+		return
+	case OpEq, OpNe:
+		eq := L1
+		for i := range a.aval {
+			if (a.aval[i]^b.aval[i])&^(a.bval[i]|b.bval[i]) != 0 {
+				eq = L0
+				break
+			}
+		}
+		if eq == L1 && !(a.Known() && b.Known()) {
+			eq = LX
+		}
+		if in.op == OpNe {
+			eq = logicNot(eq)
+		}
+		setLogic(dst, eq)
+		return
+	case OpShl, OpShr, OpAShl, OpAShr:
+		if !b.Known() {
+			setUnknown(dst)
+			return
+		}
+		n := shiftAmount(&Vector{b.aval, b.width, false})
+		shiftWords(in.op, dst.aval, a.aval, a.width, n, in.signed)
+		shiftWords(in.op, dst.bval, a.bval, a.width, n, in.signed)
+		dst.truncate()
+		return
+	}
+
+	if !a.Known() || !b.Known() {
+		setUnknown(dst)
+		return
+	}
+	switch in.op {
+	case OpLt, OpLe, OpGt, OpGe:
+		cmp := cmpVector(&Vector{a.aval, a.width, in.signed}, &Vector{b.aval, b.width, in.signed})
+		setLogic(dst, boolLogic(compare(in.op, cmp)))
+		return
+	case OpAdd:
+		var carry uint64
+		for i := range dst.aval {
+			dst.aval[i], carry = bits.Add64(a.aval[i], b.aval[i], carry)
+		}
+	case OpSub:
+		var borrow uint64
+		for i := range dst.aval {
+			dst.aval[i], borrow = bits.Sub64(a.aval[i], b.aval[i], borrow)
+		}
+	case OpMul:
+		mulWords(dst.aval, a.aval, b.aval)
+	case OpDiv, OpMod:
+		if !p.divide(in, dst, a, b) {
+			setUnknown(dst)
+			return
+		}
+	}
+	clear(dst.bval)
+	dst.truncate()
+}
+
+// divide computes the quotient or the remainder of a and b, truncated
+// towards zero. It reports false if b is zero.
+func (p *Program) divide(in *instr, dst, a, b *LogicVector) bool {
+	if isZero(b.aval) {
+		return false
+	}
+	w := a.width
+	x, y := a.aval, b.aval
+	negx := in.signed && a.Bit(w-1) == L1
+	negy := in.signed && b.Bit(w-1) == L1
+	if negx {
+		x = negWords(p.regs[in.args[2]].aval, x)
+		p.regs[in.args[2]].truncate()
+	}
+	if negy {
+		y = negWords(p.regs[in.args[3]].aval, y)
+		p.regs[in.args[3]].truncate()
+	}
+
+	q, r := p.regs[in.args[0]].aval, p.regs[in.args[1]].aval
+	divWords(q, r, x, y, w)
+	res, neg := q, negx != negy
+	if in.op == OpMod {
+		res, neg = r, negx
+	}
+	if neg {
+		negWords(dst.aval, res)
+	} else {
+		copy(dst.aval, res)
+	}
+	return true
+}
+
+func boolLogic(b bool) Logic {
+	if b {
+		return L1
+	}
+	return L0
+}
+
+func setLogic(dst *LogicVector, l Logic) {
+	clear(dst.aval)
+	clear(dst.bval)
+	dst.SetBit(0, l)
+}
+
+func setUnknown(dst *LogicVector) {
+	for i := range dst.aval {
+		dst.aval[i] = ^uint64(0)
+		dst.bval[i] = ^uint64(0)
+	}
+	dst.truncate()
+}
+
+func copyReg(dst, src *LogicVector) {
+	copy(dst.aval, src.aval)
+	copy(dst.bval, src.bval)
+}
+
+func mergeInto(dst, a, b *LogicVector) {
+	for i := range dst.aval {
+		differ := a.aval[i] ^ b.aval[i] | a.bval[i] | b.bval[i]
+		dst.aval[i] = a.aval[i] | differ
+		dst.bval[i] = differ
+	}
+}
+
+// loadValue copies v into dst, extending or truncating it to the width of
+// dst.
+func loadValue(dst *LogicVector, v Value) {
+	switch v := v.(type) {
+	case *Bool:
+		clear(dst.aval)
+		clear(dst.bval)
+		if *v {
+			dst.aval[0] = 1
+		}
+	case *Vector:
+		extendInto(dst, v.words, nil, v.width, v.signed)
+	case *LogicVector:
+		extendInto(dst, v.aval, v.bval, v.width, v.signed)
+	default:
+		lv := LogicOf(v)
+		extendInto(dst, lv.aval, lv.bval, lv.width, lv.signed)
+	}
+}
+
+// extendInto copies the width bits of aval and bval into dst. If signed is
+// set, the most significant bit is replicated up to the width of dst.
+func extendInto(dst *LogicVector, aval, bval []uint64, width uint, signed bool) {
+	for i := range dst.aval {
+		dst.aval[i], dst.bval[i] = 0, 0
+		if i < len(aval) {
+			dst.aval[i] = aval[i]
+		}
+		if i < len(bval) {
+			dst.bval[i] = bval[i]
+		}
+	}
+	if signed && width > 0 && width < dst.width {
+		if bitOf(aval, width-1) {
+			fillBits(dst.aval, width, dst.width)
+		}
+		if bitOf(bval, width-1) {
+			fillBits(dst.bval, width, dst.width)
+		}
+	}
+	dst.truncate()
+}
+
+func bitOf(words []uint64, i uint) bool {
+	return i/wordSize < uint(len(words)) && words[i/wordSize]>>(i%wordSize)&1 == 1
+}
+
+// fillBits sets the bits from up to, but not including, to.
+func fillBits(words []uint64, from, to uint) {
+	for i := from; i < to; {
+		w, off := i/wordSize, i%wordSize
+		n := min(wordSize-off, to-i)
+		words[w] |= ^uint64(0) >> (wordSize - n) << off
+		i += n
+	}
+}
+
+func isZero(words []uint64) bool {
+	for _, w := range words {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// negWords stores the two's complement of x in dst and returns dst.
+func negWords(dst, x []uint64) []uint64 {
+	var borrow uint64
+	for i := range dst {
+		dst[i], borrow = bits.Sub64(0, x[i], borrow)
+	}
+	return dst
+}
+
+// mulWords stores the lowest words of the product of x and y in dst.
+func mulWords(dst, x, y []uint64) {
+	clear(dst)
+	for i := range dst {
+		var carry uint64
+		for j := 0; i+j < len(dst); j++ {
+			hi, lo := bits.Mul64(x[i], y[j])
+			var c uint64
+			lo, c = bits.Add64(lo, dst[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			dst[i+j] = lo
+			carry = hi
+		}
+	}
+}
+
+// divWords computes the quotient q and the remainder r of the width bits
+// of the unsigned numbers x and y, which must not be zero.
+func divWords(q, r, x, y []uint64, width uint) {
+	if len(q) == 1 {
+		q[0], r[0] = x[0]/y[0], x[0]%y[0]
+		return
+	}
+	clear(q)
+	clear(r)
+	for i := int(width) - 1; i >= 0; i-- {
+		// shift the next bit of x into r; a bit shifted out of r makes
+		// it greater than y
+		out := bitOf(r, width-1)
+		var carry uint64
+		for j := range r {
+			r[j], carry = r[j]<<1|carry, r[j]>>(wordSize-1)
+		}
+		if rem := width % wordSize; rem != 0 {
+			r[len(r)-1] &= 1<<rem - 1
+		}
+		if bitOf(x, uint(i)) {
+			r[0] |= 1
+		}
+		if out || cmpVector(&Vector{r, width, false}, &Vector{y, width, false}) >= 0 {
+			var borrow uint64
+			for j := range r {
+				r[j], borrow = bits.Sub64(r[j], y[j], borrow)
+			}
+			if rem := width % wordSize; rem != 0 {
+				r[len(r)-1] &= 1<<rem - 1
+			}
+			q[i/wordSize] |= 1 << (uint(i) % wordSize)
+		}
+	}
+}
+
+// shiftWords shifts the width bits of src by n into dst. Arithmetic right
+// shifts of signed operands replicate the most significant bit.
+func shiftWords(op Op, dst, src []uint64, width, n uint, signed bool) {
+	fill := op == OpAShr && signed && bitOf(src, width-1)
+	if n >= width {
+		clear(dst)
+		if fill {
+			fillBits(dst, 0, width)
+		}
+		return
+	}
+
+	words, off := int(n/wordSize), n%wordSize
+	switch op {
+	case OpShl, OpAShl:
+		for i := len(dst) - 1; i >= 0; i-- {
+			var w uint64
+			if j := i - words; j >= 0 {
+				w = src[j] << off
+				if off > 0 && j > 0 {
+					w |= src[j-1] >> (wordSize - off)
+				}
+			}
+			dst[i] = w
+		}
+	case OpShr, OpAShr:
+		for i := range dst {
+			var w uint64
+			if j := i + words; j < len(src) {
+				w = src[j] >> off
+				if off > 0 && j+1 < len(src) {
+					w |= src[j+1] << (wordSize - off)
+				}
+			}
+			dst[i] = w
+		}
+		if fill {
+			fillBits(dst, width-n, width)
+		}
+	}
+}
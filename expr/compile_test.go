@@ -0,0 +1,238 @@
+package expr
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var genWidths = []uint{1, 3, 8, 31, 64, 65, 100, 130}
+
+// exprGen builds random well formed expressions over a set of vars.
+type exprGen struct {
+	r     *rand.Rand
+	vars  []*Var
+	nodes []Expr
+}
+
+func newExprGen(seed int64) *exprGen {
+	g := &exprGen{r: rand.New(rand.NewSource(seed))}
+	for i := 0; i < 6; i++ {
+		v := NewVar(string(rune('a'+i)), nil)
+		v.Value = g.value(genWidths[g.r.Intn(len(genWidths))], g.r.Intn(2) == 0)
+		g.vars = append(g.vars, v)
+	}
+	return g
+}
+
+// value returns a random value, sometimes with X and Z bits.
+func (g *exprGen) value(width uint, signed bool) Value {
+	vec := NewVector(width, 0)
+	for i := range vec.words {
+		vec.words[i] = g.r.Uint64()
+	}
+	vec.truncate()
+	vec.signed = signed
+	switch g.r.Intn(8) {
+	case 0:
+		lv := LogicOf(vec).WithSign(signed)
+		for i := 0; i < 3; i++ {
+			lv.SetBit(uint(g.r.Intn(int(width))), Logic(g.r.Intn(4)))
+		}
+		return lv
+	case 1:
+		return NewVector(width, uint64(g.r.Intn(4))).WithSign(signed)
+	case 2:
+		if width == 1 && !signed {
+			return boolValue(g.r.Intn(2) == 0)
+		}
+	}
+	return vec
+}
+
+// randomize assigns new values of the same type to the vars.
+func (g *exprGen) randomize() {
+	for _, v := range g.vars {
+		v.Value = g.value(v.Value.Width(), v.Value.Signed())
+	}
+}
+
+func (g *exprGen) width(e Expr) uint {
+	t, _ := Check(e)
+	return t.Width
+}
+
+func (g *exprGen) leaf() Expr {
+	switch n := g.r.Intn(10); {
+	case n < 5:
+		return g.vars[g.r.Intn(len(g.vars))]
+	case n < 8 && len(g.nodes) > 0:
+		return g.nodes[g.r.Intn(len(g.nodes))]
+	}
+	return g.value(genWidths[g.r.Intn(len(genWidths))], g.r.Intn(2) == 0)
+}
+
+func (g *exprGen) expr(depth int) Expr {
+	if depth == 0 || g.r.Intn(6) == 0 {
+		return g.leaf()
+	}
+	var e Expr
+	switch g.r.Intn(9) {
+	case 0, 1:
+		e = &UnaryExpr{g.expr(depth - 1), Op(g.r.Intn(int(OpNeg) + 1))}
+	case 2, 3, 4:
+		op := OpAnd + Op(g.r.Intn(int(OpAShr-OpAnd)+1))
+		x := g.expr(depth - 1)
+		var y Expr
+		switch op {
+		case OpShl, OpShr, OpAShl, OpAShr:
+			if g.r.Intn(2) == 0 {
+				y = NewVector(8, uint64(g.r.Intn(140)))
+				break
+			}
+			fallthrough
+		default:
+			y = g.expr(depth - 1)
+		}
+		e = &BinaryExpr{x, y, op}
+	case 5:
+		e = &IfExpr{g.expr(depth - 1), g.expr(depth - 1), g.expr(depth - 1)}
+	case 6:
+		x := g.expr(depth - 1)
+		w := g.width(x)
+		low := uint(g.r.Intn(int(w)))
+		e = Slice(x, low+uint(g.r.Intn(int(w-low))), low)
+		if g.r.Intn(2) == 0 {
+			// constant indexes must be within range
+			i := g.expr(depth - 1)
+			if _, ok := i.(Value); ok {
+				i = g.vars[g.r.Intn(len(g.vars))]
+			}
+			e = Index(x, i)
+		}
+	case 7:
+		if g.r.Intn(2) == 0 {
+			e = Replicate(uint(g.r.Intn(3)+1), g.expr(depth-1))
+		} else {
+			e = Concat(g.expr(depth-1), g.expr(depth-1))
+		}
+	case 8:
+		if g.r.Intn(3) == 0 {
+			e = Size(uint(g.r.Intn(12)+1), g.expr(depth-1))
+			break
+		}
+		e = &CastExpr{g.expr(depth - 1), g.r.Intn(2) == 0}
+	}
+	g.nodes = append(g.nodes, e)
+	return e
+}
+
+func TestCompile(t *testing.T) {
+	for seed := int64(0); seed < 300; seed++ {
+		g := newExprGen(seed)
+		e := g.expr(5)
+		typ, _ := Check(e)
+		p := Compile(e)
+		for run := 0; run < 4; run++ {
+			v := p.Run()
+			if v.Width() != typ.Width || v.Signed() != typ.Signed {
+				t.Fatal(seed, Format(e), v.Width(), typ)
+			}
+			if ev := e.Eval(); !Eq(ev, v) {
+				t.Fatalf("seed %d: %s: %v != %v", seed, Format(e), LogicOf(ev), v)
+			}
+			g.randomize()
+		}
+	}
+}
+
+func TestCompileDivide(t *testing.T) {
+	for _, w := range []uint{8, 64, 130} {
+		for _, signed := range []bool{false, true} {
+			x := NewVar("x", NewVector(w, 0).WithSign(signed))
+			y := NewVar("y", NewVector(w, 0).WithSign(signed))
+			p := Compile(Concat(Div(x, y), Mod(x, y)))
+			g := newExprGen(int64(w))
+			for i := 0; i < 200; i++ {
+				x.Value = vectorOf(g.value(w, signed)).WithSign(signed)
+				y.Value = vectorOf(g.value(w, signed)).WithSign(signed)
+				if i%20 == 0 {
+					x.Value = NewSigned(w, -1).WithSign(signed)
+				}
+				e := Concat(Div(x.Value, y.Value), Mod(x.Value, y.Value))
+				if v, ev := p.Run(), e.Eval(); !Eq(ev, v) {
+					t.Fatal(w, signed, x.Value, y.Value, v, LogicOf(ev))
+				}
+			}
+		}
+	}
+}
+
+func TestCompileShared(t *testing.T) {
+	a := NewVar("a", NewVector(8, 3))
+	s := Add(a, a)
+	e := Mul(s, s)
+	p := Compile(e)
+	if len(p.code) != 2 {
+		t.Fatal(len(p.code))
+	}
+	if v := p.Run(); v.Uint() != 36 {
+		t.Fatal(v)
+	}
+	a.Value = NewVector(8, 1)
+	if v := p.Run(); v.Uint() != 4 {
+		t.Fatal(v)
+	}
+}
+
+func TestCompileAllocs(t *testing.T) {
+	g := newExprGen(42)
+	p := Compile(g.expr(6))
+	if n := testing.AllocsPerRun(100, func() { p.Run() }); n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	Compile(Add(NewVar("a", nil), T))
+}
+
+// bigExpr builds a tree of n nodes over a few 32-bit vars.
+func bigExpr(n int) Expr {
+	r := rand.New(rand.NewSource(1))
+	vars := make([]Expr, 8)
+	for i := range vars {
+		vars[i] = NewVar(string(rune('a'+i)), NewVector(32, r.Uint64()))
+	}
+	ops := []Op{OpAdd, OpSub, OpBitXor, OpBitAnd, OpBitOr, OpMul}
+	var build func(n int) Expr
+	build = func(n int) Expr {
+		if n <= 1 {
+			return vars[r.Intn(len(vars))]
+		}
+		n--
+		left := n / 2
+		return &BinaryExpr{build(left), build(n - left), ops[r.Intn(len(ops))]}
+	}
+	return build(n)
+}
+
+func BenchmarkEval(b *testing.B) {
+	e := bigExpr(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Eval()
+	}
+}
+
+func BenchmarkProgram(b *testing.B) {
+	p := Compile(bigExpr(10000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Run()
+	}
+}
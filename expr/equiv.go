@@ -0,0 +1,633 @@
+package expr
+
+import "fmt"
+
+// Equivalent reports whether a and b evaluate to the same value, as
+// compared by Eq, for every value of their Vars. Vars are matched by name
+// and keep the width and signedness of their current Value, but take any
+// value with no X or Z bits; X results, such as those of a division by
+// zero, are compared as they are. When the expressions differ, the
+// counterexample holds a value of each Var which tells them apart.
+//
+// Both expressions are translated bit by bit to a formula in conjunctive
+// normal form, which is decided by a built-in SAT solver. Equivalent
+// panics if a or b has errors, as reported by Check.
+func Equivalent(a, b Expr) (equivalent bool, counterexample map[string]Value) {
+	bl := newBlaster()
+	x, tx := bl.blast(a)
+	y, ty := bl.blast(b)
+
+	w, signed := max(tx.Width, ty.Width), tx.Signed && ty.Signed
+	x, y = bl.extend(x, w, signed), bl.extend(y, w, signed)
+	differ := bl.f
+	for i := range x {
+		differ = bl.or(differ, bl.or(bl.xor(x[i].a, y[i].a), bl.xor(x[i].b, y[i].b)))
+	}
+	bl.s.addClause(differ)
+	if !bl.s.solve() {
+		return true, nil
+	}
+
+	counterexample = make(map[string]Value)
+	for name, in := range bl.inputs {
+		vec := NewVector(uint(len(in.bits)), 0)
+		for i, l := range in.bits {
+			if bl.s.value(l) == 1 {
+				vec.words[i/wordSize] |= 1 << (uint(i) % wordSize)
+			}
+		}
+		var v Value = vec.WithSign(in.v.Value.Signed())
+		if _, ok := in.v.Value.(*Bool); ok {
+			v = boolValue(vec.True())
+		}
+		counterexample[name] = v
+	}
+	return false, counterexample
+}
+
+// bit is a four-state bit encoded by two literals, as the bits of the aval
+// and bval words of a LogicVector.
+type bit struct {
+	a, b lit
+}
+
+type gate struct {
+	xor  bool
+	x, y lit
+}
+
+// input is the variables of the bits of a Var.
+type input struct {
+	v    *Var
+	bits []lit
+}
+
+// blaster translates expressions to a circuit of and and xor gates over
+// the variables of a solver, folding constants and sharing equal gates.
+type blaster struct {
+	s      *solver
+	t, f   lit
+	gates  map[gate]lit
+	bits   map[Expr][]bit
+	inputs map[string]input
+}
+
+func newBlaster() *blaster {
+	bl := &blaster{
+		s:      newSolver(),
+		gates:  make(map[gate]lit),
+		bits:   make(map[Expr][]bit),
+		inputs: make(map[string]input),
+	}
+	bl.t = bl.s.newVar()
+	bl.f = bl.t.not()
+	bl.s.addClause(bl.t)
+	return bl
+}
+
+func (bl *blaster) and(x, y lit) lit {
+	switch {
+	case x == bl.f || y == bl.f || x == y.not():
+		return bl.f
+	case x == bl.t || x == y:
+		return y
+	case y == bl.t:
+		return x
+	}
+	if x > y {
+		x, y = y, x
+	}
+	g := gate{false, x, y}
+	if z, ok := bl.gates[g]; ok {
+		return z
+	}
+	z := bl.s.newVar()
+	bl.s.addClause(z.not(), x)
+	bl.s.addClause(z.not(), y)
+	bl.s.addClause(z, x.not(), y.not())
+	bl.gates[g] = z
+	return z
+}
+
+func (bl *blaster) or(x, y lit) lit {
+	return bl.and(x.not(), y.not()).not()
+}
+
+func (bl *blaster) xor(x, y lit) lit {
+	switch {
+	case x == y:
+		return bl.f
+	case x == y.not():
+		return bl.t
+	case x == bl.f:
+		return y
+	case y == bl.f:
+		return x
+	case x == bl.t:
+		return y.not()
+	case y == bl.t:
+		return x.not()
+	}
+	// keep the gates positive, so x ^ y and ¬x ^ y share one
+	neg := x&1 != y&1
+	x, y = x&^1, y&^1
+	if x > y {
+		x, y = y, x
+	}
+	g := gate{true, x, y}
+	z, ok := bl.gates[g]
+	if !ok {
+		z = bl.s.newVar()
+		bl.s.addClause(z.not(), x, y)
+		bl.s.addClause(z.not(), x.not(), y.not())
+		bl.s.addClause(z, x.not(), y)
+		bl.s.addClause(z, x, y.not())
+		bl.gates[g] = z
+	}
+	if neg {
+		return z.not()
+	}
+	return z
+}
+
+// mux returns s ? x : y.
+func (bl *blaster) mux(s, x, y lit) lit {
+	if x == y {
+		return x
+	}
+	return bl.or(bl.and(s, x), bl.and(s.not(), y))
+}
+
+func (bl *blaster) any(ls []lit) lit {
+	r := bl.f
+	for _, l := range ls {
+		r = bl.or(r, l)
+	}
+	return r
+}
+
+func (bl *blaster) lit(b bool) lit {
+	if b {
+		return bl.t
+	}
+	return bl.f
+}
+
+// logic returns the 1-bit result of a logic value which is 1 when one is
+// set, X when x is set and 0 otherwise.
+func (bl *blaster) logic(one, x lit) []bit {
+	return []bit{{bl.or(one, x), x}}
+}
+
+// truth returns the literals telling whether bits are a known 1 or X, as a
+// condition is evaluated.
+func (bl *blaster) truth(bits []bit) (one, x lit) {
+	one = bl.f
+	unknown := bl.f
+	for _, b := range bits {
+		one = bl.or(one, bl.and(b.a, b.b.not()))
+		unknown = bl.or(unknown, b.b)
+	}
+	return one, bl.and(one.not(), unknown)
+}
+
+func (bl *blaster) unknown(bits []bit) lit {
+	r := bl.f
+	for _, b := range bits {
+		r = bl.or(r, b.b)
+	}
+	return r
+}
+
+// vals returns the aval literals of bits.
+func vals(bits []bit) []lit {
+	ls := make([]lit, len(bits))
+	for i, b := range bits {
+		ls[i] = b.a
+	}
+	return ls
+}
+
+// known returns the bits of the two-valued ls, or all X when x is set.
+func (bl *blaster) known(ls []lit, x lit) []bit {
+	bits := make([]bit, len(ls))
+	for i, l := range ls {
+		bits[i] = bit{bl.or(l, x), x}
+	}
+	return bits
+}
+
+func (bl *blaster) extend(bits []bit, width uint, signed bool) []bit {
+	if uint(len(bits)) >= width {
+		return bits
+	}
+	fill := bit{bl.f, bl.f}
+	if signed {
+		fill = bits[len(bits)-1]
+	}
+	r := append([]bit(nil), bits...)
+	for uint(len(r)) < width {
+		r = append(r, fill)
+	}
+	return r
+}
+
+func (bl *blaster) constant(v Value) []bit {
+	lv := LogicOf(v)
+	bits := make([]bit, lv.width)
+	for i := range bits {
+		a, b := lv.word(i / wordSize)
+		bits[i] = bit{bl.lit(a>>(uint(i)%wordSize)&1 != 0), bl.lit(b>>(uint(i)%wordSize)&1 != 0)}
+	}
+	return bits
+}
+
+func (bl *blaster) input(v *Var, t Type) []bit {
+	in, ok := bl.inputs[v.Name]
+	if !ok {
+		in = input{v, make([]lit, t.Width)}
+		for i := range in.bits {
+			in.bits[i] = bl.s.newVar()
+		}
+		bl.inputs[v.Name] = in
+	} else if w := uint(len(in.bits)); w != t.Width {
+		panic(fmt.Sprintf("expr: signal %s has %d and %d bits", v.Name, w, t.Width))
+	}
+	bits := make([]bit, t.Width)
+	for i, l := range in.bits {
+		bits[i] = bit{l, bl.f}
+	}
+	return bits
+}
+
+// blast returns the bits of e and its type.
+func (bl *blaster) blast(e Expr) ([]bit, Type) {
+	check := &checker{types: make(map[Expr]Type), visiting: make(map[Expr]bool)}
+	t := check.check(e)
+	if errs := Errors(check.diags); len(errs) > 0 {
+		panic(errs[0].String())
+	}
+	return bl.expr(e, check.types), t
+}
+
+// operand returns the bits of x extended to width bits.
+func (bl *blaster) operand(x Expr, types map[Expr]Type, width uint, signed bool) []bit {
+	return bl.extend(bl.expr(x, types), width, signed)
+}
+
+func (bl *blaster) expr(e Expr, types map[Expr]Type) []bit {
+	if bits, ok := bl.bits[e]; ok {
+		return bits
+	}
+	t := types[e]
+	var bits []bit
+	switch e := e.(type) {
+	case Value:
+		bits = bl.constant(e)
+	case *Var:
+		bits = bl.input(e, t)
+	case *UnaryExpr:
+		bits = bl.unary(e.Op, bl.expr(e.Expr, types))
+	case *BinaryExpr:
+		t1, t2 := types[e.Expr1], types[e.Expr2]
+		signed := t1.Signed && t2.Signed
+		switch e.Op {
+		case OpAnd, OpOr, OpShl, OpShr, OpAShl, OpAShr:
+			bits = bl.binary(e.Op, t1.Signed, bl.expr(e.Expr1, types), bl.expr(e.Expr2, types))
+		default:
+			w := max(t1.Width, t2.Width)
+			x, y := bl.operand(e.Expr1, types, w, signed), bl.operand(e.Expr2, types, w, signed)
+			bits = bl.binary(e.Op, signed, x, y)
+		}
+	case *IfExpr:
+		one, x := bl.truth(bl.expr(e.Cond, types))
+		ifBits := bl.operand(e.If, types, t.Width, t.Signed)
+		elseBits := bl.operand(e.Else, types, t.Width, t.Signed)
+		bits = make([]bit, t.Width)
+		for i := range bits {
+			b1, b2 := ifBits[i], elseBits[i]
+			differ := bl.or(bl.xor(b1.a, b2.a), bl.or(b1.b, b2.b))
+			merged := bit{bl.or(b1.a, differ), differ}
+			sel := bit{bl.mux(one, b1.a, b2.a), bl.mux(one, b1.b, b2.b)}
+			bits[i] = bit{bl.mux(x, merged.a, sel.a), bl.mux(x, merged.b, sel.b)}
+		}
+	case *SliceExpr:
+		src := bl.expr(e.Expr, types)
+		bits = make([]bit, t.Width)
+		for i := range bits {
+			if j := e.Low + uint(i); j < uint(len(src)) {
+				bits[i] = src[j]
+			} else {
+				bits[i] = bit{bl.t, bl.t} // past the width of the operand
+			}
+		}
+	case *IndexExpr:
+		src, index := bl.expr(e.Expr, types), bl.expr(e.Index, types)
+		sel := bit{bl.t, bl.t}
+		for i := len(src) - 1; i >= 0; i-- {
+			hit := bl.equals(vals(index), uint64(i))
+			sel = bit{bl.mux(hit, src[i].a, sel.a), bl.mux(hit, src[i].b, sel.b)}
+		}
+		x := bl.unknown(index)
+		bits = []bit{{bl.or(sel.a, x), bl.or(sel.b, x)}}
+	case *ConcatExpr:
+		for i := len(e.Exprs) - 1; i >= 0; i-- {
+			bits = append(bits, bl.expr(e.Exprs[i], types)...)
+		}
+	case *ReplicateExpr:
+		src := bl.expr(e.Expr, types)
+		for i := uint(0); i < e.Count; i++ {
+			bits = append(bits, src...)
+		}
+	case *CastExpr:
+		bits = bl.expr(e.Expr, types)
+	case *SizeExpr:
+		bits = bl.operand(e.Expr, types, t.Width, t.Signed)[:t.Width]
+	}
+	bl.bits[e] = bits
+	return bits
+}
+
+// equals returns whether the unsigned number ls is n.
+func (bl *blaster) equals(ls []lit, n uint64) lit {
+	r := bl.t
+	for i, l := range ls {
+		if i < 64 && n>>uint(i)&1 != 0 {
+			r = bl.and(r, l)
+		} else {
+			r = bl.and(r, l.not())
+		}
+	}
+	if len(ls) < 64 && n>>uint(len(ls)) != 0 {
+		return bl.f
+	}
+	return r
+}
+
+func (bl *blaster) unary(op Op, x []bit) []bit {
+	switch op {
+	case OpNot:
+		one, unknown := bl.truth(x)
+		return bl.logic(bl.or(one, unknown).not(), unknown)
+	case OpRedAnd, OpRedNand:
+		zero, unknown := bl.f, bl.f
+		for _, b := range x {
+			zero = bl.or(zero, bl.and(b.a.not(), b.b.not()))
+			unknown = bl.or(unknown, b.b)
+		}
+		unknown = bl.and(zero.not(), unknown)
+		one := bl.or(zero, unknown).not()
+		if op == OpRedNand {
+			one = zero
+		}
+		return bl.logic(one, unknown)
+	case OpRedOr, OpRedNor:
+		one, unknown := bl.truth(x)
+		if op == OpRedNor {
+			one = bl.or(one, unknown).not()
+		}
+		return bl.logic(one, unknown)
+	case OpRedXor, OpRedXnor:
+		parity := bl.f
+		for _, b := range x {
+			parity = bl.xor(parity, b.a)
+		}
+		unknown := bl.unknown(x)
+		if op == OpRedXnor {
+			parity = parity.not()
+		}
+		return bl.logic(bl.and(parity, unknown.not()), unknown)
+	case OpBitNot:
+		r := make([]bit, len(x))
+		for i, b := range x {
+			r[i] = bit{bl.or(b.a.not(), b.b), b.b}
+		}
+		return r
+	case OpNeg:
+		zero := make([]lit, len(x))
+		for i := range zero {
+			zero[i] = bl.f
+		}
+		diff, _ := bl.sub(zero, vals(x))
+		return bl.known(diff, bl.unknown(x))
+	}
+	panic("not an unary operator: " + op.String())
+}
+
+func (bl *blaster) binary(op Op, signed bool, x, y []bit) []bit {
+	switch op {
+	case OpAnd, OpOr:
+		one1, x1 := bl.truth(x)
+		one2, x2 := bl.truth(y)
+		zero1, zero2 := bl.or(one1, x1).not(), bl.or(one2, x2).not()
+		if op == OpAnd {
+			one, zero := bl.and(one1, one2), bl.or(zero1, zero2)
+			return bl.logic(one, bl.or(one, zero).not())
+		}
+		one, zero := bl.or(one1, one2), bl.and(zero1, zero2)
+		return bl.logic(one, bl.or(one, zero).not())
+	case OpBitAnd, OpBitOr, OpBitXor, OpBitXnor:
+		r := make([]bit, len(x))
+		for i := range r {
+			one1, one2 := bl.and(x[i].a, x[i].b.not()), bl.and(y[i].a, y[i].b.not())
+			zero1, zero2 := bl.and(x[i].a.not(), x[i].b.not()), bl.and(y[i].a.not(), y[i].b.not())
+			var one, zero lit
+			switch op {
+			case OpBitAnd:
+				one, zero = bl.and(one1, one2), bl.or(zero1, zero2)
+			case OpBitOr:
+				one, zero = bl.or(one1, one2), bl.and(zero1, zero2)
+			case OpBitXor:
+				one, zero = bl.or(bl.and(one1, zero2), bl.and(zero1, one2)), bl.or(bl.and(one1, one2), bl.and(zero1, zero2))
+			case OpBitXnor:
+				one, zero = bl.or(bl.and(one1, one2), bl.and(zero1, zero2)), bl.or(bl.and(one1, zero2), bl.and(zero1, one2))
+			}
+			unknown := bl.or(one, zero).not()
+			r[i] = bit{bl.or(one, unknown), unknown}
+		}
+		return r
+	case OpEq, OpNe:
+		differ := bl.f
+		for i := range x {
+			differ = bl.or(differ, bl.and(bl.xor(x[i].a, y[i].a), bl.or(x[i].b, y[i].b).not()))
+		}
+		unknown := bl.and(differ.not(), bl.or(bl.unknown(x), bl.unknown(y)))
+		one := bl.or(differ, unknown).not()
+		if op == OpNe {
+			one = differ
+		}
+		return bl.logic(one, unknown)
+	case OpShl, OpShr, OpAShl, OpAShr:
+		return bl.shift(op, signed, x, y)
+	}
+
+	a, b := vals(x), vals(y)
+	unknown := bl.or(bl.unknown(x), bl.unknown(y))
+	var r []lit
+	switch op {
+	case OpLt, OpLe, OpGt, OpGe:
+		if op == OpGt || op == OpLe {
+			a, b = b, a
+		}
+		if signed {
+			// flipping the sign bits orders two's complement numbers
+			a = append(a[:len(a)-1:len(a)-1], a[len(a)-1].not())
+			b = append(b[:len(b)-1:len(b)-1], b[len(b)-1].not())
+		}
+		_, less := bl.sub(a, b)
+		if op == OpLe || op == OpGe {
+			less = less.not()
+		}
+		return bl.logic(bl.and(less, unknown.not()), unknown)
+	case OpAdd:
+		r = bl.add(a, b, bl.f)
+	case OpSub:
+		r, _ = bl.sub(a, b)
+	case OpMul:
+		r = bl.mul(a, b)
+	case OpDiv, OpMod:
+		var zero lit
+		r, zero = bl.divide(op, signed, a, b)
+		unknown = bl.or(unknown, zero)
+	default:
+		panic("not a binary operator: " + op.String())
+	}
+	return bl.known(r, unknown)
+}
+
+// add returns the sum of a, b and the carry c, truncated to the width of a.
+func (bl *blaster) add(a, b []lit, c lit) []lit {
+	r := make([]lit, len(a))
+	for i := range a {
+		s := bl.xor(a[i], b[i])
+		r[i] = bl.xor(s, c)
+		c = bl.or(bl.and(a[i], b[i]), bl.and(s, c))
+	}
+	return r
+}
+
+// sub returns a - b and whether a is less than b, as unsigned numbers.
+func (bl *blaster) sub(a, b []lit) ([]lit, lit) {
+	r := make([]lit, len(a))
+	c := bl.t
+	for i := range a {
+		nb := b[i].not()
+		s := bl.xor(a[i], nb)
+		r[i] = bl.xor(s, c)
+		c = bl.or(bl.and(a[i], nb), bl.and(s, c))
+	}
+	return r, c.not()
+}
+
+func (bl *blaster) mul(a, b []lit) []lit {
+	r := make([]lit, len(a))
+	for i := range r {
+		r[i] = bl.f
+	}
+	for i := range b {
+		partial := make([]lit, len(a))
+		for j := range partial {
+			partial[j] = bl.f
+			if j >= i {
+				partial[j] = bl.and(a[j-i], b[i])
+			}
+		}
+		r = bl.add(r, partial, bl.f)
+	}
+	return r
+}
+
+// divide returns the quotient or the remainder of a and b, truncated
+// towards zero, and whether b is zero.
+func (bl *blaster) divide(op Op, signed bool, a, b []lit) ([]lit, lit) {
+	w := len(a)
+	zero := bl.any(b).not()
+	negx, negy := bl.f, bl.f
+	if signed {
+		negx, negy = a[w-1], b[w-1]
+		a, b = bl.abs(a, negx), bl.abs(b, negy)
+	}
+
+	q := make([]lit, w)
+	r := make([]lit, w)
+	for i := range r {
+		r[i] = bl.f
+	}
+	for i := w - 1; i >= 0; i-- {
+		// shift the next bit of a into r; a bit shifted out of r makes
+		// it greater than b
+		out := r[w-1]
+		r = append([]lit{a[i]}, r[:w-1]...)
+		diff, less := bl.sub(r, b)
+		q[i] = bl.or(out, less.not())
+		for j := range r {
+			r[j] = bl.mux(q[i], diff[j], r[j])
+		}
+	}
+
+	res, neg := q, bl.xor(negx, negy)
+	if op == OpMod {
+		res, neg = r, negx
+	}
+	return bl.abs(res, neg), zero
+}
+
+// abs returns the two's complement of x if neg is set, or x.
+func (bl *blaster) abs(x []lit, neg lit) []lit {
+	if neg == bl.f {
+		return x
+	}
+	r := make([]lit, len(x))
+	c := neg
+	for i := range x {
+		n := bl.xor(x[i], neg)
+		r[i] = bl.xor(n, c)
+		c = bl.and(n, c)
+	}
+	return r
+}
+
+// shift shifts x by the unsigned number y, with the four states of each bit.
+func (bl *blaster) shift(op Op, signed bool, x, y []bit) []bit {
+	w := len(x)
+	fill := bit{bl.f, bl.f}
+	if op == OpAShr && signed {
+		fill = x[w-1]
+	}
+	r := append([]bit(nil), x...)
+	over := bl.f
+	for k, s := range vals(y) {
+		if k >= 63 || 1<<uint(k) >= w {
+			over = bl.or(over, s)
+			continue
+		}
+		n := 1 << uint(k)
+		next := make([]bit, w)
+		for i := range next {
+			var moved bit
+			switch op {
+			case OpShl, OpAShl:
+				moved = bit{bl.f, bl.f}
+				if i >= n {
+					moved = r[i-n]
+				}
+			default:
+				moved = fill
+				if i+n < w {
+					moved = r[i+n]
+				}
+			}
+			next[i] = bit{bl.mux(s, moved.a, r[i].a), bl.mux(s, moved.b, r[i].b)}
+		}
+		r = next
+	}
+	if op == OpShl || op == OpAShl {
+		fill = bit{bl.f, bl.f}
+	}
+	unknown := bl.unknown(y)
+	for i := range r {
+		b := bit{bl.mux(over, fill.a, r[i].a), bl.mux(over, fill.b, r[i].b)}
+		r[i] = bit{bl.or(b.a, unknown), bl.or(b.b, unknown)}
+	}
+	return r
+}
@@ -0,0 +1,180 @@
+package expr
+
+import "testing"
+
+func TestEquivalent(t *testing.T) {
+	env := MapEnv{
+		"a": NewVar("a", NewVector(8, 0)),
+		"b": NewVar("b", NewVector(8, 0)),
+		"c": NewVar("c", NewVector(4, 0)),
+		"s": NewVar("s", NewSigned(8, 0)),
+		"x": NewVar("x", F),
+		"y": NewVar("y", F),
+		"z": NewVar("z", F),
+	}
+	tests := []struct {
+		a, b       string
+		equivalent bool
+	}{
+		{"a + b", "b + a", true},
+		{"a - b", "a + ~b + 8'd1", true},
+		{"a & b", "~(~a | ~b)", true},
+		{"a * 8'd2", "a << 1", true},
+		{"a * 8'd3", "(a << 1) + a", true},
+		{"a << 1", "a >> 1", false},
+		{"a + b", "a | b", false},
+		{"x ? y : z", "x && y || !x && z", true},
+		{"x ? a : b", "{8{x}} & a | {8{!x}} & b", true},
+		{"x ^ y", "x != y", true},
+		{"a == b", "!(a != b)", true},
+		{"a < b", "b > a", true},
+		{"a <= b", "!(b < a)", true},
+		{"s < 8'sd0", "s[7]", true},
+		{"s < 8'sd0", "a[7]", false},
+		{"s >>> 1", "s >> 1", false},
+		{"s >>> 7", "{8{s[7]}}", true},
+		{"{a[3:0], a[7:4]}", "a << 4 | a >> 4", true},
+		{"c", "{4'd0, c}", true},
+		{"a[c]", "(a >> c) & 8'd1", false}, // X when c is past the width
+		{"a[c[2:0]]", "|((a >> c[2:0]) & 8'd1)", true},
+		{"a / b", "a / b", true},
+		{"a / b * b + a % b", "a", false}, // X when b is zero
+		{"b == 0 ? a : a / b * b + a % b", "a", true},
+		{"s / 8'sd2", "s >>> 1", false},
+		{"&a", "a == 8'hff", true},
+		{"^a", "^{a[3:0] ^ a[7:4]}", true},
+	}
+	for _, tt := range tests {
+		a, err := Parse(tt.a, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Parse(tt.b, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		equivalent, counterexample := Equivalent(a, b)
+		if equivalent != tt.equivalent {
+			t.Errorf("%s, %s: got %v, want %v", tt.a, tt.b, equivalent, tt.equivalent)
+			continue
+		}
+		if equivalent {
+			continue
+		}
+		for name, v := range counterexample {
+			env[name].(*Var).Value = v
+		}
+		if va, vb := a.Eval(), b.Eval(); Eq(va, vb) {
+			t.Errorf("%s, %s: %v is not a counterexample: %v", tt.a, tt.b, counterexample, LogicOf(va))
+		}
+	}
+}
+
+// assignments calls fn with every value of vars with no X or Z bits.
+func assignments(vars []*Var, fn func()) {
+	if len(vars) == 0 {
+		fn()
+		return
+	}
+	v := vars[0]
+	w, signed := v.Value.Width(), v.Value.Signed()
+	_, isBool := v.Value.(*Bool)
+	for n := uint64(0); n < 1<<w; n++ {
+		v.Value = NewVector(w, n).WithSign(signed)
+		if isBool {
+			v.Value = boolValue(n == 1)
+		}
+		assignments(vars[1:], fn)
+	}
+}
+
+func TestEquivalentRandom(t *testing.T) {
+	for seed := int64(0); seed < 300; seed++ {
+		g := newExprGen(seed)
+		g.vars = g.vars[:3]
+		for _, v := range g.vars {
+			v.Value = g.value(uint(g.r.Intn(3)+1), g.r.Intn(2) == 0)
+		}
+		a := g.expr(3)
+		b := g.expr(3)
+		if seed%2 == 0 {
+			b = Simplify(a)
+		}
+		if _, diags := Check(b); len(Errors(diags)) > 0 {
+			continue // folded into a constant index out of range
+		}
+		want := true
+		assignments(g.vars, func() {
+			if !Eq(a.Eval(), b.Eval()) {
+				want = false
+			}
+		})
+		equivalent, counterexample := Equivalent(a, b)
+		if equivalent != want {
+			t.Fatalf("seed %d: %s, %s: got %v", seed, Format(a), Format(b), equivalent)
+		}
+		if equivalent {
+			continue
+		}
+		for _, v := range g.vars {
+			v.Value = counterexample[v.Name]
+		}
+		if Eq(a.Eval(), b.Eval()) {
+			t.Fatalf("seed %d: %s, %s: %v is not a counterexample", seed, Format(a), Format(b), counterexample)
+		}
+	}
+}
+
+func TestEquivalentErrors(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	Equivalent(NewVar("a", nil), T)
+}
+
+func TestSolver(t *testing.T) {
+	// the pigeonhole principle: n+1 pigeons do not fit in n holes
+	for n := 1; n <= 5; n++ {
+		s := newSolver()
+		in := make([][]lit, n+1)
+		for p := range in {
+			in[p] = make([]lit, n)
+			for h := range in[p] {
+				in[p][h] = s.newVar()
+			}
+			s.addClause(in[p]...)
+		}
+		for h := 0; h < n; h++ {
+			for p := range in {
+				for q := p + 1; q < len(in); q++ {
+					s.addClause(in[p][h].not(), in[q][h].not())
+				}
+			}
+		}
+		if s.solve() {
+			t.Fatal(n, "pigeons fit")
+		}
+	}
+
+	// a chain of implications forcing every variable
+	s := newSolver()
+	vars := make([]lit, 50)
+	for i := range vars {
+		vars[i] = s.newVar()
+		if i > 0 {
+			s.addClause(vars[i-1].not(), vars[i])
+		}
+	}
+	s.addClause(vars[0], vars[len(vars)-1].not())
+	s.addClause(vars[0], vars[1])
+	if !s.solve() {
+		t.Fatal("unsatisfiable")
+	}
+	for _, v := range vars {
+		if s.value(v) != 1 {
+			t.Fatal(v, s.value(v))
+		}
+	}
+}
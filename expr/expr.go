@@ -1,5 +1,7 @@
 package expr
 
+import "math/bits"
+
 type Expr interface {
 	Eval() Value
 }
@@ -14,49 +16,107 @@ func Eq(vl, vr Value) bool {
 	return vr.Eq(vl)
 }
 
+// Var is a named signal. Its Value may change between evaluations, so it is
+// never folded into a constant.
+//
+// A free Var stands for an input whose value is not known at all. Its Value
+// is X, only giving its type, and Partial keeps it in the residual
+// expressions it returns.
+type Var struct {
+	Name  string
+	Value Value
+	Free  bool
+}
+
+func NewVar(name string, v Value) *Var {
+	return &Var{Name: name, Value: v}
+}
+
+// Free returns a free Var of type t.
+func Free(name string, t Type) *Var {
+	return &Var{Name: name, Value: Unknown(t.Width).WithSign(t.Signed), Free: true}
+}
+
+func (v *Var) Eval() Value {
+	return v.Value
+}
+
 type UnaryExpr struct {
 	Expr Expr
-	Op   func(v Value) Value
+	Op   Op
 }
 
 func (uo *UnaryExpr) Eval() Value {
-	return uo.Op(uo.Expr.Eval())
+	return evalUnary(uo.Op, uo.Expr.Eval())
 }
 
 type BinaryExpr struct {
 	Expr1, Expr2 Expr
-	Op           func(v1, v2 Value) Value
+	Op           Op
 }
 
 func (bo *BinaryExpr) Eval() Value {
-	return bo.Op(bo.Expr1.Eval(), bo.Expr2.Eval())
+	return evalBinary(bo.Op, bo.Expr1.Eval(), bo.Expr2.Eval())
 }
 
+// Not is the logical negation !expr.
 func Not(expr Expr) *UnaryExpr {
-	return &UnaryExpr{
-		expr,
-		func(v Value) Value { // XXX: must support Vector not
-			return boolValue(!v.True())
-		},
-	}
+	return &UnaryExpr{expr, OpNot}
 }
 
+// And is the logical conjunction expr1 && expr2.
 func And(expr1, expr2 Expr) *BinaryExpr {
-	return &BinaryExpr{
-		expr1, expr2,
-		func(v1 Value, v2 Value) Value { // XXX: must support Vector not
-			return boolValue(v1.True() && v2.True())
-		},
-	}
+	return &BinaryExpr{expr1, expr2, OpAnd}
 }
 
+// Or is the logical disjunction expr1 || expr2.
 func Or(expr1, expr2 Expr) *BinaryExpr {
-	return &BinaryExpr{
-		expr1, expr2,
-		func(v1 Value, v2 Value) Value { // XXX: must support Vector not
-			return boolValue(v1.True() || v2.True())
-		},
-	}
+	return &BinaryExpr{expr1, expr2, OpOr}
+}
+
+func BitNot(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpBitNot}
+}
+
+func BitAnd(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpBitAnd}
+}
+
+func BitOr(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpBitOr}
+}
+
+func BitXor(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpBitXor}
+}
+
+func BitXnor(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpBitXnor}
+}
+
+// RedAnd reduces all bits of expr with &.
+func RedAnd(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpRedAnd}
+}
+
+func RedOr(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpRedOr}
+}
+
+func RedXor(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpRedXor}
+}
+
+func RedNand(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpRedNand}
+}
+
+func RedNor(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpRedNor}
+}
+
+func RedXnor(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpRedXnor}
 }
 
 func max(a, b uint) uint {
@@ -68,26 +128,253 @@ func max(a, b uint) uint {
 }
 
 func Add(expr1, expr2 Expr) *BinaryExpr {
-	return &BinaryExpr{
-		expr1, expr2,
-		func(v1 Value, v2 Value) Value { // XXX: must support Vector not
-			a := v1.Uint()
-			b := v2.Uint()
-			return &Vector{a + b, max(v1.Width(), v2.Width())}
-		},
+	return &BinaryExpr{expr1, expr2, OpAdd}
+}
+
+func Sub(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpSub}
+}
+
+func Mul(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpMul}
+}
+
+// Div is the integer division. Dividing by zero gives X.
+func Div(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpDiv}
+}
+
+// Mod is the remainder of the division. Dividing by zero gives X.
+func Mod(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpMod}
+}
+
+// Neg is the two's complement negation -expr.
+func Neg(expr Expr) *UnaryExpr {
+	return &UnaryExpr{expr, OpNeg}
+}
+
+func Equal(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpEq}
+}
+
+func NotEqual(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpNe}
+}
+
+func Less(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpLt}
+}
+
+func LessEq(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpLe}
+}
+
+func Greater(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpGt}
+}
+
+func GreaterEq(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpGe}
+}
+
+// Shl shifts expr1 left by expr2 bits, filling with zeros.
+func Shl(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpShl}
+}
+
+// Shr shifts expr1 right by expr2 bits, filling with zeros.
+func Shr(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpShr}
+}
+
+// AShl is the arithmetic shift left, which is the same as Shl.
+func AShl(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpAShl}
+}
+
+// AShr shifts expr1 right by expr2 bits, replicating its sign bit when
+// expr1 is signed. Otherwise it is the same as Shr.
+func AShr(expr1, expr2 Expr) *BinaryExpr {
+	return &BinaryExpr{expr1, expr2, OpAShr}
+}
+
+// addVector returns a + b truncated to the widest operand.
+func addVector(a, b *Vector) *Vector {
+	sum := NewVector(max(a.width, b.width), 0)
+	var carry uint64
+	for i := range sum.words {
+		sum.words[i], carry = bits.Add64(a.word(i), b.word(i), carry)
 	}
+	sum.truncate()
+	return sum
 }
 
+// IfExpr is the conditional Cond ? If : Else. Both branches are extended to
+// the widest of them, as the operands of a binary operator.
 type IfExpr struct {
 	Cond, If, Else Expr
 }
 
 func (ife *IfExpr) Eval() Value {
-	if ife.Cond.Eval().True() {
-		return ife.If.Eval()
-	} else {
-		return ife.Else.Eval()
+	cond := truth(ife.Cond.Eval())
+	v1, v2 := ife.If.Eval(), ife.Else.Eval()
+	w, signed := max(v1.Width(), v2.Width()), v1.Signed() && v2.Signed()
+	switch cond {
+	case L1:
+		return extendValue(v1, w, signed)
+	case L0:
+		return extendValue(v2, w, signed)
+	default:
+		return merge(v1, v2)
+	}
+}
+
+// extendValue returns v with width bits, read as signed or unsigned.
+func extendValue(v Value, width uint, signed bool) Value {
+	if v.Width() == width && v.Signed() == signed {
+		return v
+	}
+	if lv, ok := v.(*LogicVector); ok {
+		return lv.extend(width, signed).WithSign(signed)
+	}
+	return vectorOf(v).extend(width, signed).WithSign(signed)
+}
+
+// SliceExpr selects the bits Expr[High:Low]. Bits past the width of Expr
+// read as X. A reversed slice, with High < Low, is reported by Check and
+// has no bits.
+type SliceExpr struct {
+	Expr      Expr
+	High, Low uint
+}
+
+func Slice(expr Expr, high, low uint) *SliceExpr {
+	return &SliceExpr{expr, high, low}
+}
+
+func (se *SliceExpr) Eval() Value {
+	return sliceValue(se.Expr.Eval(), se.High, se.Low)
+}
+
+// IndexExpr selects the single bit Expr[Index]. An unknown or out of range
+// index reads as X.
+type IndexExpr struct {
+	Expr, Index Expr
+}
+
+func Index(expr, index Expr) *IndexExpr {
+	return &IndexExpr{expr, index}
+}
+
+func (ie *IndexExpr) Eval() Value {
+	v := ie.Expr.Eval()
+	i := ie.Index.Eval()
+	if unknown(i) {
+		return Unknown(1)
+	}
+	n := shiftAmount(vectorOf(i))
+	if n >= v.Width() {
+		return Unknown(1)
 	}
+	return sliceValue(v, n, n)
+}
+
+// ConcatExpr joins its expressions as {Exprs[0], Exprs[1], ...}, so the
+// first one ends up in the most significant bits.
+type ConcatExpr struct {
+	Exprs []Expr
+}
+
+func Concat(exprs ...Expr) *ConcatExpr {
+	return &ConcatExpr{exprs}
+}
+
+func (ce *ConcatExpr) Eval() Value {
+	values := make([]Value, len(ce.Exprs))
+	for i, e := range ce.Exprs {
+		values[i] = e.Eval()
+	}
+	return concatValues(values...)
+}
+
+// ReplicateExpr repeats Expr Count times as {Count{Expr}}.
+type ReplicateExpr struct {
+	Expr  Expr
+	Count uint
+}
+
+func Replicate(count uint, expr Expr) *ReplicateExpr {
+	return &ReplicateExpr{expr, count}
+}
+
+func (re *ReplicateExpr) Eval() Value {
+	v := re.Expr.Eval()
+	values := make([]Value, re.Count)
+	for i := range values {
+		values[i] = v
+	}
+	return concatValues(values...)
+}
+
+// CastExpr reads Expr as a signed or an unsigned number, like the Verilog
+// $signed and $unsigned functions.
+type CastExpr struct {
+	Expr   Expr
+	Signed bool
+}
+
+func AsSigned(expr Expr) *CastExpr {
+	return &CastExpr{expr, true}
+}
+
+func AsUnsigned(expr Expr) *CastExpr {
+	return &CastExpr{expr, false}
+}
+
+func (ce *CastExpr) Eval() Value {
+	switch v := ce.Expr.Eval().(type) {
+	case *LogicVector:
+		return v.WithSign(ce.Signed)
+	case *Bool:
+		if !ce.Signed {
+			return v
+		}
+		return vectorOf(v).WithSign(true)
+	default:
+		return vectorOf(v).WithSign(ce.Signed)
+	}
+}
+
+// SizeExpr resizes Expr to Width bits, like the SystemVerilog size cast
+// Width'(Expr). A wider operand is truncated and a narrower one is extended
+// according to its sign, which the result keeps. Verilog evaluates an
+// operand narrower than Width with Width bits, which Check reports.
+type SizeExpr struct {
+	Expr  Expr
+	Width uint
+}
+
+func Size(width uint, expr Expr) *SizeExpr {
+	if width == 0 {
+		panic("size cast must have a positive width")
+	}
+	return &SizeExpr{expr, width}
+}
+
+func (se *SizeExpr) Eval() Value {
+	v := se.Expr.Eval()
+	if v.Width() <= se.Width {
+		return extendValue(v, se.Width, v.Signed())
+	}
+	r := sliceValue(v, se.Width-1, 0)
+	switch r := r.(type) {
+	case *Vector:
+		return r.WithSign(v.Signed())
+	case *LogicVector:
+		return r.WithSign(v.Signed())
+	}
+	return r
 }
 
 type WalkFunc func(Expr, []Expr) error
@@ -104,19 +391,7 @@ func Walk(root Expr, walkFunc WalkFunc) (err error) {
 		}
 		visited[expr] = true
 
-		var next []Expr
-		switch expr.(type) {
-		case Value:
-		case *UnaryExpr:
-			ue := expr.(*UnaryExpr)
-			next = []Expr{ue.Expr}
-		case *BinaryExpr:
-			be := expr.(*BinaryExpr)
-			next = []Expr{be.Expr1, be.Expr2}
-		case *IfExpr:
-			ife := expr.(*IfExpr)
-			next = []Expr{ife.Cond, ife.If, ife.Else}
-		}
+		next := Children(expr)
 		err = walkFunc(expr, next)
 		if err != nil {
 			return
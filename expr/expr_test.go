@@ -1,6 +1,9 @@
 package expr
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+)
 
 func TestUnary(t *testing.T) {
 	a := Bool(true)
@@ -91,6 +94,18 @@ func TestIf(t *testing.T) {
 	}
 }
 
+func TestIfExtend(t *testing.T) {
+	// the selected branch is extended to the widest one
+	v := (&IfExpr{T, NewSigned(4, -1), NewSigned(8, 0)}).Eval()
+	if v.Width() != 8 || !v.Signed() || vectorOf(v).Big().Int64() != -1 {
+		t.Fatal(v)
+	}
+	v = (&IfExpr{T, NewSigned(4, -1), NewVector(8, 0)}).Eval()
+	if v.Width() != 8 || v.Signed() || v.Uint() != 0xf {
+		t.Fatal(v)
+	}
+}
+
 func TestWalk(t *testing.T) {
 	a := Bool(true)
 	b := Bool(false)
@@ -128,3 +143,123 @@ func TestWalk(t *testing.T) {
 		t.Fatal(count)
 	}
 }
+
+func TestSlice(t *testing.T) {
+	a := NewVector(8, 0xa5)
+	if v := Slice(a, 7, 4).Eval(); v.Width() != 4 || v.Uint() != 0xa {
+		t.Fatal(v)
+	}
+	if v := Slice(a, 0, 0).Eval(); v.Width() != 1 || !v.True() {
+		t.Fatal(v)
+	}
+
+	// across words
+	wide := VectorFromBig(128, new(big.Int).Lsh(big.NewInt(0xabc), 60))
+	if v := Slice(wide, 71, 60).Eval(); v.Width() != 12 || v.Uint() != 0xabc {
+		t.Fatal(v)
+	}
+
+	// bits past the width are unknown
+	if v := Slice(NewVector(2, 3), 3, 1).Eval(); !Eq(v, NewLogicVector(L1, LX, LX)) {
+		t.Fatal(v)
+	}
+
+	// reversed slices have no bits, and are reported by Check
+	if v := a.Slice(3, 4); v.Width() != 0 {
+		t.Fatal(v)
+	}
+	if v := LogicOf(a).Slice(0, 1); v.Width() != 0 {
+		t.Fatal(v)
+	}
+	if v := Slice(a, 3, 4).Eval(); v.Width() != 0 {
+		t.Fatal(v)
+	}
+	if _, diags := Check(Slice(a, 3, 4)); len(Errors(diags)) == 0 {
+		t.Fatal("reversed slice not reported")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	a := NewVector(8, 0xa5)
+	for i := uint64(0); i < 8; i++ {
+		v := Index(a, NewVector(3, i)).Eval()
+		if v.Width() != 1 || v.True() != (0xa5>>i&1 == 1) {
+			t.Fatal(i, v)
+		}
+	}
+	if v := Index(a, NewVector(4, 8)).Eval(); !Eq(v, Unknown(1)) {
+		t.Fatal(v)
+	}
+	if v := Index(a, Unknown(3)).Eval(); !Eq(v, Unknown(1)) {
+		t.Fatal(v)
+	}
+	if v := Index(NewLogicVector(L0, LZ), T).Eval(); !Eq(v, HighZ(1)) {
+		t.Fatal(v)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	a := NewVector(8, 0xa5)
+	b := T
+
+	// {a[7:4], b, 2'b01}
+	cat := Concat(Slice(a, 7, 4), b, NewVector(2, 1))
+	if v := cat.Eval(); v.Width() != 7 || v.Uint() != 0x55 {
+		t.Fatal(v)
+	}
+
+	v := Concat(NewVector(64, 1), NewVector(64, 2), NewVector(8, 3)).Eval()
+	want := new(big.Int).Lsh(big.NewInt(1), 72)
+	want.Or(want, big.NewInt(0x203))
+	if v.Width() != 136 || vectorOf(v).Big().Cmp(want) != 0 {
+		t.Fatal(vectorOf(v).Big().Text(16))
+	}
+
+	if v := Concat(Unknown(1), NewVector(2, 2)).Eval(); !Eq(v, NewLogicVector(L0, L1, LX)) {
+		t.Fatal(v)
+	}
+
+	if v := Replicate(3, NewVector(2, 1)).Eval(); v.Width() != 6 || v.Uint() != 0x15 {
+		t.Fatal(v)
+	}
+	if v := Replicate(2, Concat(T, F)).Eval(); v.Width() != 4 || v.Uint() != 0xa {
+		t.Fatal(v)
+	}
+}
+
+func TestSize(t *testing.T) {
+	for _, tc := range []struct {
+		e    Expr
+		want Value
+	}{
+		{Size(4, NewVector(8, 0xa5)), NewVector(4, 5)},
+		{Size(4, NewSigned(8, -3)), NewSigned(4, -3)},
+		{Size(16, NewVector(8, 0xa5)), NewVector(16, 0xa5)},
+		{Size(16, NewSigned(8, -3)), NewSigned(16, -3)},
+		{Size(8, Add(NewVector(8, 200), NewVector(8, 100))), NewVector(8, 44)},
+		{Size(2, NewLogicVector(LX, L1, L0)), NewLogicVector(LX, L1)},
+	} {
+		v := tc.e.Eval()
+		if !Eq(v, tc.want) || v.Width() != tc.want.Width() || v.Signed() != tc.want.Signed() {
+			t.Fatal(Format(tc.e), v)
+		}
+	}
+}
+
+func TestWalkSlices(t *testing.T) {
+	a := NewVector(8, 0xa5)
+	b := Bool(true)
+	expr := Concat(Slice(a, 7, 4), Index(a, NewVector(3, 1)), Replicate(2, &b))
+
+	count := 0
+	err := Walk(expr, func(e Expr, deps []Expr) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Fatal(count)
+	}
+}
@@ -0,0 +1,180 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Precedence levels of the printed expressions, from the conditional
+// operator to the primaries. Binary operators sit in between, following
+// the levels of the parser.
+var (
+	precIf      = 0
+	precUnary   = len(binaryLevels) + 1
+	precPrimary = precUnary + 1
+	binaryPrec  = make(map[Op]int)
+)
+
+func init() {
+	for i, level := range binaryLevels {
+		for _, op := range level {
+			binaryPrec[op] = i + 1
+		}
+	}
+}
+
+// Format prints e as a Verilog expression, adding parentheses only where
+// the precedence of the operators requires them. Values are printed as
+// sized literals, negative ones with a leading minus, except for 32-bit
+// signed non negative values, which are exactly the Verilog unsized
+// decimal literals. A node reached again
+// through a cycle is printed as "...".
+func Format(e Expr) string {
+	f := &formatter{visiting: make(map[Expr]bool)}
+	f.format(e)
+	return f.sb.String()
+}
+
+type formatter struct {
+	sb       strings.Builder
+	visiting map[Expr]bool
+}
+
+// precedence returns the precedence level of e and whether it is printed
+// with a leading unary operator.
+func precedence(e Expr) (int, bool) {
+	switch e := e.(type) {
+	case *UnaryExpr:
+		return precUnary, true
+	case *BinaryExpr:
+		return binaryPrec[e.Op], false
+	case *IfExpr:
+		return precIf, false
+	case Value:
+		if strings.HasPrefix(literal(e), "-") {
+			return precUnary, true
+		}
+	}
+	return precPrimary, false
+}
+
+// operand prints x, in parentheses if it binds looser than prec.
+func (f *formatter) operand(x Expr, prec int) {
+	if p, _ := precedence(x); p < prec {
+		f.sb.WriteByte('(')
+		f.format(x)
+		f.sb.WriteByte(')')
+		return
+	}
+	f.format(x)
+}
+
+func (f *formatter) format(e Expr) {
+	if e == nil {
+		f.sb.WriteString("<nil>")
+		return
+	}
+	if f.visiting[e] {
+		f.sb.WriteString("...")
+		return
+	}
+	f.visiting[e] = true
+	defer delete(f.visiting, e)
+
+	switch e := e.(type) {
+	case *Var:
+		f.sb.WriteString(e.Name)
+	case *Bool:
+		f.sb.WriteString(literal(e))
+	case *Vector:
+		f.sb.WriteString(literal(e))
+	case *LogicVector:
+		f.sb.WriteString(literal(e))
+	case *UnaryExpr:
+		f.sb.WriteString(e.Op.String())
+		// avoid merging with the operator, as in - -a or & &a
+		if _, unary := precedence(e.Expr); unary {
+			f.operand(e.Expr, precPrimary+1)
+		} else {
+			f.operand(e.Expr, precUnary)
+		}
+	case *BinaryExpr:
+		prec := binaryPrec[e.Op]
+		f.operand(e.Expr1, prec)
+		f.sb.WriteString(" " + e.Op.String() + " ")
+		f.operand(e.Expr2, prec+1)
+	case *IfExpr:
+		f.operand(e.Cond, precIf+1)
+		f.sb.WriteString(" ? ")
+		f.format(e.If)
+		f.sb.WriteString(" : ")
+		f.format(e.Else)
+	case *SliceExpr:
+		f.operand(e.Expr, precPrimary)
+		fmt.Fprintf(&f.sb, "[%d:%d]", e.High, e.Low)
+	case *IndexExpr:
+		f.operand(e.Expr, precPrimary)
+		f.sb.WriteByte('[')
+		f.format(e.Index)
+		f.sb.WriteByte(']')
+	case *ConcatExpr:
+		f.sb.WriteByte('{')
+		for i, x := range e.Exprs {
+			if i > 0 {
+				f.sb.WriteString(", ")
+			}
+			f.format(x)
+		}
+		f.sb.WriteByte('}')
+	case *ReplicateExpr:
+		fmt.Fprintf(&f.sb, "{%d{", e.Count)
+		f.format(e.Expr)
+		f.sb.WriteString("}}")
+	case *CastExpr:
+		if e.Signed {
+			f.sb.WriteString("$signed(")
+		} else {
+			f.sb.WriteString("$unsigned(")
+		}
+		f.format(e.Expr)
+		f.sb.WriteByte(')')
+	case *SizeExpr:
+		fmt.Fprintf(&f.sb, "%d'(", e.Width)
+		f.format(e.Expr)
+		f.sb.WriteByte(')')
+	default:
+		fmt.Fprint(&f.sb, e)
+	}
+}
+
+// literal returns the Verilog literal of v.
+func literal(v Value) string {
+	s := ""
+	if v.Signed() {
+		s = "s"
+	}
+	switch v := v.(type) {
+	case *Bool:
+		if v.True() {
+			return "1'b1"
+		}
+		return "1'b0"
+	case *LogicVector:
+		if !v.Known() {
+			return fmt.Sprintf("%d'%sb%s", v.width, s, v)
+		}
+	}
+
+	vec := vectorOf(v)
+	x := vec.Big()
+	switch {
+	case x.Sign() < 0 && uint(x.BitLen()) < vec.width:
+		return fmt.Sprintf("-%d'sd%s", vec.width, x.Neg(x))
+	case x.Sign() < 0:
+		// the most negative value has no positive counterpart
+		return fmt.Sprintf("%d'sh%x", vec.width, vec.WithSign(false).Big())
+	case vec.signed && vec.width == 32:
+		return x.String()
+	}
+	return fmt.Sprintf("%d'%sd%s", vec.width, s, x)
+}
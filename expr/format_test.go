@@ -0,0 +1,92 @@
+package expr
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	b := NewVar("b", NewVector(8, 2))
+	c := NewVar("c", NewVector(8, 3))
+	s := NewVar("s", T)
+
+	tests := []struct {
+		e    Expr
+		repr string
+	}{
+		{a, "a"},
+		{T, "1'b1"},
+		{NewVector(8, 42), "8'd42"},
+		{NewSigned(8, 42), "8'sd42"},
+		{NewSigned(8, -1), "-8'sd1"},
+		{NewSigned(8, -128), "8'sh80"},
+		{NewSigned(32, 7), "7"},
+		{NewLogicVector(L0, L1, LX, LZ), "4'bzx10"},
+		{Add(a, Mul(b, c)), "a + b * c"},
+		{Mul(Add(a, b), c), "(a + b) * c"},
+		{Sub(a, Sub(b, c)), "a - (b - c)"},
+		{Sub(Sub(a, b), c), "a - b - c"},
+		{Sub(a, Neg(b)), "a - -b"},
+		{Neg(Neg(a)), "-(-a)"},
+		{RedAnd(RedAnd(a)), "&(&a)"},
+		{BitNot(Add(a, b)), "~(a + b)"},
+		{BitNot(NewSigned(8, -1)), "~(-8'sd1)"},
+		{Or(And(s, s), s), "s && s || s"},
+		{And(Or(s, s), s), "(s || s) && s"},
+		{BitAnd(a, Equal(b, c)), "a & b == c"},
+		{Equal(BitAnd(a, b), c), "(a & b) == c"},
+		{&IfExpr{s, a, &IfExpr{s, b, c}}, "s ? a : s ? b : c"},
+		{&IfExpr{&IfExpr{s, s, s}, a, b}, "(s ? s : s) ? a : b"},
+		{Add(&IfExpr{s, a, b}, c), "(s ? a : b) + c"},
+		{Slice(Add(a, b), 3, 0), "(a + b)[3:0]"},
+		{Index(a, NewSigned(32, 2)), "a[2]"},
+		{Concat(a, Replicate(2, b)), "{a, {2{b}}}"},
+		{AsSigned(a), "$signed(a)"},
+		{Size(16, Add(a, b)), "16'(a + b)"},
+		{Shl(a, AShr(b, c)), "a << (b >>> c)"},
+	}
+	for _, test := range tests {
+		if repr := Format(test.e); repr != test.repr {
+			t.Fatal(repr, test.repr)
+		}
+	}
+
+	cycle := &BinaryExpr{a, nil, OpAdd}
+	cycle.Expr2 = cycle
+	if repr := Format(cycle); repr != "a + (...)" {
+		t.Fatal(repr)
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	env := MapEnv{
+		"a": NewVar("a", NewVector(8, 0xa5)),
+		"b": NewVar("b", NewSigned(8, -3)),
+		"c": NewVar("c", T),
+	}
+	for _, x := range []string{
+		"a + b * 3",
+		"(a + b) * 3",
+		"a - (b - 2)",
+		"-(-b) >>> 1",
+		"c ? a : c ? b : 8'hx1",
+		"(c ? a : b) + 1",
+		"{a[3:0], {2{c}}} & ~a",
+		"&(&a) || !c",
+		"$signed(a) < b",
+		"16'(a + b) >> 4'(b)",
+		"a ^ b == 8'sd12 | b",
+		"-8'sd128 * b",
+	} {
+		e, err := Parse(x, env)
+		if err != nil {
+			t.Fatal(x, err)
+		}
+		repr := Format(e)
+		r, err := Parse(repr, env)
+		if err != nil {
+			t.Fatal(x, repr, err)
+		}
+		if Format(r) != repr || !Eq(r.Eval(), e.Eval()) {
+			t.Fatal(x, repr, Format(r))
+		}
+	}
+}
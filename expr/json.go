@@ -0,0 +1,322 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The JSON form of an expression lists its nodes, each with an id equal to
+// its position, and names the root node:
+//
+//	{"root": 0, "nodes": [
+//		{"id": 0, "kind": "binary", "op": "+", "args": [1, 3]},
+//		{"id": 1, "kind": "var", "name": "a", "args": [2]},
+//		{"id": 2, "kind": "vector", "width": 8, "bits": "00000001"},
+//		{"id": 3, "kind": "vector", "width": 8, "signed": true, "bits": "11111111"}
+//	]}
+//
+// Operands are referenced by id in args, so shared nodes appear once and
+// cycles are kept. The kinds of nodes are:
+//
+//	bool, vector, logic  constant values of width bits, most significant
+//	                     first, as 0, 1, x or z; only logic has x and z
+//	var                  a named signal, with its value in args if it has one,
+//	                     which is free if so marked
+//	unary, binary        an operator, written as its Verilog token
+//	if                   args are the condition and both branches
+//	slice                the bits high to low of its operand
+//	index                args are the operand and the index
+//	concat               args are joined most significant first
+//	replicate            count copies of its operand
+//	cast                 its operand read as signed or unsigned
+//	size                 its operand resized to width bits
+//
+// Nodes are numbered in depth first order from the root, so equal trees
+// have equal encodings. Bools are decoded as T and F, so all Bools of the
+// same value are encoded as a single node.
+type jsonExpr struct {
+	Root  int        `json:"root"`
+	Nodes []jsonNode `json:"nodes"`
+}
+
+type jsonNode struct {
+	ID     int    `json:"id"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Op     string `json:"op,omitempty"`
+	Width  uint   `json:"width,omitempty"`
+	Signed bool   `json:"signed,omitempty"`
+	Bits   string `json:"bits,omitempty"`
+	High   uint   `json:"high,omitempty"`
+	Low    uint   `json:"low,omitempty"`
+	Count  uint   `json:"count,omitempty"`
+	Free   bool   `json:"free,omitempty"`
+	Args   []int  `json:"args,omitempty"`
+}
+
+// Marshal returns the JSON encoding of e. It fails on nodes of types
+// defined outside of this package.
+func Marshal(e Expr) ([]byte, error) {
+	enc := &encoder{ids: make(map[Expr]int)}
+	root, err := enc.node(e)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonExpr{root, enc.nodes})
+}
+
+type encoder struct {
+	ids   map[Expr]int
+	nodes []jsonNode
+}
+
+func (enc *encoder) node(e Expr) (int, error) {
+	if b, ok := e.(*Bool); ok {
+		e = boolValue(bool(*b)) // T and F are the only Bools decoded
+	}
+	if id, ok := enc.ids[e]; ok {
+		return id, nil
+	}
+	id := len(enc.nodes)
+	enc.ids[e] = id
+	enc.nodes = append(enc.nodes, jsonNode{})
+
+	n := jsonNode{ID: id}
+	switch e := e.(type) {
+	case *Bool:
+		n.Kind, n.Width, n.Bits = "bool", 1, LogicOf(e).String()
+	case *Vector:
+		n.Kind, n.Width, n.Signed, n.Bits = "vector", e.width, e.signed, LogicOf(e).String()
+	case *LogicVector:
+		n.Kind, n.Width, n.Signed, n.Bits = "logic", e.width, e.signed, e.String()
+	case *Var:
+		n.Kind, n.Name, n.Free = "var", e.Name, e.Free
+	case *UnaryExpr:
+		n.Kind, n.Op = "unary", e.Op.String()
+	case *BinaryExpr:
+		n.Kind, n.Op = "binary", e.Op.String()
+	case *IfExpr:
+		n.Kind = "if"
+	case *SliceExpr:
+		n.Kind, n.High, n.Low = "slice", e.High, e.Low
+	case *IndexExpr:
+		n.Kind = "index"
+	case *ConcatExpr:
+		n.Kind = "concat"
+	case *ReplicateExpr:
+		n.Kind, n.Count = "replicate", e.Count
+	case *CastExpr:
+		n.Kind, n.Signed = "cast", e.Signed
+	case *SizeExpr:
+		n.Kind, n.Width = "size", e.Width
+	default:
+		return 0, fmt.Errorf("expr: cannot marshal %T", e)
+	}
+
+	args := Children(e)
+	if v, ok := e.(*Var); ok && v.Value != nil {
+		args = []Expr{v.Value}
+	}
+	for _, x := range args {
+		arg, err := enc.node(x)
+		if err != nil {
+			return 0, err
+		}
+		n.Args = append(n.Args, arg)
+	}
+	enc.nodes[id] = n
+	return id, nil
+}
+
+// Unmarshal decodes an expression encoded by Marshal.
+func Unmarshal(data []byte) (Expr, error) {
+	var doc jsonExpr
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	nodes := make([]Expr, len(doc.Nodes))
+	for i, n := range doc.Nodes {
+		if n.ID != i {
+			return nil, fmt.Errorf("expr: node %d has id %d", i, n.ID)
+		}
+		e, err := newNode(n)
+		if err != nil {
+			return nil, fmt.Errorf("expr: node %d: %v", i, err)
+		}
+		nodes[i] = e
+	}
+	for i, n := range doc.Nodes {
+		if err := link(nodes[i], n, nodes); err != nil {
+			return nil, fmt.Errorf("expr: node %d: %v", i, err)
+		}
+	}
+	if doc.Root < 0 || doc.Root >= len(nodes) {
+		return nil, fmt.Errorf("expr: root %d out of range", doc.Root)
+	}
+	return nodes[doc.Root], nil
+}
+
+// binaryOp returns the binary operator of a token.
+func binaryOp(name string) (Op, bool) {
+	for _, level := range binaryLevels {
+		if op, ok := level[name]; ok {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+// newNode returns the node of n without its operands.
+func newNode(n jsonNode) (Expr, error) {
+	switch n.Kind {
+	case "bool", "vector", "logic":
+		return decodeValue(n)
+	case "var":
+		return &Var{Name: n.Name, Free: n.Free}, nil
+	case "unary":
+		op, ok := unaryOps[n.Op]
+		if !ok {
+			return nil, fmt.Errorf("unknown unary operator %q", n.Op)
+		}
+		return &UnaryExpr{Op: op}, nil
+	case "binary":
+		op, ok := binaryOp(n.Op)
+		if !ok {
+			return nil, fmt.Errorf("unknown binary operator %q", n.Op)
+		}
+		return &BinaryExpr{Op: op}, nil
+	case "if":
+		return &IfExpr{}, nil
+	case "slice":
+		if n.High < n.Low {
+			return nil, fmt.Errorf("slice [%d:%d] is reversed", n.High, n.Low)
+		}
+		return &SliceExpr{High: n.High, Low: n.Low}, nil
+	case "index":
+		return &IndexExpr{}, nil
+	case "concat":
+		return &ConcatExpr{}, nil
+	case "replicate":
+		return &ReplicateExpr{Count: n.Count}, nil
+	case "cast":
+		return &CastExpr{Signed: n.Signed}, nil
+	case "size":
+		if n.Width == 0 {
+			return nil, fmt.Errorf("size cast to 0 bits")
+		}
+		return &SizeExpr{Width: n.Width}, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", n.Kind)
+}
+
+func decodeValue(n jsonNode) (Value, error) {
+	if uint(len(n.Bits)) != n.Width || n.Width == 0 {
+		return nil, fmt.Errorf("%d bits for width %d", len(n.Bits), n.Width)
+	}
+	lv := newLogicVector(n.Width)
+	for i, c := range n.Bits {
+		var l Logic
+		switch c {
+		case '0':
+			l = L0
+		case '1':
+			l = L1
+		case 'x':
+			l = LX
+		case 'z':
+			l = LZ
+		default:
+			return nil, fmt.Errorf("invalid bit %q", c)
+		}
+		lv.SetBit(n.Width-1-uint(i), l)
+	}
+	lv.signed = n.Signed
+
+	switch {
+	case n.Kind == "logic":
+		return lv, nil
+	case !lv.Known():
+		return nil, fmt.Errorf("%s has unknown bits", n.Kind)
+	case n.Kind == "vector":
+		return lv.vector().WithSign(n.Signed), nil
+	case n.Width != 1 || n.Signed:
+		return nil, fmt.Errorf("bool must have a single unsigned bit")
+	}
+	return boolValue(lv.Uint() == 1), nil
+}
+
+// link sets the operands of e from the args of n.
+func link(e Expr, n jsonNode, nodes []Expr) error {
+	args := make([]Expr, len(n.Args))
+	for i, id := range n.Args {
+		if id < 0 || id >= len(nodes) {
+			return fmt.Errorf("operand %d out of range", id)
+		}
+		args[i] = nodes[id]
+	}
+	want := len(args)
+	switch e := e.(type) {
+	case Value:
+		want = 0
+	case *Var:
+		if len(args) > 1 {
+			want = 1
+			break
+		}
+		for _, x := range args {
+			v, ok := x.(Value)
+			if !ok {
+				return fmt.Errorf("value of %s is not constant", e.Name)
+			}
+			e.Value = v
+		}
+	case *UnaryExpr:
+		want = 1
+		if len(args) == want {
+			e.Expr = args[0]
+		}
+	case *BinaryExpr:
+		want = 2
+		if len(args) == want {
+			e.Expr1, e.Expr2 = args[0], args[1]
+		}
+	case *IfExpr:
+		want = 3
+		if len(args) == want {
+			e.Cond, e.If, e.Else = args[0], args[1], args[2]
+		}
+	case *SliceExpr:
+		want = 1
+		if len(args) == want {
+			e.Expr = args[0]
+		}
+	case *IndexExpr:
+		want = 2
+		if len(args) == want {
+			e.Expr, e.Index = args[0], args[1]
+		}
+	case *ConcatExpr:
+		if len(args) > 0 {
+			e.Exprs = args
+		}
+	case *ReplicateExpr:
+		want = 1
+		if len(args) == want {
+			e.Expr = args[0]
+		}
+	case *CastExpr:
+		want = 1
+		if len(args) == want {
+			e.Expr = args[0]
+		}
+	case *SizeExpr:
+		want = 1
+		if len(args) == want {
+			e.Expr = args[0]
+		}
+	}
+	if len(args) != want {
+		return fmt.Errorf("%s has %d operands, want %d", n.Kind, len(args), want)
+	}
+	return nil
+}
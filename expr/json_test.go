@@ -0,0 +1,136 @@
+package expr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// roundTrip checks that e is decoded to an equal tree which encodes to the
+// same JSON.
+func roundTrip(t *testing.T, e Expr) Expr {
+	t.Helper()
+	data, err := Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err, string(data))
+	}
+	again, err := Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Fatalf("%s\n%s", data, again)
+	}
+	if Format(d) != Format(e) || reflect.TypeOf(d) != reflect.TypeOf(e) {
+		t.Fatal(Format(d), Format(e))
+	}
+	return d
+}
+
+func TestJSON(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	s := NewVar("s", NewSigned(8, -3))
+	x := NewVar("x", nil)
+	f := Free("f", Type{8, true})
+	for _, e := range []Expr{
+		T,
+		F,
+		NewVector(130, 7),
+		NewSigned(8, -128),
+		NewLogicVector(L0, L1, LX, LZ).WithSign(true),
+		a,
+		x,
+		Not(x),
+		Add(a, Neg(s)),
+		Sub(f, s),
+		&IfExpr{x, a, s},
+		Slice(a, 6, 2),
+		Index(a, s),
+		Concat(a, s, T),
+		Concat(),
+		Replicate(3, a),
+		AsSigned(a),
+		AsUnsigned(s),
+		Size(4, Add(a, s)),
+	} {
+		d := roundTrip(t, e)
+		if v, ok := e.(Value); ok && !v.Eq(d.(Value)) {
+			t.Fatal(v, d)
+		}
+		free := false
+		Walk(e, func(e Expr, _ []Expr) error {
+			free = free || e == x
+			return nil
+		})
+		if !free && !Eq(e.Eval(), d.Eval()) {
+			t.Fatal(Format(e), e.Eval(), d.Eval())
+		}
+	}
+}
+
+func TestJSONShared(t *testing.T) {
+	a := NewVar("a", NewVector(8, 3))
+	sum := Add(a, a)
+	d := roundTrip(t, Mul(sum, sum)).(*BinaryExpr)
+	if d.Expr1 != d.Expr2 || d.Expr1.(*BinaryExpr).Expr1 != d.Expr1.(*BinaryExpr).Expr2 {
+		t.Fatal("sharing is lost")
+	}
+	if d.Eval().Uint() != 36 {
+		t.Fatal(d.Eval())
+	}
+
+	loop := &BinaryExpr{a, nil, OpAdd}
+	loop.Expr2 = loop
+	if d := roundTrip(t, loop).(*BinaryExpr); d.Expr2 != d {
+		t.Fatal("cycle is lost")
+	}
+}
+
+func TestJSONRandom(t *testing.T) {
+	for seed := int64(0); seed < 100; seed++ {
+		g := newExprGen(seed)
+		e := g.expr(5)
+		if d := roundTrip(t, e); !Eq(d.Eval(), e.Eval()) {
+			t.Fatal(seed, Format(e))
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	e, err := Unmarshal([]byte(`{"root": 0, "nodes": [
+		{"id": 0, "kind": "binary", "op": "+", "args": [1, 3]},
+		{"id": 1, "kind": "var", "name": "a", "args": [2]},
+		{"id": 2, "kind": "vector", "width": 8, "bits": "00000001"},
+		{"id": 3, "kind": "vector", "width": 8, "signed": true, "bits": "11111111"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Format(e); s != "a + -8'sd1" {
+		t.Fatal(s)
+	}
+
+	for _, data := range []string{
+		`[]`,
+		`{"root": 1, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}`,
+		`{"root": 0, "nodes": [{"id": 1, "kind": "bool", "width": 1, "bits": "1"}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "x"}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "vector", "width": 2, "bits": "1"}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "logic", "width": 1, "bits": "u"}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "node"}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "unary", "op": "+", "args": [0]}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "binary", "op": "!", "args": [0, 0]}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "if", "args": [0, 0]}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "slice", "high": 1, "low": 2, "args": [0]}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "index", "args": [0, 1]}]}`,
+		`{"root": 0, "nodes": [{"id": 0, "kind": "var", "name": "a", "args": [0]}]}`,
+	} {
+		if _, err := Unmarshal([]byte(data)); err == nil {
+			t.Error(data)
+		}
+	}
+}
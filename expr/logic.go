@@ -0,0 +1,307 @@
+package expr
+
+import "strings"
+
+// Logic is the state of a single bit of a four-state value.
+type Logic uint8 // zero value represents X undefined
+
+const (
+	LX Logic = iota
+	L1
+	L0
+	LZ
+)
+
+func (l Logic) String() string {
+	return [...]string{"x", "1", "0", "z"}[l]
+}
+
+// LogicVector is a four-state (0, 1, X, Z) bit vector of arbitrary width.
+// Each bit is encoded in a pair of words as in the Verilog PLI:
+//
+//	aval bval
+//	   0    0  0
+//	   1    0  1
+//	   0    1  Z
+//	   1    1  X
+type LogicVector struct {
+	aval, bval []uint64
+	width      uint
+	signed     bool
+}
+
+func newLogicVector(width uint) *LogicVector {
+	n := numWords(width)
+	return &LogicVector{make([]uint64, n), make([]uint64, n), width, false}
+}
+
+// Unknown returns a vector with all bits set to X.
+func Unknown(width uint) *LogicVector {
+	lv := newLogicVector(width)
+	for i := range lv.aval {
+		lv.aval[i] = ^uint64(0)
+		lv.bval[i] = ^uint64(0)
+	}
+	lv.truncate()
+	return lv
+}
+
+// HighZ returns a vector with all bits set to Z.
+func HighZ(width uint) *LogicVector {
+	lv := newLogicVector(width)
+	for i := range lv.bval {
+		lv.bval[i] = ^uint64(0)
+	}
+	lv.truncate()
+	return lv
+}
+
+// NewLogicVector builds a vector from its bits, least significant first.
+func NewLogicVector(bits ...Logic) *LogicVector {
+	lv := newLogicVector(uint(len(bits)))
+	for i, b := range bits {
+		lv.SetBit(uint(i), b)
+	}
+	return lv
+}
+
+// LogicOf converts v to a four-state vector.
+func LogicOf(v Value) *LogicVector {
+	if lv, ok := v.(*LogicVector); ok {
+		return lv
+	}
+	vec := vectorOf(v)
+	lv := newLogicVector(vec.width)
+	copy(lv.aval, vec.words)
+	lv.signed = vec.signed
+	return lv
+}
+
+func (lv *LogicVector) truncate() {
+	if rem := lv.width % wordSize; rem != 0 {
+		lv.aval[len(lv.aval)-1] &= 1<<rem - 1
+		lv.bval[len(lv.bval)-1] &= 1<<rem - 1
+	}
+}
+
+func (lv *LogicVector) word(i int) (a, b uint64) {
+	if i < len(lv.aval) {
+		return lv.aval[i], lv.bval[i]
+	}
+	return 0, 0
+}
+
+func (lv *LogicVector) Bit(i uint) Logic {
+	a, b := lv.word(int(i / wordSize))
+	a = a >> (i % wordSize) & 1
+	b = b >> (i % wordSize) & 1
+	switch {
+	case a == 0 && b == 0:
+		return L0
+	case b == 0:
+		return L1
+	case a == 0:
+		return LZ
+	}
+	return LX
+}
+
+func (lv *LogicVector) SetBit(i uint, l Logic) {
+	w, mask := i/wordSize, uint64(1)<<(i%wordSize)
+	lv.aval[w] &^= mask
+	lv.bval[w] &^= mask
+	if l == L1 || l == LX {
+		lv.aval[w] |= mask
+	}
+	if l == LZ || l == LX {
+		lv.bval[w] |= mask
+	}
+}
+
+// WithSign returns lv read as a signed or an unsigned number.
+func (lv *LogicVector) WithSign(signed bool) *LogicVector {
+	return &LogicVector{lv.aval, lv.bval, lv.width, signed}
+}
+
+// extend returns lv with width bits. If signed is set, the most significant
+// bit is replicated, be it known or not.
+func (lv *LogicVector) extend(width uint, signed bool) *LogicVector {
+	if width <= lv.width {
+		return lv
+	}
+	aval := (&Vector{lv.aval, lv.width, false}).extend(width, signed)
+	bval := (&Vector{lv.bval, lv.width, false}).extend(width, signed)
+	return &LogicVector{aval.words, bval.words, width, lv.signed}
+}
+
+// Slice returns the bits lv[high:low]. Bits past the width read as 0. A
+// reversed slice, with high < low, has no bits.
+func (lv *LogicVector) Slice(high, low uint) *LogicVector {
+	if high < low {
+		return newLogicVector(0)
+	}
+	r := newLogicVector(high - low + 1)
+	copyBits(r.aval, 0, lv.aval, low, r.width)
+	copyBits(r.bval, 0, lv.bval, low, r.width)
+	return r
+}
+
+// Known reports whether no bit is X or Z.
+func (lv *LogicVector) Known() bool {
+	for _, b := range lv.bval {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (lv *LogicVector) Eval() Value {
+	return lv
+}
+
+// True reports whether any bit is known to be 1, which is how Verilog
+// decides a condition.
+func (lv *LogicVector) True() bool {
+	return lv.reduceOr() == L1
+}
+
+// Eq compares all four states of each bit, like the Verilog === operator.
+func (lv *LogicVector) Eq(v Value) bool {
+	lv2 := LogicOf(v)
+	w, signed := max(lv.width, lv2.width), lv.signed && lv2.signed
+	lv1 := lv.extend(w, signed)
+	lv2 = lv2.extend(w, signed)
+	for i := range lv1.aval {
+		a1, b1 := lv1.word(i)
+		a2, b2 := lv2.word(i)
+		if a1 != a2 || b1 != b2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (lv *LogicVector) Width() uint {
+	return lv.width
+}
+
+func (lv *LogicVector) Signed() bool {
+	return lv.signed
+}
+
+// Uint returns the 64 least significant bits, reading X and Z as 0.
+func (lv *LogicVector) Uint() uint64 {
+	a, b := lv.word(0)
+	return a &^ b
+}
+
+func (lv *LogicVector) String() string {
+	var sb strings.Builder
+	for i := lv.width; i > 0; i-- {
+		sb.WriteString(lv.Bit(i - 1).String())
+	}
+	return sb.String()
+}
+
+// vector returns the two-state value of lv, reading X and Z as 0.
+func (lv *LogicVector) vector() *Vector {
+	vec := NewVector(lv.width, 0)
+	for i := range vec.words {
+		vec.words[i] = lv.aval[i] &^ lv.bval[i]
+	}
+	vec.signed = lv.signed
+	return vec
+}
+
+func (lv *LogicVector) reduceOr() Logic {
+	unknown := false
+	for i := range lv.aval {
+		if lv.aval[i]&^lv.bval[i] != 0 {
+			return L1
+		}
+		if lv.bval[i] != 0 {
+			unknown = true
+		}
+	}
+	if unknown {
+		return LX
+	}
+	return L0
+}
+
+// truth reduces v to a single logic bit as a condition is evaluated.
+func truth(v Value) Logic {
+	if lv, ok := v.(*LogicVector); ok {
+		return lv.reduceOr()
+	}
+	if v.True() {
+		return L1
+	}
+	return L0
+}
+
+func logicNot(l Logic) Logic {
+	switch l {
+	case L1:
+		return L0
+	case L0:
+		return L1
+	}
+	return LX
+}
+
+func logicAnd(l1, l2 Logic) Logic {
+	switch {
+	case l1 == L0 || l2 == L0:
+		return L0
+	case l1 == L1 && l2 == L1:
+		return L1
+	}
+	return LX
+}
+
+func logicOr(l1, l2 Logic) Logic {
+	switch {
+	case l1 == L1 || l2 == L1:
+		return L1
+	case l1 == L0 && l2 == L0:
+		return L0
+	}
+	return LX
+}
+
+func logicValue(l Logic) Value {
+	switch l {
+	case L1:
+		return &True
+	case L0:
+		return &False
+	}
+	return Unknown(1)
+}
+
+func unknown(v Value) bool {
+	lv, ok := v.(*LogicVector)
+	return ok && !lv.Known()
+}
+
+// merge combines the values of both branches of a conditional whose
+// condition is unknown: bits that agree are kept and all others become X.
+// Branches are extended as operands of a binary operator.
+func merge(v1, v2 Value) *LogicVector {
+	lv1, lv2 := LogicOf(v1), LogicOf(v2)
+	w, signed := max(lv1.width, lv2.width), lv1.signed && lv2.signed
+	lv1, lv2 = lv1.extend(w, signed), lv2.extend(w, signed)
+	lv := newLogicVector(w)
+	lv.signed = signed
+	for i := range lv.aval {
+		a1, b1 := lv1.word(i)
+		a2, b2 := lv2.word(i)
+		differ := a1 ^ a2 | b1 | b2
+		lv.aval[i] = a1 | differ
+		lv.bval[i] = differ
+	}
+	lv.truncate()
+	return lv
+}
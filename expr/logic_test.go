@@ -0,0 +1,72 @@
+package expr
+
+import "testing"
+
+func TestLogicVector(t *testing.T) {
+	lv := NewLogicVector(L0, L1, LX, LZ)
+	if s := lv.String(); s != "zx10" {
+		t.Fatal(s)
+	}
+	if lv.Known() || lv.Uint() != 2 {
+		t.Fatal(lv)
+	}
+	if !lv.True() {
+		t.Fatal(lv)
+	}
+	if Unknown(4).True() || HighZ(4).True() {
+		t.Fatal()
+	}
+
+	// === semantics
+	if !Eq(Unknown(70), Unknown(70)) || Eq(Unknown(70), HighZ(70)) {
+		t.Fatal()
+	}
+	if Eq(Unknown(1), F) || Eq(T, Unknown(1)) {
+		t.Fatal()
+	}
+	if !Eq(LogicOf(NewVector(100, 5)), NewVector(100, 5)) {
+		t.Fatal()
+	}
+}
+
+func TestLogicOps(t *testing.T) {
+	x := Unknown(1)
+	for _, tc := range []struct {
+		e    Expr
+		want Value
+	}{
+		{Not(x), x},
+		{Not(HighZ(1)), x},
+		{And(x, F), F},
+		{And(F, x), F},
+		{And(x, T), x},
+		{Or(x, T), T},
+		{Or(T, x), T},
+		{Or(x, F), x},
+		{Or(NewLogicVector(LX, L1), F), T},
+		{Add(NewVector(8, 1), NewLogicVector(L1, LX)), Unknown(8)},
+		{Add(NewVector(8, 1), LogicOf(NewVector(8, 2))), NewVector(8, 3)},
+	} {
+		if v := tc.e.Eval(); !Eq(v, tc.want) {
+			t.Fatal(v, tc.want)
+		}
+	}
+}
+
+func TestLogicIf(t *testing.T) {
+	a := NewLogicVector(L0, L1, L0, L1)
+	b := NewLogicVector(L0, L0, L1, L1)
+
+	if v := (&IfExpr{Unknown(1), a, b}).Eval(); !Eq(v, NewLogicVector(L0, LX, LX, L1)) {
+		t.Fatal(v)
+	}
+	if v := (&IfExpr{Unknown(1), a, a}).Eval(); !Eq(v, a) {
+		t.Fatal(v)
+	}
+	if v := (&IfExpr{NewLogicVector(LX, L1), a, b}).Eval(); !Eq(v, a) {
+		t.Fatal(v)
+	}
+	if v := (&IfExpr{NewLogicVector(LZ, L0), a, b}).Eval(); !Eq(v, NewLogicVector(L0, LX, LX, L1)) {
+		t.Fatal(v)
+	}
+}
@@ -0,0 +1,478 @@
+package expr
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// Op identifies the operator applied by a UnaryExpr or a BinaryExpr.
+type Op int
+
+const (
+	// unary operators
+	OpNot     Op = iota // !
+	OpBitNot            // ~
+	OpRedAnd            // &
+	OpRedOr             // |
+	OpRedXor            // ^
+	OpRedNand           // ~&
+	OpRedNor            // ~|
+	OpRedXnor           // ~^
+	OpNeg               // -
+
+	// binary operators
+	OpAnd     // &&
+	OpOr      // ||
+	OpBitAnd  // &
+	OpBitOr   // |
+	OpBitXor  // ^
+	OpBitXnor // ~^
+	OpAdd     // +
+	OpSub     // -
+	OpMul     // *
+	OpDiv     // /
+	OpMod     // %
+	OpEq      // ==
+	OpNe      // !=
+	OpLt      // <
+	OpLe      // <=
+	OpGt      // >
+	OpGe      // >=
+	OpShl     // <<
+	OpShr     // >>
+	OpAShl    // <<<
+	OpAShr    // >>>
+)
+
+var opNames = [...]string{
+	OpNot:     "!",
+	OpBitNot:  "~",
+	OpRedAnd:  "&",
+	OpRedOr:   "|",
+	OpRedXor:  "^",
+	OpRedNand: "~&",
+	OpRedNor:  "~|",
+	OpRedXnor: "~^",
+	OpNeg:     "-",
+	OpAnd:     "&&",
+	OpOr:      "||",
+	OpBitAnd:  "&",
+	OpBitOr:   "|",
+	OpBitXor:  "^",
+	OpBitXnor: "~^",
+	OpAdd:     "+",
+	OpSub:     "-",
+	OpMul:     "*",
+	OpDiv:     "/",
+	OpMod:     "%",
+	OpEq:      "==",
+	OpNe:      "!=",
+	OpLt:      "<",
+	OpLe:      "<=",
+	OpGt:      ">",
+	OpGe:      ">=",
+	OpShl:     "<<",
+	OpShr:     ">>",
+	OpAShl:    "<<<",
+	OpAShr:    ">>>",
+}
+
+// String returns the Verilog token of the operator.
+func (op Op) String() string {
+	return opNames[op]
+}
+
+func (op Op) Unary() bool {
+	return op <= OpNeg
+}
+
+// Width returns the width of the result of op applied to operands of
+// widths w1 and w2, following the Verilog rules for self-determined
+// expressions. Unary operators ignore w2.
+func (op Op) Width(w1, w2 uint) uint {
+	switch op {
+	case OpNot, OpRedAnd, OpRedOr, OpRedXor, OpRedNand, OpRedNor, OpRedXnor,
+		OpAnd, OpOr, OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		return 1
+	case OpBitNot, OpNeg, OpShl, OpShr, OpAShl, OpAShr:
+		return w1
+	}
+	return max(w1, w2)
+}
+
+func evalUnary(op Op, v Value) Value {
+	switch op {
+	case OpNot:
+		return logicValue(logicNot(truth(v)))
+	case OpRedAnd, OpRedOr, OpRedXor:
+		return logicValue(reduce(op, v))
+	case OpRedNand:
+		return logicValue(logicNot(reduce(OpRedAnd, v)))
+	case OpRedNor:
+		return logicValue(logicNot(reduce(OpRedOr, v)))
+	case OpRedXnor:
+		return logicValue(logicNot(reduce(OpRedXor, v)))
+	}
+
+	if lv, ok := v.(*LogicVector); ok {
+		switch {
+		case op == OpBitNot:
+			return lv.not()
+		case !lv.Known():
+			return Unknown(lv.width).WithSign(lv.signed)
+		}
+		return LogicOf(evalUnary(op, lv.vector()))
+	}
+
+	vec := vectorOf(v)
+	var r *Vector
+	switch op {
+	case OpBitNot:
+		r = NewVector(vec.width, 0)
+		for i := range r.words {
+			r.words[i] = ^vec.words[i]
+		}
+		r.truncate()
+	case OpNeg:
+		r = subVector(NewVector(vec.width, 0), vec)
+	default:
+		panic("not an unary operator: " + op.String())
+	}
+	r.signed = vec.signed
+	if _, ok := v.(*Bool); ok {
+		return boolValue(r.True())
+	}
+	return r
+}
+
+func evalBinary(op Op, v1, v2 Value) Value {
+	switch op {
+	case OpAnd:
+		return logicValue(logicAnd(truth(v1), truth(v2)))
+	case OpOr:
+		return logicValue(logicOr(truth(v1), truth(v2)))
+	}
+
+	lv1, ok1 := v1.(*LogicVector)
+	lv2, ok2 := v2.(*LogicVector)
+	if ok1 || ok2 {
+		return evalLogic(op, LogicOf(v1), LogicOf(v2), ok1 && !lv1.Known() || ok2 && !lv2.Known())
+	}
+
+	// operands are extended to the width of the operation and only
+	// treated as signed numbers when both are signed
+	a, b := vectorOf(v1), vectorOf(v2)
+	w := op.Width(a.width, b.width)
+	signed := a.signed && b.signed
+	switch op {
+	case OpShl, OpShr, OpAShl, OpAShr:
+		signed = a.signed
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		ew := max(a.width, b.width)
+		a, b = a.extend(ew, signed), b.extend(ew, signed)
+	default:
+		a, b = a.extend(w, signed), b.extend(w, signed)
+	}
+	a, b = a.WithSign(signed), b.WithSign(signed)
+
+	var r *Vector
+	switch op {
+	case OpBitAnd, OpBitOr, OpBitXor, OpBitXnor:
+		r = bitwise(op, a, b)
+	case OpAdd:
+		r = addVector(a, b)
+	case OpSub:
+		r = subVector(a, b)
+	case OpMul:
+		r = VectorFromBig(w, new(big.Int).Mul(a.Big(), b.Big()))
+	case OpDiv, OpMod:
+		if !b.True() {
+			// division by zero is undefined
+			return Unknown(w).WithSign(signed)
+		}
+		if op == OpDiv {
+			r = VectorFromBig(w, new(big.Int).Quo(a.Big(), b.Big()))
+		} else {
+			r = VectorFromBig(w, new(big.Int).Rem(a.Big(), b.Big()))
+		}
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		return boolValue(compare(op, cmpVector(a, b)))
+	case OpShl, OpShr, OpAShl, OpAShr:
+		r = shiftVector(op, a, shiftAmount(b.WithSign(false)))
+	default:
+		panic("not a binary operator: " + op.String())
+	}
+	r.signed = signed
+
+	_, bool1 := v1.(*Bool)
+	_, bool2 := v2.(*Bool)
+	if bool1 && bool2 {
+		return boolValue(r.True())
+	}
+	return r
+}
+
+// evalLogic applies op to four-state operands. Bitwise operators work bit by
+// bit, equality is unknown only when the known bits do not decide it and
+// every other operator yields X as soon as an operand has an X or Z bit.
+func evalLogic(op Op, a, b *LogicVector, unknown bool) Value {
+	w := op.Width(a.width, b.width)
+	signed := a.signed && b.signed
+	switch op {
+	case OpShl, OpShr, OpAShl, OpAShr:
+	case OpEq, OpNe:
+		ew := max(a.width, b.width)
+		a, b = a.extend(ew, signed), b.extend(ew, signed)
+	default:
+		a, b = a.extend(w, signed), b.extend(w, signed)
+	}
+
+	switch op {
+	case OpBitAnd, OpBitOr, OpBitXor, OpBitXnor:
+		return bitwiseLogic(op, a, b).WithSign(signed)
+	case OpEq, OpNe:
+		eq := L1
+		for i := range a.aval[:min(len(a.aval), len(b.aval))] {
+			if (a.aval[i]^b.aval[i])&^(a.bval[i]|b.bval[i]) != 0 {
+				eq = L0
+				break
+			}
+		}
+		if eq == L1 && unknown {
+			eq = LX
+		}
+		// known bits only present in the widest operand must be zero
+		for _, lv := range []*LogicVector{a, b} {
+			for i := min(len(a.aval), len(b.aval)); i < len(lv.aval); i++ {
+				if lv.aval[i]&^lv.bval[i] != 0 {
+					eq = L0
+				}
+			}
+		}
+		if op == OpNe {
+			eq = logicNot(eq)
+		}
+		return logicValue(eq)
+	case OpShl, OpShr, OpAShl, OpAShr:
+		if !b.Known() {
+			return Unknown(w).WithSign(a.signed)
+		}
+		n := shiftAmount(b.vector().WithSign(false))
+		aval := &Vector{a.aval, a.width, a.signed}
+		bval := &Vector{a.bval, a.width, a.signed}
+		return &LogicVector{
+			shiftVector(op, aval, n).words,
+			shiftVector(op, bval, n).words,
+			w,
+			a.signed,
+		}
+	}
+	if unknown {
+		if w == 1 {
+			return logicValue(LX)
+		}
+		return Unknown(w).WithSign(signed)
+	}
+	v := evalBinary(op, a.vector(), b.vector())
+	switch v.(type) {
+	case *Bool:
+		return v
+	}
+	return LogicOf(v)
+}
+
+// reduce applies a reduction &, | or ^ to the bits of v.
+func reduce(op Op, v Value) Logic {
+	lv, ok := v.(*LogicVector)
+	if !ok {
+		lv = LogicOf(v)
+	}
+	switch op {
+	case OpRedOr:
+		return lv.reduceOr()
+	case OpRedAnd:
+		unknown := false
+		for i := range lv.aval {
+			m := wordMask(lv.width, i)
+			if ^lv.aval[i]&^lv.bval[i]&m != 0 {
+				return L0
+			}
+			if lv.bval[i] != 0 {
+				unknown = true
+			}
+		}
+		if unknown {
+			return LX
+		}
+		return L1
+	case OpRedXor:
+		if !lv.Known() {
+			return LX
+		}
+		parity := 0
+		for _, a := range lv.aval {
+			parity += bits.OnesCount64(a)
+		}
+		if parity%2 == 1 {
+			return L1
+		}
+		return L0
+	}
+	panic("not a reduction operator: " + op.String())
+}
+
+// wordMask returns the bits of the i-th word which are within width.
+func wordMask(width uint, i int) uint64 {
+	if rem := width - uint(i)*wordSize; rem < wordSize {
+		return 1<<rem - 1
+	}
+	return ^uint64(0)
+}
+
+func bitwise(op Op, a, b *Vector) *Vector {
+	r := NewVector(max(a.width, b.width), 0)
+	for i := range r.words {
+		x, y := a.word(i), b.word(i)
+		switch op {
+		case OpBitAnd:
+			r.words[i] = x & y
+		case OpBitOr:
+			r.words[i] = x | y
+		case OpBitXor:
+			r.words[i] = x ^ y
+		case OpBitXnor:
+			r.words[i] = ^(x ^ y)
+		}
+	}
+	r.truncate()
+	return r
+}
+
+func bitwiseLogic(op Op, a, b *LogicVector) *LogicVector {
+	r := newLogicVector(max(a.width, b.width))
+	bitwiseInto(op, r, a, b)
+	return r
+}
+
+// bitwiseInto stores the result of a bitwise operator in r.
+func bitwiseInto(op Op, r, a, b *LogicVector) {
+	for i := range r.aval {
+		a1, b1 := a.word(i)
+		a2, b2 := b.word(i)
+		one1, one2 := a1&^b1, a2&^b2 // known ones
+		zero1, zero2 := ^a1&^b1, ^a2&^b2
+		var one, zero uint64
+		switch op {
+		case OpBitAnd:
+			one, zero = one1&one2, zero1|zero2
+		case OpBitOr:
+			one, zero = one1|one2, zero1&zero2
+		case OpBitXor:
+			one, zero = one1&zero2|zero1&one2, one1&one2|zero1&zero2
+		case OpBitXnor:
+			one, zero = one1&one2|zero1&zero2, one1&zero2|zero1&one2
+		}
+		unknown := ^(one | zero)
+		r.aval[i] = one | unknown
+		r.bval[i] = unknown
+	}
+	r.truncate()
+}
+
+func (lv *LogicVector) not() *LogicVector {
+	r := newLogicVector(lv.width)
+	for i := range r.aval {
+		r.aval[i] = ^lv.aval[i] | lv.bval[i]
+		r.bval[i] = lv.bval[i]
+	}
+	r.truncate()
+	r.signed = lv.signed
+	return r
+}
+
+func subVector(a, b *Vector) *Vector {
+	diff := NewVector(max(a.width, b.width), 0)
+	var borrow uint64
+	for i := range diff.words {
+		diff.words[i], borrow = bits.Sub64(a.word(i), b.word(i), borrow)
+	}
+	diff.truncate()
+	return diff
+}
+
+// cmpVector returns -1, 0 or 1 when a is less, equal or greater than b.
+// Signed vectors must have the same width.
+func cmpVector(a, b *Vector) int {
+	if a.signed && b.signed && a.width > 0 {
+		na, nb := a.Bit(a.width-1), b.Bit(b.width-1)
+		if na && !nb {
+			return -1
+		} else if !na && nb {
+			return 1
+		}
+	}
+	n := max(uint(len(a.words)), uint(len(b.words)))
+	for i := int(n) - 1; i >= 0; i-- {
+		x, y := a.word(i), b.word(i)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func compare(op Op, cmp int) bool {
+	switch op {
+	case OpEq:
+		return cmp == 0
+	case OpNe:
+		return cmp != 0
+	case OpLt:
+		return cmp < 0
+	case OpLe:
+		return cmp <= 0
+	case OpGt:
+		return cmp > 0
+	case OpGe:
+		return cmp >= 0
+	}
+	panic("not a comparison operator: " + op.String())
+}
+
+// shiftAmount returns the value of v saturated to the maximum uint.
+func shiftAmount(v *Vector) uint {
+	for _, w := range v.words[min(len(v.words), 1):] {
+		if w != 0 {
+			return ^uint(0)
+		}
+	}
+	n := v.word(0)
+	if n > uint64(^uint(0)) {
+		return ^uint(0)
+	}
+	return uint(n)
+}
+
+func shiftVector(op Op, vec *Vector, n uint) *Vector {
+	r := NewVector(vec.width, 0)
+	fill := op == OpAShr && vec.signed && vec.width > 0 && vec.Bit(vec.width-1)
+	for i := uint(0); i < vec.width; i++ {
+		var bit bool
+		switch op {
+		case OpShl, OpAShl:
+			bit = i >= n && vec.Bit(i-n)
+		case OpShr, OpAShr:
+			if n < vec.width-i {
+				bit = vec.Bit(i + n)
+			} else {
+				bit = fill
+			}
+		}
+		if bit {
+			r.words[i/wordSize] |= 1 << (i % wordSize)
+		}
+	}
+	return r
+}
@@ -0,0 +1,306 @@
+package expr
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func bigBool(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return big.NewInt(0)
+}
+
+var binaryRefs = map[Op]func(a, b *big.Int, w uint) *big.Int{
+	OpBitAnd:  func(a, b *big.Int, w uint) *big.Int { return new(big.Int).And(a, b) },
+	OpBitOr:   func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Or(a, b) },
+	OpBitXor:  func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Xor(a, b) },
+	OpBitXnor: func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Xor(new(big.Int).Xor(a, b), mask(w)) },
+	OpAdd:     func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Add(a, b) },
+	OpSub:     func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Sub(a, b) },
+	OpMul:     func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Mul(a, b) },
+	OpDiv:     func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Quo(a, b) },
+	OpMod:     func(a, b *big.Int, w uint) *big.Int { return new(big.Int).Rem(a, b) },
+	OpEq:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Cmp(b) == 0) },
+	OpNe:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Cmp(b) != 0) },
+	OpLt:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Cmp(b) < 0) },
+	OpLe:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Cmp(b) <= 0) },
+	OpGt:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Cmp(b) > 0) },
+	OpGe:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Cmp(b) >= 0) },
+	OpAnd:     func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Sign() != 0 && b.Sign() != 0) },
+	OpOr:      func(a, b *big.Int, w uint) *big.Int { return bigBool(a.Sign() != 0 || b.Sign() != 0) },
+}
+
+func TestBinaryOps(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for op, ref := range binaryRefs {
+		for _, wa := range testWidths {
+			for _, wb := range testWidths {
+				for i := 0; i < 10; i++ {
+					a, b := randBig(r, wa), randBig(r, wb)
+					switch i {
+					case 0:
+						b = new(big.Int).And(a, mask(wb))
+					case 1:
+						b = big.NewInt(1)
+					}
+					if (op == OpDiv || op == OpMod) && b.Sign() == 0 {
+						continue
+					}
+					w := op.Width(wa, wb)
+					want := new(big.Int).And(ref(a, b, w), mask(w))
+					got := (&BinaryExpr{VectorFromBig(wa, a), VectorFromBig(wb, b), op}).Eval()
+					if got.Width() != w {
+						t.Fatal(op, wa, wb, got.Width(), w)
+					}
+					if !Eq(got, VectorFromBig(w, want)) {
+						t.Fatal(op, wa, wb, a, b, vectorOf(got).Big(), want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestUnaryOps(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	for _, w := range testWidths {
+		for i := 0; i < 20; i++ {
+			a := randBig(r, w)
+			switch i {
+			case 0:
+				a.SetInt64(0)
+			case 1:
+				a = mask(w)
+			}
+			ones := 0
+			for j := 0; j < a.BitLen(); j++ {
+				ones += int(a.Bit(j))
+			}
+			vec := VectorFromBig(w, a)
+
+			for _, tc := range []struct {
+				op   Op
+				want *big.Int
+			}{
+				{OpNot, bigBool(a.Sign() == 0)},
+				{OpBitNot, new(big.Int).Xor(a, mask(w))},
+				{OpNeg, new(big.Int).And(new(big.Int).Neg(a), mask(w))},
+				{OpRedAnd, bigBool(a.Cmp(mask(w)) == 0)},
+				{OpRedNand, bigBool(a.Cmp(mask(w)) != 0)},
+				{OpRedOr, bigBool(a.Sign() != 0)},
+				{OpRedNor, bigBool(a.Sign() == 0)},
+				{OpRedXor, bigBool(ones%2 == 1)},
+				{OpRedXnor, bigBool(ones%2 == 0)},
+			} {
+				got := (&UnaryExpr{vec, tc.op}).Eval()
+				if got.Width() != tc.op.Width(w, 0) {
+					t.Fatal(tc.op, w, got.Width())
+				}
+				if !Eq(got, VectorFromBig(got.Width(), tc.want)) {
+					t.Fatal(tc.op, w, a, vectorOf(got).Big(), tc.want)
+				}
+			}
+		}
+	}
+}
+
+func TestShiftOps(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for _, w := range testWidths {
+		for i := 0; i < 10; i++ {
+			a := randBig(r, w)
+			msb := a.Bit(int(w) - 1)
+			for _, n := range []uint{0, 1, 3, 63, 64, 65, w - 1, w, w + 1} {
+				sh := NewVector(16, uint64(n))
+				shl := new(big.Int).And(new(big.Int).Lsh(a, n), mask(w))
+				shr := new(big.Int).Rsh(a, n)
+				ashr := new(big.Int).Set(shr)
+				if msb == 1 {
+					fill := mask(w)
+					if n < w {
+						fill = new(big.Int).Xor(mask(w), mask(w-n))
+					}
+					ashr.Or(ashr, fill)
+				}
+				for _, tc := range []struct {
+					e    *BinaryExpr
+					want *big.Int
+				}{
+					{Shl(vec(w, a), sh), shl},
+					{AShl(vec(w, a), sh), shl},
+					{Shr(vec(w, a), sh), shr},
+					{AShr(vec(w, a), sh), shr},
+					{AShr(vec(w, a).WithSign(true), sh), ashr},
+					{Shr(vec(w, a).WithSign(true), sh), shr},
+				} {
+					got := tc.e.Eval()
+					if got.Width() != w || !Eq(got, VectorFromBig(w, tc.want)) {
+						t.Fatal(tc.e.Op, w, n, a, vectorOf(got).Big(), tc.want)
+					}
+				}
+			}
+		}
+	}
+
+	// shift amounts wider than a word
+	huge := VectorFromBig(128, new(big.Int).Lsh(big.NewInt(1), 100))
+	if v := Shl(NewVector(8, 0xff), huge).Eval(); v.True() {
+		t.Fatal(v)
+	}
+}
+
+func vec(w uint, x *big.Int) *Vector {
+	return VectorFromBig(w, x)
+}
+
+func TestDivByZero(t *testing.T) {
+	for _, e := range []Expr{
+		Div(NewVector(8, 3), NewVector(8, 0)),
+		Mod(NewVector(8, 3), NewVector(4, 0)),
+	} {
+		if v := e.Eval(); !Eq(v, Unknown(8)) {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestBoolOps(t *testing.T) {
+	for _, tc := range []struct {
+		e    Expr
+		want Value
+	}{
+		{BitAnd(T, F), F},
+		{BitOr(T, F), T},
+		{BitXor(T, T), F},
+		{BitXnor(T, T), T},
+		{BitNot(T), F},
+		{Add(T, T), F},
+		{Less(F, T), T},
+	} {
+		v := tc.e.Eval()
+		if _, ok := v.(*Bool); !ok || !Eq(v, tc.want) {
+			t.Fatal(v, tc.want)
+		}
+	}
+}
+
+func TestLogicBitwise(t *testing.T) {
+	a := NewLogicVector(L0, L1, LX, LZ, L0, L1, LX, LZ, L0, L1, LX, LZ, L0, L1, LX, LZ)
+	b := NewLogicVector(L0, L0, L0, L0, L1, L1, L1, L1, LX, LX, LX, LX, LZ, LZ, LZ, LZ)
+	for _, tc := range []struct {
+		e    Expr
+		want string
+	}{
+		{BitAnd(a, b), "xxx0xxx0xx100000"},
+		{BitOr(a, b), "xx1xxx1x1111xx10"},
+		{BitXor(a, b), "xxxxxxxxxx01xx10"},
+		{BitXnor(a, b), "xxxxxxxxxx10xx01"},
+		{BitNot(a), "xx01xx01xx01xx01"},
+		{Shl(NewLogicVector(L1, LX, L0, LZ), NewVector(2, 1)), "0x10"},
+		{AShr(NewLogicVector(L1, LX, L0, LZ).WithSign(true), NewVector(2, 2)), "zzz0"},
+		{AShr(NewLogicVector(L1, LX, L0, LZ), NewVector(2, 2)), "00z0"},
+		{Shr(NewLogicVector(L1, L1), Unknown(2)), "xx"},
+		{Add(NewLogicVector(L1, L1), NewLogicVector(L1, LZ)), "xx"},
+		{Mul(NewLogicVector(L1, L1), NewVector(2, 1)), "11"},
+		{Neg(NewLogicVector(L1, LX)), "xx"},
+	} {
+		if v := tc.e.Eval().(*LogicVector); v.String() != tc.want {
+			t.Fatal(v, tc.want)
+		}
+	}
+}
+
+func TestLogicReduceCompare(t *testing.T) {
+	x := Unknown(1)
+	for _, tc := range []struct {
+		e    Expr
+		want Value
+	}{
+		{RedAnd(NewLogicVector(L1, LX, L0)), F},
+		{RedAnd(NewLogicVector(L1, LX, L1)), x},
+		{RedNand(NewLogicVector(L1, LX, L0)), T},
+		{RedOr(NewLogicVector(L1, LX, L0)), T},
+		{RedNor(NewLogicVector(LZ, L0)), x},
+		{RedXor(NewLogicVector(L1, LX)), x},
+		{RedXnor(NewLogicVector(L1, L0)), F},
+		{Equal(NewLogicVector(L1, LX), NewVector(2, 0)), F},
+		{Equal(NewLogicVector(L1, LX), NewVector(2, 1)), x},
+		{NotEqual(NewLogicVector(L1, LX), NewVector(2, 0)), T},
+		{Equal(LogicOf(NewVector(100, 7)), NewVector(100, 7)), T},
+		{Equal(NewLogicVector(L1, LX), NewVector(100, 1)), x},
+		{Less(NewLogicVector(L0, LX), NewVector(4, 15)), x},
+		{GreaterEq(LogicOf(NewVector(4, 3)), NewVector(4, 2)), T},
+	} {
+		if v := tc.e.Eval(); !Eq(v, tc.want) {
+			t.Fatal(v, tc.want)
+		}
+	}
+}
+
+func TestSignedOps(t *testing.T) {
+	for _, tc := range []struct {
+		e    Expr
+		want Value
+	}{
+		{Less(NewSigned(8, -1), NewSigned(8, 1)), T},
+		{Less(NewVector(8, 0xff), NewVector(8, 1)), F},
+		{Less(NewSigned(8, -1), NewVector(8, 1)), F}, // unsigned comparison
+		{Greater(NewSigned(4, -2), NewSigned(16, -3)), T},
+		{Equal(NewSigned(4, -1), NewSigned(16, -1)), T},
+		{Equal(NewSigned(4, -1), NewVector(16, 0xffff)), F},
+		{Mul(NewSigned(8, -3), NewSigned(8, 5)), NewSigned(8, -15)},
+		{Mul(NewSigned(4, -3), NewSigned(8, 5)), NewSigned(8, -15)},
+		{Div(NewSigned(8, -7), NewSigned(8, 2)), NewSigned(8, -3)},
+		{Mod(NewSigned(8, -7), NewSigned(8, 2)), NewSigned(8, -1)},
+		{Add(NewSigned(4, -1), NewSigned(8, 1)), NewSigned(8, 0)},
+		{Add(NewSigned(4, -1), NewVector(8, 1)), NewVector(8, 16)},
+		{Neg(NewSigned(8, 5)), NewSigned(8, -5)},
+		{AShr(NewSigned(8, -16), NewVector(3, 2)), NewSigned(8, -4)},
+		{AShr(NewSigned(8, -16), NewSigned(3, -4)), NewSigned(8, -1)}, // amount is unsigned
+		{AShr(AsUnsigned(NewSigned(8, -16)), NewVector(3, 2)), NewVector(8, 0x3c)},
+		{AShr(AsSigned(NewVector(8, 0xf0)), NewVector(3, 2)), NewSigned(8, -4)},
+		{BitAnd(NewSigned(4, -8), NewSigned(8, 0x7f)), NewSigned(8, 0x78)},
+		{Less(NewLogicVector(L1, L1).WithSign(true), LogicOf(NewSigned(2, 0)).WithSign(true)), T},
+	} {
+		v := tc.e.Eval()
+		if !Eq(v, tc.want) || v.Width() != tc.want.Width() || v.Signed() != tc.want.Signed() {
+			t.Fatal(vectorOf(v).Big(), v.Signed(), vectorOf(tc.want).Big())
+		}
+	}
+}
+
+func TestSignedProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	ops := []Op{OpAdd, OpSub, OpMul, OpDiv, OpMod, OpLt, OpLe, OpGt, OpGe, OpEq, OpNe}
+	for _, op := range ops {
+		for _, wa := range testWidths {
+			for _, wb := range testWidths {
+				a := VectorFromBig(wa, randBig(r, wa)).WithSign(true)
+				b := VectorFromBig(wb, randBig(r, wb)).WithSign(true)
+				if (op == OpDiv || op == OpMod) && !b.True() {
+					continue
+				}
+				w := op.Width(wa, wb)
+				x, y := a.Big(), b.Big()
+				var want *big.Int
+				switch op {
+				case OpLt, OpLe, OpGt, OpGe, OpEq, OpNe:
+					want = bigBool(compare(op, x.Cmp(y)))
+				case OpDiv:
+					want = new(big.Int).Quo(x, y)
+				case OpMod:
+					want = new(big.Int).Rem(x, y)
+				default:
+					want = binaryRefs[op](x, y, w)
+				}
+				got := (&BinaryExpr{a, b, op}).Eval()
+				if !Eq(got, VectorFromBig(w, want)) {
+					t.Fatal(op, wa, wb, x, y, vectorOf(got).Big(), want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package expr
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Env resolves the signal identifiers found by Parse.
+type Env interface {
+	Lookup(name string) (Expr, bool)
+}
+
+// MapEnv is an Env backed by a map.
+type MapEnv map[string]Expr
+
+func (env MapEnv) Lookup(name string) (Expr, bool) {
+	e, ok := env[name]
+	return e, ok
+}
+
+// ParseError reports a syntax error at a column of the parsed string,
+// starting at 1.
+type ParseError struct {
+	Col int
+	Msg string
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("col %d: %s", err.Col, err.Msg)
+}
+
+// Parse builds the expression described by x. Identifiers are resolved
+// through env.
+//
+// Operators may be written in Go or in Verilog syntax, e.g. both a &^ b and
+// a & ~b, and they bind as in Verilog. Since unary ^ is a reduction in
+// Verilog but a complement in Go, it is read as a reduction; write ~ for
+// the complement. Besides the operators, Parse accepts the ternary
+// operator, $signed and $unsigned, 8'(a) size casts, a[i] and a[h:l]
+// selects with constant bounds, {a, b} concatenations, {n{a}} replications
+// and the literals true, false, Go integers (42, 0xff, 0b101, 0o17) and
+// Verilog numbers (8'hff, 4'b10xz, 'd3, 8'sd5). Unsized decimals are 32-bit
+// signed numbers and unsized based numbers are 32-bit unsigned, as in
+// Verilog.
+func Parse(x string, env Env) (Expr, error) {
+	p := &parser{src: x, env: env}
+	p.next()
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf(p.tok.col, "unexpected %q", p.tok.text)
+	}
+	return e, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	col  int
+}
+
+// operators sorted so that the longest match comes first
+var operatorTokens = []string{
+	"<<<", ">>>", "===", "!==",
+	"&&", "||", "==", "!=", "<=", ">=", "<<", ">>", "&^", "~&", "~|", "~^", "^~",
+	"!", "~", "&", "|", "^", "+", "-", "*", "/", "%", "<", ">",
+	"?", ":", "(", ")", "[", "]", "{", "}", ",",
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+	env Env
+}
+
+func (p *parser) errorf(col int, format string, args ...interface{}) error {
+	return &ParseError{col, fmt.Sprintf(format, args...)}
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// next scans the following token into p.tok.
+func (p *parser) next() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\n\r", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	start := p.pos
+	p.tok = token{tokEOF, "", start + 1}
+	if p.pos >= len(p.src) {
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case isLetter(c):
+		for p.pos < len(p.src) && (isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind = tokIdent
+	case isDigit(c) || c == '\'':
+		p.scanNumber()
+		p.tok.kind = tokNumber
+	default:
+		for _, op := range operatorTokens {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok.kind = tokOp
+				break
+			}
+		}
+		if p.tok.kind != tokOp {
+			p.pos++ // report the invalid character as a token
+			p.tok.kind = tokOp
+		}
+	}
+	p.tok.text = p.src[start:p.pos]
+}
+
+func (p *parser) scanNumber() {
+	alnum := func() {
+		for p.pos < len(p.src) && (isLetter(p.src[p.pos]) || isDigit(p.src[p.pos]) || p.src[p.pos] == '?') {
+			p.pos++
+		}
+	}
+	alnum()
+	if p.pos < len(p.src) && p.src[p.pos] == '\'' {
+		p.pos++
+		alnum()
+	}
+}
+
+func (p *parser) expectOp(op string) error {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		if p.tok.kind == tokEOF {
+			return p.errorf(p.tok.col, "expected %q, found end of expression", op)
+		}
+		return p.errorf(p.tok.col, "expected %q, found %q", op, p.tok.text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+// binary operators from the lowest to the highest precedence
+var binaryLevels = []map[string]Op{
+	{"||": OpOr},
+	{"&&": OpAnd},
+	{"|": OpBitOr},
+	{"^": OpBitXor, "~^": OpBitXnor, "^~": OpBitXnor},
+	{"&": OpBitAnd},
+	{"==": OpEq, "!=": OpNe},
+	{"<": OpLt, "<=": OpLe, ">": OpGt, ">=": OpGe},
+	{"<<": OpShl, ">>": OpShr, "<<<": OpAShl, ">>>": OpAShr},
+	{"+": OpAdd, "-": OpSub},
+	{"*": OpMul, "/": OpDiv, "%": OpMod},
+}
+
+var unaryOps = map[string]Op{
+	"!":  OpNot,
+	"~":  OpBitNot,
+	"-":  OpNeg,
+	"&":  OpRedAnd,
+	"|":  OpRedOr,
+	"^":  OpRedXor,
+	"~&": OpRedNand,
+	"~|": OpRedNor,
+	"~^": OpRedXnor,
+	"^~": OpRedXnor,
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil || !p.isOp("?") {
+		return cond, err
+	}
+	p.next()
+	ifExpr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	elseExpr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &IfExpr{cond, ifExpr, elseExpr}, nil
+}
+
+func (p *parser) parseBinary(level int) (Expr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp {
+		if p.tok.text == "===" || p.tok.text == "!==" {
+			return nil, p.errorf(p.tok.col, "unsupported operator %q", p.tok.text)
+		}
+		op, ok := binaryLevels[level][p.tok.text]
+		andNot := level == 4 && p.tok.text == "&^"
+		if !ok && !andNot {
+			break
+		}
+		p.next()
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		if andNot {
+			x = BitAnd(x, BitNot(y))
+		} else {
+			x = &BinaryExpr{x, y, op}
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.isOp("+") {
+		p.next()
+		return p.parseUnary()
+	}
+	if p.tok.kind == tokOp {
+		if op, ok := unaryOps[p.tok.text]; ok {
+			p.next()
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &UnaryExpr{x, op}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (Expr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("[") {
+		p.next()
+		col := p.tok.col
+		index, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(":") {
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			x = Index(x, index)
+			continue
+		}
+		high, err := p.constant(index, col)
+		if err != nil {
+			return nil, err
+		}
+		p.next()
+		col = p.tok.col
+		lowExpr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		low, err := p.constant(lowExpr, col)
+		if err != nil {
+			return nil, err
+		}
+		if high < low {
+			return nil, p.errorf(col, "slice [%d:%d] is reversed", high, low)
+		}
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		x = Slice(x, high, low)
+	}
+	return x, nil
+}
+
+// constant evaluates e, which must not depend on any signal.
+func (p *parser) constant(e Expr, col int) (uint, error) {
+	v, ok := Simplify(e).(Value)
+	if !ok || unknown(v) {
+		return 0, p.errorf(col, "expected a constant")
+	}
+	if v.Signed() && vectorOf(v).Big().Sign() < 0 {
+		return 0, p.errorf(col, "expected a non negative constant")
+	}
+	return shiftAmount(vectorOf(v)), nil
+}
+
+// maxWidth is the widest size cast or literal which Parse accepts.
+const maxWidth = 1 << 16
+
+// parseSize parses the size cast width'(x) after its width.
+func (p *parser) parseSize(width string, col int) (Expr, error) {
+	n, err := strconv.ParseUint(width, 10, 32)
+	if err != nil || n == 0 || n > maxWidth {
+		return nil, p.errorf(col, "invalid size %q", width)
+	}
+	p.next()
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return &SizeExpr{x, uint(n)}, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokEOF:
+		return nil, p.errorf(tok.col, "unexpected end of expression")
+	case tokNumber:
+		p.next()
+		if w := strings.TrimSuffix(tok.text, "'"); w != tok.text && p.tok.text == "(" {
+			return p.parseSize(w, tok.col)
+		}
+		v, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, p.errorf(tok.col, "%v", err)
+		}
+		return v, nil
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return T, nil
+		case "false":
+			return F, nil
+		case "$signed", "$unsigned":
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return &CastExpr{x, tok.text == "$signed"}, nil
+		}
+		if p.env != nil {
+			if e, ok := p.env.Lookup(tok.text); ok {
+				return e, nil
+			}
+		}
+		return nil, p.errorf(tok.col, "undefined identifier %q", tok.text)
+	}
+
+	switch tok.text {
+	case "(":
+		p.next()
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case "{":
+		return p.parseConcat()
+	}
+	return nil, p.errorf(tok.col, "unexpected %q", tok.text)
+}
+
+func (p *parser) parseConcat() (Expr, error) {
+	p.next() // {
+	col := p.tok.col
+	first, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isOp("{") {
+		// replication
+		n, err := p.constant(first, col)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, p.errorf(col, "replication count must be positive")
+		}
+		x, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp("}"); err != nil {
+			return nil, err
+		}
+		if ce, ok := x.(*ConcatExpr); ok && len(ce.Exprs) == 1 {
+			x = ce.Exprs[0]
+		}
+		return Replicate(n, x), nil
+	}
+
+	exprs := []Expr{first}
+	for p.isOp(",") {
+		p.next()
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, x)
+	}
+	if err := p.expectOp("}"); err != nil {
+		return nil, err
+	}
+	return Concat(exprs...), nil
+}
+
+// parseNumber reads a Go or a Verilog integer literal.
+func parseNumber(lit string) (Value, error) {
+	lit = strings.Replace(lit, "_", "", -1)
+	if i := strings.IndexByte(lit, '\''); i >= 0 {
+		return parseVerilogNumber(lit[:i], lit[i+1:])
+	}
+
+	base, digits := 10, lit
+	if len(lit) > 1 && lit[0] == '0' {
+		switch lit[1] {
+		case 'x', 'X':
+			base, digits = 16, lit[2:]
+		case 'b', 'B':
+			base, digits = 2, lit[2:]
+		case 'o', 'O':
+			base, digits = 8, lit[2:]
+		default:
+			base, digits = 8, lit[1:]
+		}
+	}
+	x, ok := new(big.Int).SetString(digits, base)
+	if !ok || digits == "" {
+		return nil, fmt.Errorf("invalid number %q", lit)
+	}
+	width := uint(x.BitLen())
+	if base == 10 {
+		width++ // room for the sign bit
+	}
+	if width < 32 {
+		width = 32
+	}
+	return VectorFromBig(width, x).WithSign(base == 10), nil
+}
+
+var digitBits = map[byte]uint{'b': 1, 'o': 3, 'h': 4}
+
+func parseVerilogNumber(size, spec string) (Value, error) {
+	lit := size + "'" + spec
+	width := uint(32)
+	if size != "" {
+		n, ok := new(big.Int).SetString(size, 10)
+		if !ok || n.Sign() == 0 || !n.IsUint64() || n.Uint64() > maxWidth {
+			return nil, fmt.Errorf("invalid size in %q", lit)
+		}
+		width = uint(n.Uint64())
+	}
+
+	signed := false
+	if spec != "" && (spec[0] == 's' || spec[0] == 'S') {
+		signed = true
+		spec = spec[1:]
+	}
+	if len(spec) < 2 {
+		return nil, fmt.Errorf("invalid number %q", lit)
+	}
+	base, digits := spec[0]|0x20, strings.ToLower(spec[1:])
+
+	if base == 'd' {
+		if digits == "x" || digits == "z" || digits == "?" {
+			return extendLogic(NewLogicVector(digitLogic(digits[0])), width).WithSign(signed), nil
+		}
+		x, ok := new(big.Int).SetString(digits, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q", lit)
+		}
+		return VectorFromBig(width, x).WithSign(signed), nil
+	}
+
+	bits, ok := digitBits[base]
+	if !ok {
+		return nil, fmt.Errorf("invalid base in %q", lit)
+	}
+	lv := newLogicVector(uint(len(digits)) * bits)
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		for b := uint(0); b < bits; b++ {
+			l := digitLogic(d)
+			if l == L1 || l == L0 {
+				n := uint64(1) << bits // invalid unless it is a digit
+				switch {
+				case isDigit(d):
+					n = uint64(d - '0')
+				case 'a' <= d && d <= 'f':
+					n = uint64(d-'a') + 10
+				}
+				if n >= 1<<bits {
+					return nil, fmt.Errorf("invalid digit %q in %q", d, lit)
+				}
+				l = L0
+				if n>>b&1 == 1 {
+					l = L1
+				}
+			}
+			lv.SetBit(uint(i)*bits+b, l)
+		}
+	}
+	lv = extendLogic(lv, width)
+	if lv.Known() {
+		return lv.vector().WithSign(signed), nil
+	}
+	return lv.WithSign(signed), nil
+}
+
+func digitLogic(d byte) Logic {
+	switch d {
+	case 'x':
+		return LX
+	case 'z', '?':
+		return LZ
+	}
+	return L1 // any other digit is known
+}
+
+// extendLogic fits lv to width bits. A leading X or Z is replicated.
+func extendLogic(lv *LogicVector, width uint) *LogicVector {
+	r := lv.Slice(width-1, 0)
+	if lv.width == 0 {
+		return r
+	}
+	fill := lv.Bit(lv.width - 1)
+	if fill == L1 {
+		fill = L0
+	}
+	for i := lv.width; i < width; i++ {
+		r.SetBit(i, fill)
+	}
+	return r
+}
@@ -0,0 +1,159 @@
+package expr
+
+import "testing"
+
+func TestParseNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		x    string
+		want Value
+	}{
+		{"42", NewSigned(32, 42)},
+		{"0x_ff", NewVector(32, 0xff)},
+		{"0b101", NewVector(32, 5)},
+		{"0o17", NewVector(32, 15)},
+		{"017", NewVector(32, 15)},
+		{"8'hFF", NewVector(8, 0xff)},
+		{"8'hF_F", NewVector(8, 0xff)},
+		{"'d3", NewVector(32, 3)},
+		{"2'b01", NewVector(2, 1)},
+		{"8'sd5", NewSigned(8, 5)},
+		{"4'sb1111", NewSigned(4, -1)},
+		{"12'o7", NewVector(12, 7)},
+		{"4'b10xz", NewLogicVector(LZ, LX, L0, L1)},
+		{"4'bx", Unknown(4)},
+		{"4'bz1", NewLogicVector(L1, LZ, LZ, LZ)},
+		{"4'h?", HighZ(4)},
+		{"3'dx", Unknown(3)},
+		{"4'hff", NewVector(4, 0xf)},
+		{"true", T},
+		{"false", F},
+		{"0x1_0000_0000_0000_0000", VectorFromBig(65, bigOne(64))},
+	} {
+		e, err := Parse(tc.x, nil)
+		if err != nil {
+			t.Fatal(tc.x, err)
+		}
+		v := e.Eval()
+		if !Eq(v, tc.want) || v.Width() != tc.want.Width() || v.Signed() != tc.want.Signed() {
+			t.Fatal(tc.x, v, tc.want)
+		}
+	}
+}
+
+func TestParseOperators(t *testing.T) {
+	env := MapEnv{
+		"a":   NewVar("a", NewVector(8, 0xa5)),
+		"b":   T,
+		"c":   NewVector(8, 3),
+		"sel": F,
+		"s":   NewVar("s", NewSigned(8, -16)),
+	}
+	for _, tc := range []struct {
+		x    string
+		want Value
+	}{
+		{"{a[7:4], b, 2'b01}", NewVector(7, 0x55)},
+		{"{2{c[1:0]}}", NewVector(4, 0xf)},
+		{"{2{a[0], sel}}", NewVector(4, 0xa)},
+		{"a[2]", T},
+		{"a[c-2]", F},
+		{"sel ? a : c", NewVector(8, 3)},
+		{"!sel ? a : c", NewVector(8, 0xa5)},
+		{"b ? sel ? 1 : 2 : 3", NewSigned(32, 2)},
+		{"sel && b || !sel && c == 3", T},
+		{"Sel && B", nil},
+		{"a &^ c", NewVector(8, 0xa4)},
+		{"a & ~c", NewVector(8, 0xa4)},
+		{"a ^ c", NewVector(8, 0xa6)},
+		{"a ~^ c", NewVector(8, 0x59)},
+		{"a ^~ c", NewVector(8, 0x59)},
+		{"^a", F},
+		{"~^a", T},
+		{"&a", F},
+		{"~&a", T},
+		{"|a", T},
+		{"~|a", F},
+		{"a | c & 8'h0f", NewVector(8, 0xa7)},
+		{"a + c * 2", NewSigned(32, 0xab)},
+		{"(a + c) * 8'd2", NewVector(8, 0x50)},
+		{"-c", NewVector(8, 0xfd)},
+		{"+c", NewVector(8, 3)},
+		{"c << 2 + 1", NewVector(8, 24)},
+		{"s >>> 2", NewSigned(8, -4)},
+		{"s >> 2", NewSigned(8, 0x3c)},
+		{"$unsigned(s) >>> 2", NewVector(8, 0x3c)},
+		{"$signed(a) < 0", T},
+		{"4'(a)", NewVector(4, 5)},
+		{"16'(s)", NewSigned(16, -16)},
+		{"4'(a + c)", NewVector(4, 8)},
+		{"a < 0", F},
+		{"c <= 3 && c >= 3 && c != 4 && c > 2", T},
+		{"a / c % 8'd7", NewVector(8, 6)},
+		{"1 <<< 3", NewSigned(32, 8)},
+		{"a[7:0] == a", T},
+	} {
+		e, err := Parse(tc.x, env)
+		if tc.want == nil {
+			if err == nil {
+				t.Fatal(tc.x)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(tc.x, err)
+		}
+		v := e.Eval()
+		if !Eq(v, tc.want) || v.Width() != tc.want.Width() {
+			t.Fatal(tc.x, vectorOf(v).Big(), v.Width(), vectorOf(tc.want).Big())
+		}
+	}
+
+	// signals are not copied
+	e, _ := Parse("a + 1", env)
+	if e.(*BinaryExpr).Expr1 != env["a"] {
+		t.Fatal(e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	env := MapEnv{"a": NewVar("a", NewVector(8, 1))}
+	for _, tc := range []struct {
+		x   string
+		col int
+	}{
+		{"", 1},
+		{"a +", 4},
+		{"a + b", 5},
+		{"(a", 3},
+		{"a)", 2},
+		{"a ? a", 6},
+		{"a[a:0]", 3},
+		{"a[0:3]", 5},
+		{"a[3:0", 6},
+		{"{a, a", 6},
+		{"0'(a)", 1},
+		{"4'(a", 5},
+		{"{a{a}}", 2},
+		{"{0{a}}", 2},
+		{"8'hfg", 1},
+		{"2'b12", 1},
+		{"8'q1", 1},
+		{"0'b1", 1},
+		{"a + 4294967296'h0", 5},
+		{"a + 65537'(a)", 5},
+		{"09", 1},
+		{"a === a", 3},
+		{"a # a", 3},
+		{"a a", 3},
+		{"$signed a", 9},
+	} {
+		_, err := Parse(tc.x, env)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatal(tc.x, err)
+		}
+		if perr.Col != tc.col {
+			t.Fatal(tc.x, perr)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package expr
+
+import "sort"
+
+// Partial evaluates e as far as its inputs are known: the Vars which are
+// not free are replaced by their current values and the result is
+// simplified. It returns a Value if e does not depend on any free Var, and
+// otherwise the residual expression over the free Vars, which evaluates as
+// e for any value of them. Vars with no value are kept as they are. The
+// original tree is left untouched.
+func Partial(e Expr) Expr {
+	return Simplify(Rewrite(e, func(x Expr) (Expr, bool) {
+		if v, ok := x.(*Var); ok && !v.Free && v.Value != nil {
+			return v.Value, true
+		}
+		return x, false
+	}))
+}
+
+// Cone returns the free Vars in the cone of influence of e, the ones
+// whose values may change the value of e once all other Vars are fixed,
+// sorted by name. Values with X or Z bits are not considered, so that
+// neither a & 0 nor a * 0 depend on a. Each Var read by the residual of e
+// is decided by Equivalent, which makes Cone as costly; when the residual
+// has errors, as reported by Check, all of them are returned.
+func Cone(e Expr) []*Var {
+	r := Partial(e)
+	var cone []*Var
+	Walk(r, func(x Expr, _ []Expr) error {
+		if v, ok := x.(*Var); ok && v.Free {
+			cone = append(cone, v)
+		}
+		return nil
+	})
+	if _, diags := Check(r); len(Errors(diags)) == 0 {
+		influent := cone[:0]
+		for _, v := range cone {
+			if !independent(r, v) {
+				influent = append(influent, v)
+			}
+		}
+		cone = influent
+	}
+	sort.Slice(cone, func(i, j int) bool { return cone[i].Name < cone[j].Name })
+	return cone
+}
+
+// independent reports whether e has the same value for any two values of
+// v, by comparing it with a copy reading another Var instead.
+func independent(e Expr, v *Var) bool {
+	other := &Var{Name: v.Name + "'", Value: v.Value, Free: true}
+	equivalent, _ := Equivalent(e, Rewrite(e, func(x Expr) (Expr, bool) {
+		if y, ok := x.(*Var); ok && y.Name == v.Name {
+			return other, true
+		}
+		return x, false
+	}))
+	return equivalent
+}
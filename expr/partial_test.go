@@ -0,0 +1,95 @@
+package expr
+
+import "testing"
+
+func TestPartial(t *testing.T) {
+	mode := NewVar("mode", F)
+	gain := NewVar("gain", NewVector(8, 2))
+	x := Free("x", Type{8, false})
+	y := Free("y", Type{8, false})
+	// mode ? x * gain : y + gain
+	e := &IfExpr{mode, Mul(x, gain), Add(y, gain)}
+
+	r := Partial(e)
+	be, ok := r.(*BinaryExpr)
+	if !ok || be.Op != OpAdd || be.Expr1 != y {
+		t.Fatal(Format(r))
+	}
+	if v, ok := be.Expr2.(Value); !ok || v.Uint() != 2 {
+		t.Fatal(Format(r))
+	}
+	if cone := Cone(e); len(cone) != 1 || cone[0] != y {
+		t.Fatal(cone)
+	}
+
+	mode.Value = T
+	r = Partial(e)
+	if s := Format(r); s != "x * 8'd2" {
+		t.Fatal(s)
+	}
+
+	// the residual evaluates as the original expression
+	for _, v := range []uint64{0, 1, 7, 255} {
+		x.Value = NewVector(8, v)
+		y.Value = NewVector(8, v+1)
+		if ev, rv := e.Eval(), r.Eval(); !Eq(ev, rv) {
+			t.Fatal(v, ev, rv)
+		}
+	}
+
+	// with no free vars the result is a constant
+	x.Free, y.Free = false, false
+	if v, ok := Partial(e).(Value); !ok || v.Uint() != 255*2&0xff {
+		t.Fatal(Format(Partial(e)))
+	}
+	if cone := Cone(e); len(cone) != 0 {
+		t.Fatal(cone)
+	}
+}
+
+func TestCone(t *testing.T) {
+	en := NewVar("en", Unknown(1))
+	a := Free("a", Type{4, false})
+	b := Free("b", Type{4, false})
+	c := Free("c", Type{1, false})
+	e := Concat(&IfExpr{en, a, b}, And(c, F), Index(a, c))
+
+	names := func() []string {
+		var names []string
+		for _, v := range Cone(e) {
+			names = append(names, v.Name)
+		}
+		return names
+	}
+	// an unknown enable selects either input
+	if got := names(); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatal(got)
+	}
+	en.Value = T
+	if got := names(); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatal(got)
+	}
+
+	// vars which cannot change the value are left out
+	zero := NewVector(4, 0)
+	for _, tc := range []struct {
+		e    Expr
+		cone int
+	}{
+		{BitAnd(a, zero), 0},
+		{Mul(a, zero), 0},
+		{BitXor(b, b), 0},
+		{Shl(a, NewVector(3, 4)), 0},
+		{Slice(Concat(a, b), 3, 0), 1}, // b
+		{BitOr(a, zero), 1},
+	} {
+		if cone := Cone(tc.e); len(cone) != tc.cone {
+			t.Fatal(Format(tc.e), cone)
+		}
+	}
+
+	// free vars evaluate to X
+	if v := e.Eval(); LogicOf(v).Known() {
+		t.Fatal(v)
+	}
+}
@@ -0,0 +1,126 @@
+package expr
+
+// Children returns the operands of e. Leaves have none.
+func Children(e Expr) []Expr {
+	switch e := e.(type) {
+	case *UnaryExpr:
+		return []Expr{e.Expr}
+	case *BinaryExpr:
+		return []Expr{e.Expr1, e.Expr2}
+	case *IfExpr:
+		return []Expr{e.Cond, e.If, e.Else}
+	case *SliceExpr:
+		return []Expr{e.Expr}
+	case *IndexExpr:
+		return []Expr{e.Expr, e.Index}
+	case *ConcatExpr:
+		return e.Exprs
+	case *ReplicateExpr:
+		return []Expr{e.Expr}
+	case *CastExpr:
+		return []Expr{e.Expr}
+	case *SizeExpr:
+		return []Expr{e.Expr}
+	}
+	return nil
+}
+
+// WithChildren returns a copy of e with its operands replaced by children,
+// which must match the ones returned by Children. If no operand changes, e
+// itself is returned.
+func WithChildren(e Expr, children []Expr) Expr {
+	old := Children(e)
+	if len(old) != len(children) {
+		panic("wrong number of children")
+	}
+	same := true
+	for i := range old {
+		same = same && old[i] == children[i]
+	}
+	if same {
+		return e
+	}
+
+	switch e := e.(type) {
+	case *UnaryExpr:
+		return &UnaryExpr{children[0], e.Op}
+	case *BinaryExpr:
+		return &BinaryExpr{children[0], children[1], e.Op}
+	case *IfExpr:
+		return &IfExpr{children[0], children[1], children[2]}
+	case *SliceExpr:
+		return &SliceExpr{children[0], e.High, e.Low}
+	case *IndexExpr:
+		return &IndexExpr{children[0], children[1]}
+	case *ConcatExpr:
+		return &ConcatExpr{append([]Expr(nil), children...)}
+	case *ReplicateExpr:
+		return &ReplicateExpr{children[0], e.Count}
+	case *CastExpr:
+		return &CastExpr{children[0], e.Signed}
+	case *SizeExpr:
+		return &SizeExpr{children[0], e.Width}
+	}
+	panic("unreachable")
+}
+
+// RewriteFunc returns the expression that replaces e and whether e was
+// replaced at all.
+type RewriteFunc func(e Expr) (Expr, bool)
+
+// Rewrite calls f for each node of the tree, parents before their children,
+// and returns the rewritten tree. A replaced node is not descended into.
+// Nodes whose operands change are copied, so root is left untouched, and
+// shared nodes are rewritten only once. A node that is reached again through
+// a cycle is kept as is.
+func Rewrite(root Expr, f RewriteFunc) Expr {
+	r := &rewriter{f, false, make(map[Expr]Expr)}
+	return r.rewrite(root)
+}
+
+// RewritePost is like Rewrite, but it calls f on each node after its
+// children were rewritten, so f sees the node with its new operands.
+func RewritePost(root Expr, f RewriteFunc) Expr {
+	r := &rewriter{f, true, make(map[Expr]Expr)}
+	return r.rewrite(root)
+}
+
+type rewriter struct {
+	f    RewriteFunc
+	post bool
+	done map[Expr]Expr
+}
+
+func (r *rewriter) rewrite(e Expr) Expr {
+	if e == nil {
+		return nil
+	}
+	if n, ok := r.done[e]; ok {
+		return n
+	}
+	r.done[e] = e
+
+	if !r.post {
+		if n, ok := r.f(e); ok {
+			r.done[e] = n
+			return n
+		}
+	}
+
+	n := e
+	if children := Children(e); len(children) > 0 {
+		rewritten := make([]Expr, len(children))
+		for i, c := range children {
+			rewritten[i] = r.rewrite(c)
+		}
+		n = WithChildren(e, rewritten)
+	}
+
+	if r.post {
+		if m, ok := r.f(n); ok {
+			n = m
+		}
+	}
+	r.done[e] = n
+	return n
+}
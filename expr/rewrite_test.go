@@ -0,0 +1,143 @@
+package expr
+
+import "testing"
+
+func TestRewrite(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	b := NewVar("b", NewVector(8, 2))
+	sum := Add(a, b)
+	e := Concat(sum, Slice(sum, 3, 0))
+
+	// bind a to a constant
+	var visited []Expr
+	r := Rewrite(e, func(e Expr) (Expr, bool) {
+		visited = append(visited, e)
+		if e == a {
+			return NewVector(8, 5), true
+		}
+		return e, false
+	})
+	if len(visited) != 5 || visited[0] != e {
+		t.Fatal(visited)
+	}
+	if v := r.Eval(); v.Uint() != 0x77 {
+		t.Fatal(v)
+	}
+	ce := r.(*ConcatExpr)
+	if ce.Exprs[0] != ce.Exprs[1].(*SliceExpr).Expr {
+		t.Fatal("shared node rewritten twice")
+	}
+
+	// the original tree is kept
+	if e.Eval().Uint() != 0x33 || sum.Expr1 != a {
+		t.Fatal(e.Eval())
+	}
+
+	// nothing to rewrite
+	if r := Rewrite(e, func(e Expr) (Expr, bool) { return e, false }); r != e {
+		t.Fatal(r)
+	}
+}
+
+func TestRewriteReplaced(t *testing.T) {
+	a := NewVar("a", T)
+	e := Not(Not(a))
+
+	// replacements are not descended into
+	calls := 0
+	r := Rewrite(e, func(e Expr) (Expr, bool) {
+		calls++
+		if ue, ok := e.(*UnaryExpr); ok {
+			return Not(ue), true
+		}
+		return e, false
+	})
+	if calls != 1 || r.(*UnaryExpr).Expr != e {
+		t.Fatal(calls, r)
+	}
+}
+
+func TestRewritePost(t *testing.T) {
+	a := NewVar("a", NewVector(8, 7))
+	b := NewVar("b", NewVector(8, 2))
+
+	// lower a - b into a + -b
+	var visited []Expr
+	r := RewritePost(Mul(Sub(a, b), Sub(a, b)), func(e Expr) (Expr, bool) {
+		visited = append(visited, e)
+		if be, ok := e.(*BinaryExpr); ok && be.Op == OpSub {
+			return Add(be.Expr1, Neg(be.Expr2)), true
+		}
+		return e, false
+	})
+	if len(visited) != 5 {
+		t.Fatal(len(visited))
+	}
+	if _, ok := visited[len(visited)-1].(*BinaryExpr); !ok {
+		t.Fatal(visited)
+	}
+	mul := r.(*BinaryExpr)
+	if mul.Expr1.(*BinaryExpr).Op != OpAdd || mul.Expr2.(*BinaryExpr).Op != OpAdd {
+		t.Fatal(mul)
+	}
+	if v := r.Eval(); v.Uint() != 25 {
+		t.Fatal(v)
+	}
+}
+
+func TestRewriteCycle(t *testing.T) {
+	a := NewVar("a", T)
+	or := Or(And(a, a), nil)
+	or.Expr2 = or
+
+	count := 0
+	r := RewritePost(or, func(e Expr) (Expr, bool) {
+		count++
+		if e == a {
+			return NewVar("b", F), true
+		}
+		return e, false
+	})
+	if count != 3 {
+		t.Fatal(count)
+	}
+	ro := r.(*BinaryExpr)
+	if ro == or || ro.Expr2 != or || ro.Expr1.(*BinaryExpr).Expr1.(*Var).Name != "b" {
+		t.Fatal(ro)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	b := NewVar("b", NewVector(8, 2))
+	for _, e := range []Expr{
+		Not(a), Add(a, b), &IfExpr{a, a, b}, Slice(a, 3, 1), Index(a, b),
+		Concat(a, b, a), Replicate(2, a), AsSigned(a),
+	} {
+		children := Children(e)
+		if WithChildren(e, children) != e {
+			t.Fatal(e)
+		}
+		swapped := make([]Expr, len(children))
+		for i, c := range children {
+			if c == a {
+				swapped[i] = b
+			} else {
+				swapped[i] = a
+			}
+		}
+		n := WithChildren(e, swapped)
+		if n == e {
+			t.Fatal(e)
+		}
+		for i, c := range Children(n) {
+			if c != swapped[i] {
+				t.Fatal(e, i)
+			}
+		}
+	}
+
+	if Children(a) != nil || Children(T) != nil {
+		t.Fatal()
+	}
+}
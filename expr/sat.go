@@ -0,0 +1,360 @@
+package expr
+
+// lit is a literal of the solver: the variable lit/2, negated if lit is
+// odd.
+type lit int32
+
+func (l lit) not() lit {
+	return l ^ 1
+}
+
+func (l lit) v() int {
+	return int(l >> 1)
+}
+
+// solver decides the satisfiability of a formula in conjunctive normal
+// form with conflict driven clause learning: unit propagation over two
+// watched literals, first UIP learning, activity based decisions and
+// restarts.
+type solver struct {
+	clauses  [][]lit
+	watches  [][]int // clauses watching each literal, by index
+	assigns  []int8  // 1, -1 or 0 if unassigned
+	levels   []int
+	reasons  []int // clause which implied each variable, or -1
+	trail    []lit
+	trailLim []int // start of each decision level in trail
+	qhead    int
+	activity []float64
+	inc      float64
+	heap     varHeap
+	seen     []bool
+	unsat    bool
+}
+
+func newSolver() *solver {
+	s := &solver{inc: 1}
+	s.heap.s = s
+	return s
+}
+
+func (s *solver) newVar() lit {
+	v := len(s.assigns)
+	s.watches = append(s.watches, nil, nil)
+	s.assigns = append(s.assigns, 0)
+	s.levels = append(s.levels, 0)
+	s.reasons = append(s.reasons, -1)
+	s.activity = append(s.activity, 0)
+	s.seen = append(s.seen, false)
+	s.heap.index = append(s.heap.index, -1)
+	s.heap.push(v)
+	return lit(2 * v)
+}
+
+// value returns 1, -1 or 0 if l is true, false or unassigned.
+func (s *solver) value(l lit) int8 {
+	a := s.assigns[l.v()]
+	if l&1 != 0 {
+		return -a
+	}
+	return a
+}
+
+func (s *solver) level() int {
+	return len(s.trailLim)
+}
+
+// addClause adds the clause of lits. It must be called before solve, at
+// decision level 0.
+func (s *solver) addClause(lits ...lit) {
+	var c []lit
+	for _, l := range lits {
+		switch s.value(l) {
+		case 1:
+			return
+		case -1:
+			continue
+		}
+		dup := false
+		for _, x := range c {
+			if x == l {
+				dup = true
+			} else if x == l.not() {
+				return
+			}
+		}
+		if !dup {
+			c = append(c, l)
+		}
+	}
+	switch len(c) {
+	case 0:
+		s.unsat = true
+	case 1:
+		if !s.enqueue(c[0], -1) || s.propagate() >= 0 {
+			s.unsat = true
+		}
+	default:
+		s.attach(c)
+	}
+}
+
+func (s *solver) attach(c []lit) int {
+	i := len(s.clauses)
+	s.clauses = append(s.clauses, c)
+	s.watches[c[0].not()] = append(s.watches[c[0].not()], i)
+	s.watches[c[1].not()] = append(s.watches[c[1].not()], i)
+	return i
+}
+
+func (s *solver) enqueue(l lit, reason int) bool {
+	switch s.value(l) {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	v := l.v()
+	s.assigns[v] = 1
+	if l&1 != 0 {
+		s.assigns[v] = -1
+	}
+	s.levels[v] = s.level()
+	s.reasons[v] = reason
+	s.trail = append(s.trail, l)
+	return true
+}
+
+// propagate assigns the literals implied by the trail and returns the
+// index of a conflicting clause, or -1.
+func (s *solver) propagate() int {
+	for s.qhead < len(s.trail) {
+		p := s.trail[s.qhead] // p is true, so clauses watching ¬p may be unit
+		s.qhead++
+		ws := s.watches[p]
+		j := 0
+		for i := 0; i < len(ws); i++ {
+			ci := ws[i]
+			c := s.clauses[ci]
+			if c[0] == p.not() {
+				c[0], c[1] = c[1], c[0]
+			}
+			if s.value(c[0]) == 1 {
+				ws[j] = ci
+				j++
+				continue
+			}
+			moved := false
+			for k := 2; k < len(c); k++ {
+				if s.value(c[k]) != -1 {
+					c[1], c[k] = c[k], c[1]
+					s.watches[c[1].not()] = append(s.watches[c[1].not()], ci)
+					moved = true
+					break
+				}
+			}
+			if moved {
+				continue
+			}
+			ws[j] = ci
+			j++
+			if !s.enqueue(c[0], ci) {
+				j += copy(ws[j:], ws[i+1:])
+				s.watches[p] = ws[:j]
+				s.qhead = len(s.trail)
+				return ci
+			}
+		}
+		s.watches[p] = ws[:j]
+	}
+	return -1
+}
+
+// analyze returns the first UIP clause learned from the conflict and the
+// level to backtrack to.
+func (s *solver) analyze(conflict int) ([]lit, int) {
+	learnt := []lit{0} // the asserting literal goes first
+	pending := 0
+	var p lit = -1
+	i := len(s.trail) - 1
+	for {
+		for _, q := range s.clauses[conflict] {
+			if q == p {
+				continue
+			}
+			v := q.v()
+			if s.seen[v] || s.levels[v] == 0 {
+				continue
+			}
+			s.seen[v] = true
+			s.bump(v)
+			if s.levels[v] == s.level() {
+				pending++
+			} else {
+				learnt = append(learnt, q)
+			}
+		}
+		for !s.seen[s.trail[i].v()] {
+			i--
+		}
+		p = s.trail[i]
+		i--
+		s.seen[p.v()] = false
+		pending--
+		if pending == 0 {
+			break
+		}
+		conflict = s.reasons[p.v()]
+	}
+	learnt[0] = p.not()
+
+	back := 0
+	for k := 1; k < len(learnt); k++ {
+		s.seen[learnt[k].v()] = false
+		if l := s.levels[learnt[k].v()]; l > back {
+			back = l
+			learnt[1], learnt[k] = learnt[k], learnt[1]
+		}
+	}
+	s.inc *= 1 / 0.95
+	return learnt, back
+}
+
+func (s *solver) bump(v int) {
+	s.activity[v] += s.inc
+	if s.activity[v] > 1e100 {
+		for i := range s.activity {
+			s.activity[i] *= 1e-100
+		}
+		s.inc *= 1e-100
+	}
+	if s.heap.index[v] >= 0 {
+		s.heap.up(s.heap.index[v])
+	}
+}
+
+func (s *solver) backtrack(level int) {
+	if s.level() <= level {
+		return
+	}
+	for i := len(s.trail) - 1; i >= s.trailLim[level]; i-- {
+		v := s.trail[i].v()
+		s.assigns[v] = 0
+		s.reasons[v] = -1
+		if s.heap.index[v] < 0 {
+			s.heap.push(v)
+		}
+	}
+	s.trail = s.trail[:s.trailLim[level]]
+	s.trailLim = s.trailLim[:level]
+	s.qhead = len(s.trail)
+}
+
+// solve reports whether the clauses are satisfiable. If they are, the
+// assignment of every variable is kept for value.
+func (s *solver) solve() bool {
+	if s.unsat {
+		return false
+	}
+	limit := 100
+	conflicts := 0
+	for {
+		if c := s.propagate(); c >= 0 {
+			if s.level() == 0 {
+				s.unsat = true
+				return false
+			}
+			learnt, back := s.analyze(c)
+			s.backtrack(back)
+			if len(learnt) == 1 {
+				s.enqueue(learnt[0], -1)
+			} else {
+				s.enqueue(learnt[0], s.attach(learnt))
+			}
+			conflicts++
+			continue
+		}
+		if conflicts >= limit {
+			conflicts = 0
+			limit += limit / 2
+			s.backtrack(0)
+		}
+		v := -1
+		for s.heap.len() > 0 {
+			if x := s.heap.pop(); s.assigns[x] == 0 {
+				v = x
+				break
+			}
+		}
+		if v < 0 {
+			return true
+		}
+		s.trailLim = append(s.trailLim, len(s.trail))
+		s.enqueue(lit(2*v+1), -1) // try false first
+	}
+}
+
+// varHeap orders the variables by decreasing activity.
+type varHeap struct {
+	s     *solver
+	vars  []int
+	index []int // position of each variable in vars, or -1
+}
+
+func (h *varHeap) len() int {
+	return len(h.vars)
+}
+
+func (h *varHeap) less(i, j int) bool {
+	return h.s.activity[h.vars[i]] > h.s.activity[h.vars[j]]
+}
+
+func (h *varHeap) swap(i, j int) {
+	h.vars[i], h.vars[j] = h.vars[j], h.vars[i]
+	h.index[h.vars[i]] = i
+	h.index[h.vars[j]] = j
+}
+
+func (h *varHeap) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.less(i, parent) {
+			break
+		}
+		h.swap(i, parent)
+		i = parent
+	}
+}
+
+func (h *varHeap) down(i int) {
+	for {
+		child := 2*i + 1
+		if child >= len(h.vars) {
+			return
+		}
+		if child+1 < len(h.vars) && h.less(child+1, child) {
+			child++
+		}
+		if !h.less(child, i) {
+			return
+		}
+		h.swap(i, child)
+		i = child
+	}
+}
+
+func (h *varHeap) push(v int) {
+	h.index[v] = len(h.vars)
+	h.vars = append(h.vars, v)
+	h.up(len(h.vars) - 1)
+}
+
+func (h *varHeap) pop() int {
+	v := h.vars[0]
+	last := len(h.vars) - 1
+	h.swap(0, last)
+	h.vars = h.vars[:last]
+	h.index[v] = -1
+	h.down(0)
+	return v
+}
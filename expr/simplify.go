@@ -0,0 +1,218 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Simplify returns an expression equivalent to e with constant subtrees
+// folded, trivial identities applied and structurally equal subexpressions
+// shared. The original tree is left untouched.
+//
+// Values are taken as constants: signals that change between evaluations
+// must be represented by a Var or by another Expr which is not a Value.
+// The rewrites preserve the result for any 0, 1 or X input, but an input
+// with Z bits may produce Z where the original expression gives X.
+// Cycles are kept, pointing back to the original nodes.
+func Simplify(e Expr) Expr {
+	s := &simplifier{
+		done:   make(map[Expr]Expr),
+		shared: make(map[string]Expr),
+		types:  &checker{types: make(map[Expr]Type), visiting: make(map[Expr]bool)},
+	}
+	return s.simplify(e)
+}
+
+type simplifier struct {
+	done   map[Expr]Expr   // simplified form of each visited node
+	shared map[string]Expr // canonical node of each structure
+	types  *checker        // types of the simplified nodes
+}
+
+func (s *simplifier) simplify(e Expr) Expr {
+	if r, ok := s.done[e]; ok {
+		return r
+	}
+	s.done[e] = e // a cycle resolves to the original node
+	r := s.share(s.rewrite(e))
+	s.done[e] = r
+	s.done[r] = r
+	return r
+}
+
+func constant(e Expr) (Value, bool) {
+	v, ok := e.(Value)
+	return v, ok
+}
+
+// boolean reports whether e always evaluates to a single 0, 1 or X bit.
+func boolean(e Expr) bool {
+	switch e := e.(type) {
+	case *Bool:
+		return true
+	case *UnaryExpr:
+		return e.Op.Width(0, 0) == 1
+	case *BinaryExpr:
+		return e.Op.Width(0, 0) == 1
+	}
+	return false
+}
+
+// truthOf returns an expression with the truth value of e.
+func truthOf(e Expr) Expr {
+	if boolean(e) {
+		return e
+	}
+	return RedOr(e)
+}
+
+func (s *simplifier) rewrite(e Expr) Expr {
+	switch e := e.(type) {
+	case *UnaryExpr:
+		x := s.simplify(e.Expr)
+		if v, ok := constant(x); ok {
+			return evalUnary(e.Op, v)
+		}
+		if inner, ok := x.(*UnaryExpr); ok && inner.Op == e.Op {
+			switch {
+			case e.Op == OpBitNot, e.Op == OpNeg:
+				return inner.Expr
+			case e.Op == OpNot && boolean(inner.Expr):
+				return inner.Expr
+			}
+		}
+		return &UnaryExpr{x, e.Op}
+	case *BinaryExpr:
+		x, y := s.simplify(e.Expr1), s.simplify(e.Expr2)
+		vx, okx := constant(x)
+		vy, oky := constant(y)
+		if okx && oky {
+			return evalBinary(e.Op, vx, vy)
+		}
+		switch e.Op {
+		case OpAnd, OpOr:
+			if okx {
+				// logical operators are commutative
+				x, y, vy = y, x, vx
+				oky = true
+			}
+			if !oky {
+				break
+			}
+			switch l := truth(vy); {
+			case e.Op == OpAnd && l == L0:
+				return F
+			case e.Op == OpOr && l == L1:
+				return T
+			case l != LX:
+				return truthOf(x)
+			}
+		}
+		return &BinaryExpr{x, y, e.Op}
+	case *IfExpr:
+		cond := s.simplify(e.Cond)
+		ifExpr, elseExpr := s.simplify(e.If), s.simplify(e.Else)
+		if v, ok := constant(cond); ok {
+			// a branch keeps the value of the conditional only if it
+			// needs no extension
+			same := s.types.check(ifExpr) == s.types.check(elseExpr)
+			switch l := truth(v); {
+			case l == L1 && same:
+				return ifExpr
+			case l == L0 && same:
+				return elseExpr
+			}
+			r := &IfExpr{cond, ifExpr, elseExpr}
+			return s.fold(r, ifExpr, elseExpr)
+		}
+		if ifExpr == elseExpr {
+			return ifExpr
+		}
+		return &IfExpr{cond, ifExpr, elseExpr}
+	case *SliceExpr:
+		x := s.simplify(e.Expr)
+		if v, ok := constant(x); ok && e.High >= e.Low {
+			return sliceValue(v, e.High, e.Low)
+		}
+		return &SliceExpr{x, e.High, e.Low} // reversed ones are left to Check
+	case *IndexExpr:
+		r := &IndexExpr{s.simplify(e.Expr), s.simplify(e.Index)}
+		return s.fold(r, r.Expr, r.Index)
+	case *ConcatExpr:
+		r := &ConcatExpr{make([]Expr, len(e.Exprs))}
+		for i, x := range e.Exprs {
+			r.Exprs[i] = s.simplify(x)
+		}
+		return s.fold(r, r.Exprs...)
+	case *ReplicateExpr:
+		r := &ReplicateExpr{s.simplify(e.Expr), e.Count}
+		return s.fold(r, r.Expr)
+	case *CastExpr:
+		r := &CastExpr{s.simplify(e.Expr), e.Signed}
+		return s.fold(r, r.Expr)
+	case *SizeExpr:
+		r := &SizeExpr{s.simplify(e.Expr), e.Width}
+		return s.fold(r, r.Expr)
+	}
+	return e
+}
+
+// fold evaluates e if all of its operands are constants.
+func (s *simplifier) fold(e Expr, operands ...Expr) Expr {
+	for _, x := range operands {
+		if _, ok := constant(x); !ok {
+			return e
+		}
+	}
+	return e.Eval()
+}
+
+// share returns the first node seen with the same structure as e.
+func (s *simplifier) share(e Expr) Expr {
+	k, ok := key(e)
+	if !ok {
+		return e
+	}
+	if r, ok := s.shared[k]; ok {
+		return r
+	}
+	s.shared[k] = e
+	return e
+}
+
+// key describes the structure of e given that its children are already
+// shared. Leaves which are not values are only equal to themselves.
+func key(e Expr) (string, bool) {
+	switch e := e.(type) {
+	case *Bool:
+		return fmt.Sprintf("b%v", e.True()), true
+	case *Vector:
+		return fmt.Sprintf("v%v'%s", e.signed, LogicOf(e)), true
+	case *LogicVector:
+		return fmt.Sprintf("l%v'%s", e.signed, e), true
+	case *UnaryExpr:
+		return fmt.Sprintf("u%d(%p)", e.Op, e.Expr), true
+	case *BinaryExpr:
+		return fmt.Sprintf("b%d(%p,%p)", e.Op, e.Expr1, e.Expr2), true
+	case *IfExpr:
+		return fmt.Sprintf("if(%p,%p,%p)", e.Cond, e.If, e.Else), true
+	case *SliceExpr:
+		return fmt.Sprintf("s(%p)[%d:%d]", e.Expr, e.High, e.Low), true
+	case *IndexExpr:
+		return fmt.Sprintf("i(%p)[%p]", e.Expr, e.Index), true
+	case *ConcatExpr:
+		var sb strings.Builder
+		sb.WriteString("c")
+		for _, x := range e.Exprs {
+			fmt.Fprintf(&sb, "(%p)", x)
+		}
+		return sb.String(), true
+	case *ReplicateExpr:
+		return fmt.Sprintf("r%d(%p)", e.Count, e.Expr), true
+	case *CastExpr:
+		return fmt.Sprintf("cast%v(%p)", e.Signed, e.Expr), true
+	case *SizeExpr:
+		return fmt.Sprintf("size%d(%p)", e.Width, e.Expr), true
+	}
+	return "", false
+}
@@ -0,0 +1,131 @@
+package expr
+
+import "testing"
+
+func count(e Expr) int {
+	n := 0
+	Walk(e, func(Expr, []Expr) error {
+		n++
+		return nil
+	})
+	return n
+}
+
+func TestSimplifyFold(t *testing.T) {
+	e := Add(Mul(NewVector(8, 3), NewVector(8, 4)), Concat(NewVector(4, 1), NewVector(4, 2)))
+	r := Simplify(e)
+	if v, ok := r.(Value); !ok || !Eq(v, NewVector(8, 0x1e)) {
+		t.Fatal(r)
+	}
+
+	// the original tree is kept
+	if _, ok := e.Expr1.(*BinaryExpr); !ok {
+		t.Fatal(e.Expr1)
+	}
+
+	a := NewVar("a", NewVector(8, 1))
+	r = Simplify(Add(a, Sub(NewVector(8, 5), NewVector(8, 3))))
+	be, ok := r.(*BinaryExpr)
+	if !ok || be.Expr1 != a {
+		t.Fatal(r)
+	}
+	if v, ok := be.Expr2.(Value); !ok || v.Uint() != 2 {
+		t.Fatal(be.Expr2)
+	}
+
+	// a reversed slice is not folded away from Check
+	if _, diags := Check(Simplify(Slice(NewVector(8, 1), 3, 4))); len(Errors(diags)) == 0 {
+		t.Fatal("reversed slice folded")
+	}
+}
+
+func TestSimplifyIdentities(t *testing.T) {
+	a := NewVar("a", T)
+	b := NewVar("b", F)
+	v := NewVar("v", NewVector(8, 2))
+	lt := Less(v, a)
+
+	for _, tc := range []struct {
+		e, want Expr
+	}{
+		{And(lt, T), lt},
+		{And(T, lt), lt},
+		{And(lt, F), F},
+		{Or(lt, T), T},
+		{Or(F, lt), lt},
+		{Or(lt, Unknown(1)), Or(lt, Unknown(1))},
+		{Not(Not(lt)), lt},
+		{BitNot(BitNot(v)), v},
+		{Neg(Neg(v)), v},
+		{&IfExpr{T, a, b}, a},
+		{&IfExpr{Not(T), a, b}, b},
+		{&IfExpr{lt, a, a}, a},
+		{And(v, T), RedOr(v)},
+	} {
+		r := Simplify(tc.e)
+		if count(r) != count(tc.want) {
+			t.Fatal(r, tc.want)
+		}
+		if _, ok := tc.want.(*Var); ok && r != tc.want {
+			t.Fatal(r, tc.want)
+		}
+		if !Eq(r.Eval(), tc.e.Eval()) {
+			t.Fatal(r.Eval(), tc.e.Eval())
+		}
+	}
+
+	// !!v is not v for vectors
+	if r := Simplify(Not(Not(v))); r == v {
+		t.Fatal(r)
+	}
+
+	// unknown condition merges constant branches
+	r := Simplify(&IfExpr{Unknown(1), NewVector(2, 1), NewVector(2, 3)})
+	if !Eq(r.(Value), NewLogicVector(L1, LX)) {
+		t.Fatal(r)
+	}
+
+	// a branch narrower than the other one is extended
+	for _, e := range []Expr{&IfExpr{T, a, v}, &IfExpr{F, v, a}} {
+		r := Simplify(e)
+		if r == a || r.Eval().Width() != 8 || !Eq(r.Eval(), e.Eval()) {
+			t.Fatal(r)
+		}
+	}
+	r = Simplify(&IfExpr{T, NewSigned(4, -1), NewVector(8, 0)})
+	if !Eq(r.(Value), NewVector(8, 0xf)) {
+		t.Fatal(r)
+	}
+}
+
+func TestSimplifyShare(t *testing.T) {
+	a := NewVar("a", NewVector(8, 1))
+	b := NewVar("b", NewVector(8, 2))
+
+	e := BitXor(Add(a, b), Add(a, b))
+	r := Simplify(e).(*BinaryExpr)
+	if r.Expr1 != r.Expr2 {
+		t.Fatal(r)
+	}
+	if count(r) != 4 {
+		t.Fatal(count(r))
+	}
+
+	// equal constants are shared as well
+	r = Simplify(Concat(Add(a, NewVector(8, 1)), Add(a, NewVector(8, 1)))).(*ConcatExpr).Exprs[0].(*BinaryExpr)
+	if c := Simplify(Concat(r, Add(a, NewVector(8, 1)))).(*ConcatExpr); c.Exprs[0] != c.Exprs[1] {
+		t.Fatal(c)
+	}
+}
+
+func TestSimplifyCycle(t *testing.T) {
+	a := NewVar("a", T)
+	not := Not(a)
+	or := Or(And(not, T), nil)
+	or.Expr2 = or
+
+	r := Simplify(or).(*BinaryExpr)
+	if ue, ok := r.Expr1.(*UnaryExpr); !ok || ue.Expr != a || r.Expr2 != or {
+		t.Fatal(r)
+	}
+}
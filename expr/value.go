@@ -1,11 +1,17 @@
 package expr
 
+import (
+	"math"
+	"math/big"
+)
+
 type Value interface {
 	Expr
 	True() bool
 	Eq(v Value) bool
 	Width() uint
 	Uint() uint64
+	Signed() bool
 }
 
 type Bool bool
@@ -19,6 +25,9 @@ func (b *Bool) True() bool {
 }
 
 func (b *Bool) Eq(v Value) bool {
+	if lv, ok := v.(*LogicVector); ok {
+		return lv.Eq(b)
+	}
 	return b.True() == v.True()
 }
 
@@ -34,6 +43,10 @@ func (b *Bool) Uint() uint64 {
 	}
 }
 
+func (b *Bool) Signed() bool {
+	return false
+}
+
 func boolValue(b bool) *Bool {
 	if b {
 		return &True
@@ -52,13 +65,89 @@ var (
 	F = &False
 )
 
+// Vector is a bit vector of arbitrary width. Bits are stored in
+// little-endian 64 bit words and every bit above width is kept cleared.
+// Signed vectors hold two's complement numbers.
 type Vector struct {
-	value uint64
-	width uint
+	words  []uint64
+	width  uint
+	signed bool
+}
+
+const wordSize = 64
+
+func numWords(width uint) uint {
+	return (width + wordSize - 1) / wordSize
+}
+
+func NewVector(width uint, value uint64) *Vector {
+	vec := &Vector{make([]uint64, numWords(width)), width, false}
+	if len(vec.words) > 0 {
+		vec.words[0] = value
+	}
+	vec.truncate()
+	return vec
+}
+
+// NewSigned returns a signed vector holding value truncated to width bits.
+func NewSigned(width uint, value int64) *Vector {
+	return VectorFromBig(width, big.NewInt(value)).WithSign(true)
+}
+
+// VectorFromBig returns x truncated to width bits. Negative numbers are
+// represented in two's complement.
+func VectorFromBig(width uint, x *big.Int) *Vector {
+	vec := &Vector{make([]uint64, numWords(width)), width, false}
+	if x.Sign() < 0 {
+		mod := new(big.Int).Lsh(big.NewInt(1), width)
+		x = new(big.Int).Mod(x, mod)
+	}
+	w := new(big.Int)
+	mask := new(big.Int).SetUint64(math.MaxUint64)
+	for i := range vec.words {
+		vec.words[i] = w.And(w.Rsh(x, uint(i)*wordSize), mask).Uint64()
+	}
+	vec.truncate()
+	return vec
 }
 
-func (vec *Vector) Slice(from, to int) {
+func (vec *Vector) truncate() {
+	if rem := vec.width % wordSize; rem != 0 {
+		vec.words[len(vec.words)-1] &= 1<<rem - 1
+	}
+}
 
+// WithSign returns vec read as a signed or an unsigned number.
+func (vec *Vector) WithSign(signed bool) *Vector {
+	return &Vector{vec.words, vec.width, signed}
+}
+
+// extend returns vec with width bits, sign extending it if signed is set.
+func (vec *Vector) extend(width uint, signed bool) *Vector {
+	if width <= vec.width {
+		return vec
+	}
+	r := NewVector(width, 0)
+	copy(r.words, vec.words)
+	if signed && vec.width > 0 && vec.Bit(vec.width-1) {
+		for i := vec.width; i < width; i++ {
+			r.words[i/wordSize] |= 1 << (i % wordSize)
+		}
+	}
+	r.signed = vec.signed
+	return r
+}
+
+// Slice returns the bits vec[high:low]. Bits past the width read as 0. A
+// reversed slice, with high < low, has no bits.
+func (vec *Vector) Slice(high, low uint) *Vector {
+	if high < low {
+		return NewVector(0, 0)
+	}
+	r := NewVector(high-low+1, 0)
+	copyBits(r.words, 0, vec.words, low, r.width)
+	r.truncate()
+	return r
 }
 
 func (vec *Vector) Eval() Value {
@@ -66,27 +155,131 @@ func (vec *Vector) Eval() Value {
 }
 
 func (vec *Vector) True() bool {
-	return vec.value == 0
+	for _, w := range vec.words {
+		if w != 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func (vec *Vector) Eq(v Value) bool {
-	switch v.(type) {
-	case *Bool:
-		return (vec.value == 0) == v.True()
-	case *Vector:
-		vec2 := v.(*Vector)
-		if vec.Width() <= vec2.Width() {
-			panic("vecs shouldn't have distinc values")
+	if lv, ok := v.(*LogicVector); ok {
+		return lv.Eq(vec)
+	}
+	vec2 := vectorOf(v)
+	w, signed := max(vec.width, vec2.width), vec.signed && vec2.signed
+	vec1 := vec.extend(w, signed)
+	vec2 = vec2.extend(w, signed)
+	for i := range vec1.words {
+		if vec1.word(i) != vec2.word(i) {
+			return false
 		}
-		return vec.value == vec2.value
 	}
-	return false
+	return true
 }
 
 func (vec *Vector) Width() uint {
 	return vec.width
 }
 
+// Uint returns the 64 least significant bits of the vector.
 func (vec *Vector) Uint() uint64 {
-	return vec.value
+	return vec.word(0)
+}
+
+func (vec *Vector) Signed() bool {
+	return vec.signed
+}
+
+func (vec *Vector) Bit(i uint) bool {
+	return vec.word(int(i/wordSize))&(1<<(i%wordSize)) != 0
+}
+
+// Big returns the number held by vec, which is negative for signed vectors
+// with the most significant bit set.
+func (vec *Vector) Big() *big.Int {
+	x := new(big.Int)
+	for i := len(vec.words) - 1; i >= 0; i-- {
+		x.Lsh(x, wordSize)
+		x.Or(x, new(big.Int).SetUint64(vec.words[i]))
+	}
+	if vec.signed && vec.width > 0 && vec.Bit(vec.width-1) {
+		x.Sub(x, new(big.Int).Lsh(big.NewInt(1), vec.width))
+	}
+	return x
+}
+
+// word returns the i-th word of vec, zero extending it past its width.
+func (vec *Vector) word(i int) uint64 {
+	if i < len(vec.words) {
+		return vec.words[i]
+	}
+	return 0
+}
+
+func vectorOf(v Value) *Vector {
+	switch v := v.(type) {
+	case *Vector:
+		return v
+	case *LogicVector:
+		return v.vector()
+	}
+	return NewVector(v.Width(), v.Uint())
+}
+
+// copyBits copies n bits of src starting at bit from into dst starting at
+// bit to. Bits past the end of src read as 0.
+func copyBits(dst []uint64, to uint, src []uint64, from uint, n uint) {
+	for i := uint(0); i < n; i++ {
+		j, k := from+i, to+i
+		var bit uint64
+		if j/wordSize < uint(len(src)) {
+			bit = src[j/wordSize] >> (j % wordSize) & 1
+		}
+		dst[k/wordSize] = dst[k/wordSize]&^(1<<(k%wordSize)) | bit<<(k%wordSize)
+	}
+}
+
+func sliceValue(v Value, high, low uint) Value {
+	lv, ok := v.(*LogicVector)
+	if !ok && high < v.Width() {
+		if _, ok := v.(*Bool); ok && low == 0 {
+			return v
+		}
+		return vectorOf(v).Slice(high, low)
+	}
+	if !ok {
+		lv = LogicOf(v)
+	}
+	r := lv.Slice(high, low)
+	for i := max(lv.width, low); i <= high; i++ {
+		r.SetBit(i-low, LX)
+	}
+	return r
+}
+
+// concatValues joins values with the first one in the most significant
+// bits. The result is four-state if any of the values is.
+func concatValues(values ...Value) Value {
+	var width uint
+	fourState := false
+	for _, v := range values {
+		width += v.Width()
+		if _, ok := v.(*LogicVector); ok {
+			fourState = true
+		}
+	}
+	r := newLogicVector(width)
+	off := width
+	for _, v := range values {
+		lv := LogicOf(v)
+		off -= lv.width
+		copyBits(r.aval, off, lv.aval, 0, lv.width)
+		copyBits(r.bval, off, lv.bval, 0, lv.width)
+	}
+	if fourState {
+		return r
+	}
+	return r.vector()
 }
@@ -0,0 +1,126 @@
+package expr
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+var testWidths = []uint{1, 7, 63, 64, 65, 127, 128, 129, 512}
+
+func randBig(r *rand.Rand, width uint) *big.Int {
+	x := new(big.Int)
+	for i := uint(0); i < width; i += 32 {
+		x.Lsh(x, 32)
+		x.Or(x, big.NewInt(int64(r.Uint32())))
+	}
+	return x.And(x, mask(width))
+}
+
+func mask(width uint) *big.Int {
+	m := new(big.Int).Lsh(big.NewInt(1), width)
+	return m.Sub(m, big.NewInt(1))
+}
+
+func TestVectorBig(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, w := range testWidths {
+		for i := 0; i < 100; i++ {
+			x := randBig(r, w+8) // wider than the vector
+			vec := VectorFromBig(w, x)
+			want := new(big.Int).And(x, mask(w))
+			if vec.Big().Cmp(want) != 0 {
+				t.Fatal(w, vec.Big(), want)
+			}
+			if vec.Uint() != new(big.Int).And(want, mask(64)).Uint64() {
+				t.Fatal(w, vec.Uint(), want)
+			}
+		}
+	}
+
+	neg := VectorFromBig(128, big.NewInt(-1))
+	if neg.Big().Cmp(mask(128)) != 0 {
+		t.Fatal(neg.Big())
+	}
+}
+
+func TestVectorAdd(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, wa := range testWidths {
+		for _, wb := range testWidths {
+			for i := 0; i < 20; i++ {
+				a, b := randBig(r, wa), randBig(r, wb)
+				w := max(wa, wb)
+				sum := Add(VectorFromBig(wa, a), VectorFromBig(wb, b)).Eval()
+				want := new(big.Int).Add(a, b)
+				want.And(want, mask(w))
+				if sum.Width() != w {
+					t.Fatal(sum.Width(), w)
+				}
+				if got := sum.(*Vector).Big(); got.Cmp(want) != 0 {
+					t.Fatal(wa, wb, got, want)
+				}
+			}
+		}
+	}
+
+	// carry out of the top word must be dropped
+	ones := VectorFromBig(128, mask(128))
+	if sum := Add(ones, NewVector(1, 1)).Eval(); sum.True() {
+		t.Fatal(sum.(*Vector).Big())
+	}
+}
+
+func TestVectorEq(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, wa := range testWidths {
+		for _, wb := range testWidths {
+			for i := 0; i < 20; i++ {
+				a := randBig(r, wa)
+				b := new(big.Int).And(a, mask(wb))
+				if i%2 == 1 {
+					b = randBig(r, wb)
+				}
+				va, vb := VectorFromBig(wa, a), VectorFromBig(wb, b)
+				want := a.Cmp(b) == 0
+				if Eq(va, vb) != want || Eq(vb, va) != want {
+					t.Fatal(wa, wb, a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestVectorLogic(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, w := range testWidths {
+		for i := 0; i < 20; i++ {
+			a, b := randBig(r, w), randBig(r, w)
+			if i%4 == 0 {
+				a.SetInt64(0)
+			}
+			if i%5 == 0 {
+				// only the most significant bit set
+				b.Lsh(big.NewInt(1), w-1)
+			}
+			va, vb := VectorFromBig(w, a), VectorFromBig(w, b)
+			ta, tb := a.Sign() != 0, b.Sign() != 0
+			if va.True() != ta || vb.True() != tb {
+				t.Fatal(w, a, b)
+			}
+			if Not(va).Eval().True() != !ta {
+				t.Fatal(w, a)
+			}
+			if And(va, vb).Eval().True() != (ta && tb) {
+				t.Fatal(w, a, b)
+			}
+			if Or(va, vb).Eval().True() != (ta || tb) {
+				t.Fatal(w, a, b)
+			}
+		}
+	}
+}
+
+func bigOne(shift uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), shift)
+}
@@ -0,0 +1,275 @@
+// Package gen generates random expressions and modules, to cross-check the
+// evaluators of expr, the simulator and the Verilog emitter against each
+// other.
+package gen
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"reflect"
+
+	"github.com/dakerfp/verigo/expr"
+	"github.com/dakerfp/verigo/meta"
+)
+
+// Limits bounds the size of what a Generator builds.
+type Limits struct {
+	Depth  int // of expression trees
+	Inputs int // of modules, besides the clock; must be positive
+	Wires  int // combinational nodes of modules
+	Regs   int // nodes of modules updated on the clock edge
+}
+
+// DefaultLimits builds designs that are small enough to debug.
+var DefaultLimits = Limits{Depth: 4, Inputs: 4, Wires: 4, Regs: 3}
+
+// Generator builds random designs. The same seed and limits always build
+// the same designs.
+type Generator struct {
+	r      *rand.Rand
+	limits Limits
+}
+
+// New returns a Generator drawing from seed.
+func New(seed int64, limits Limits) *Generator {
+	return &Generator{rand.New(rand.NewSource(seed)), limits}
+}
+
+var widths = []uint{1, 2, 3, 8, 16, 31, 64, 65, 100, 130}
+
+// Width returns a random width of a value.
+func (g *Generator) Width() uint {
+	return widths[g.r.Intn(len(widths))]
+}
+
+// Value returns a random value of the given type. Small numbers, all ones
+// and values with X and Z bits are more likely than others.
+func (g *Generator) Value(width uint, signed bool) expr.Value {
+	switch g.r.Intn(8) {
+	case 0:
+		bits := make([]expr.Logic, width)
+		for i := range bits {
+			bits[i] = expr.Logic(g.r.Intn(4))
+		}
+		return expr.NewLogicVector(bits...).WithSign(signed)
+	case 1:
+		return expr.NewVector(width, uint64(g.r.Intn(4))).WithSign(signed)
+	case 2:
+		return expr.NewSigned(width, -1).WithSign(signed)
+	case 3:
+		if width == 1 && !signed {
+			if g.r.Intn(2) == 0 {
+				return expr.T
+			}
+			return expr.F
+		}
+	}
+	return g.KnownValue(width, signed)
+}
+
+// KnownValue returns a random value with no X or Z bits.
+func (g *Generator) KnownValue(width uint, signed bool) expr.Value {
+	max := new(big.Int).Lsh(big.NewInt(1), width)
+	return expr.VectorFromBig(width, new(big.Int).Rand(g.r, max)).WithSign(signed)
+}
+
+// Vars returns n vars named a, b, c and so on, with random values.
+func (g *Generator) Vars(n int) []*expr.Var {
+	vars := make([]*expr.Var, n)
+	for i := range vars {
+		vars[i] = expr.NewVar(string(rune('a'+i)), g.Value(g.Width(), g.r.Intn(2) == 0))
+	}
+	return vars
+}
+
+// Expr returns a random expression of leaves and constants with no errors
+// reported by expr.Check. Subexpressions may be shared.
+func (g *Generator) Expr(leaves ...expr.Expr) expr.Expr {
+	for {
+		var nodes []expr.Expr
+		e := g.expr(g.limits.Depth, leaves, &nodes)
+		if _, diags := expr.Check(e); len(expr.Errors(diags)) == 0 {
+			return e
+		}
+	}
+}
+
+func (g *Generator) leaf(leaves []expr.Expr, nodes []expr.Expr) expr.Expr {
+	switch n := g.r.Intn(10); {
+	case n < 5 && len(leaves) > 0:
+		return leaves[g.r.Intn(len(leaves))]
+	case n < 8 && len(nodes) > 0:
+		return nodes[g.r.Intn(len(nodes))]
+	}
+	return g.Value(g.Width(), g.r.Intn(2) == 0)
+}
+
+func width(e expr.Expr) uint {
+	t, _ := expr.Check(e)
+	return t.Width
+}
+
+func (g *Generator) expr(depth int, leaves []expr.Expr, nodes *[]expr.Expr) expr.Expr {
+	if depth <= 0 || g.r.Intn(6) == 0 {
+		return g.leaf(leaves, *nodes)
+	}
+	sub := func() expr.Expr {
+		return g.expr(depth-1, leaves, nodes)
+	}
+	var e expr.Expr
+	switch g.r.Intn(9) {
+	case 0, 1:
+		e = &expr.UnaryExpr{Expr: sub(), Op: expr.Op(g.r.Intn(int(expr.OpNeg) + 1))}
+	case 2, 3, 4:
+		op := expr.OpAnd + expr.Op(g.r.Intn(int(expr.OpAShr-expr.OpAnd)+1))
+		x := sub()
+		var y expr.Expr
+		switch op {
+		case expr.OpShl, expr.OpShr, expr.OpAShl, expr.OpAShr:
+			if g.r.Intn(2) == 0 {
+				y = expr.NewVector(8, uint64(g.r.Intn(140)))
+				break
+			}
+			fallthrough
+		default:
+			y = sub()
+		}
+		e = &expr.BinaryExpr{Expr1: x, Expr2: y, Op: op}
+	case 5:
+		e = &expr.IfExpr{Cond: sub(), If: sub(), Else: sub()}
+	case 6:
+		x := sub()
+		w := width(x)
+		if g.r.Intn(2) == 0 {
+			e = expr.Index(x, sub())
+			break
+		}
+		low := uint(g.r.Intn(int(w)))
+		e = expr.Slice(x, low+uint(g.r.Intn(int(w-low))), low)
+	case 7:
+		if g.r.Intn(2) == 0 {
+			e = expr.Replicate(uint(g.r.Intn(3)+1), sub())
+		} else {
+			e = expr.Concat(sub(), sub())
+		}
+	case 8:
+		if g.r.Intn(3) == 0 {
+			e = expr.Size(uint(g.r.Intn(12)+1), sub())
+			break
+		}
+		e = &expr.CastExpr{Expr: sub(), Signed: g.r.Intn(2) == 0}
+	}
+	*nodes = append(*nodes, e)
+	return e
+}
+
+var types = []reflect.Type{
+	reflect.TypeOf(false),
+	reflect.TypeOf(int8(0)), reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(int16(0)), reflect.TypeOf(uint16(0)),
+	reflect.TypeOf(int32(0)), reflect.TypeOf(uint32(0)),
+	reflect.TypeOf(int64(0)), reflect.TypeOf(uint64(0)),
+}
+
+func (g *Generator) node(name string) *meta.Node {
+	t := types[g.r.Intn(len(types))]
+	v := meta.ValueOf(reflect.New(t).Elem())
+	init, err := meta.Convert(g.KnownValue(v.Width(), v.Signed()), t)
+	if err != nil {
+		panic(err)
+	}
+	return &meta.Node{T: t, V: init, Name: name}
+}
+
+// Module returns a module with a clock input Clk, random inputs named I0,
+// I1 and so on, wires W0, W1... computed from the inputs, the registers and
+// the previous wires, and registers R0, R1... updated on the rising edge of
+// Clk from any other node. Registers and some wires are outputs. All nodes
+// start with random values.
+func (g *Generator) Module(name string) *meta.Mod {
+	m := &meta.Mod{Name: name, Values: make(map[string]*meta.Node)}
+	clk := &meta.Node{T: types[0], V: reflect.ValueOf(false), Name: "Clk", Mod: m}
+	m.Values[clk.Name] = clk
+	m.Inputs = append(m.Inputs, clk)
+
+	var inputs, wires, regs []*meta.Node
+	add := func(list *[]*meta.Node, prefix string, n int) {
+		for i := 0; i < n; i++ {
+			node := g.node(fmt.Sprint(prefix, i))
+			node.Mod = m
+			m.Values[node.Name] = node
+			*list = append(*list, node)
+		}
+	}
+	add(&inputs, "I", 1+g.r.Intn(g.limits.Inputs))
+	add(&wires, "W", g.r.Intn(g.limits.Wires+1))
+	add(&regs, "R", g.r.Intn(g.limits.Regs+1))
+	m.Inputs = append(m.Inputs, inputs...)
+
+	for i, n := range wires {
+		sources := append(append([]*meta.Node(nil), inputs...), regs...)
+		g.assign(m, n, append(sources, wires[:i]...), nil)
+		if g.r.Intn(2) == 0 {
+			m.Outputs = append(m.Outputs, n)
+		}
+	}
+	for _, n := range regs {
+		sources := append(append(append([]*meta.Node(nil), inputs...), wires...), regs...)
+		g.assign(m, n, sources, clk)
+		m.Outputs = append(m.Outputs, n)
+	}
+	return m
+}
+
+// assign sets a random expression of sources as the one of n, triggered by
+// any change of the sources or by the rising edge of clk. Half of the
+// expressions are as wide as n, the others are truncated or extended by the
+// assignment.
+func (g *Generator) assign(m *meta.Mod, n *meta.Node, sources []*meta.Node, clk *meta.Node) {
+	var leaves []expr.Expr
+	for _, src := range sources {
+		leaves = append(leaves, expr.NewVar(src.Name, src.Value()))
+	}
+	t := expr.TypeOf(n.Value())
+	var e expr.Expr
+	for {
+		e = g.Expr(leaves...)
+		if g.r.Intn(2) == 0 {
+			e = fit(e, t.Width)
+		}
+		if len(expr.Errors(expr.CheckAssign(t, e))) == 0 {
+			break
+		}
+	}
+	m.SetExpr(n, e)
+
+	if clk != nil {
+		meta.Connect(clk, n, meta.Posedge|meta.Block)
+	}
+	seen := make(map[string]bool)
+	expr.Walk(e, func(x expr.Expr, _ []expr.Expr) error {
+		v, ok := x.(*expr.Var)
+		if !ok || seen[v.Name] {
+			return nil
+		}
+		seen[v.Name] = true
+		if clk != nil {
+			meta.Connect(m.Values[v.Name], n, meta.Noedge)
+		} else {
+			meta.Connect(m.Values[v.Name], n, meta.Anyedge)
+		}
+		return nil
+	})
+}
+
+// fit truncates or zero extends e to n bits.
+func fit(e expr.Expr, n uint) expr.Expr {
+	switch w := width(e); {
+	case w > n:
+		return expr.Slice(e, n-1, 0)
+	case w < n:
+		return expr.Concat(expr.NewVector(n-w, 0), e)
+	}
+	return e
+}
@@ -0,0 +1,272 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dakerfp/verigo/expr"
+	"github.com/dakerfp/verigo/meta"
+	"github.com/dakerfp/verigo/sim"
+	"github.com/dakerfp/verigo/verilog"
+)
+
+func addSeeds(f *testing.F) {
+	for seed := int64(0); seed < 20; seed++ {
+		f.Add(seed)
+	}
+}
+
+// FuzzExpr checks that Eval agrees with compiled programs, with the
+// expression printed and parsed back and with its JSON encoding.
+func FuzzExpr(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, seed int64) {
+		g := New(seed, DefaultLimits)
+		vars := g.Vars(4)
+		var leaves []expr.Expr
+		env := make(expr.MapEnv)
+		for _, v := range vars {
+			leaves = append(leaves, v)
+			env[v.Name] = v
+		}
+		e := g.Expr(leaves...)
+
+		text := expr.Format(e)
+		parsed, err := expr.Parse(text, env)
+		if err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		data, err := expr.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := expr.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedVars := make(map[string]*expr.Var)
+		expr.Walk(decoded, func(x expr.Expr, _ []expr.Expr) error {
+			if v, ok := x.(*expr.Var); ok {
+				decodedVars[v.Name] = v
+			}
+			return nil
+		})
+
+		p := expr.Compile(e)
+		for run := 0; run < 4; run++ {
+			for _, v := range decodedVars {
+				v.Value = env[v.Name].(*expr.Var).Value
+			}
+			want := e.Eval()
+			if v := p.Run(); !expr.Eq(want, v) {
+				t.Fatalf("%s: compiled %v, want %v", text, v, expr.LogicOf(want))
+			}
+			if v := parsed.Eval(); !expr.Eq(want, v) {
+				t.Fatalf("%s: parsed %v, want %v", text, expr.LogicOf(v), expr.LogicOf(want))
+			}
+			if v := decoded.Eval(); !expr.Eq(want, v) {
+				t.Fatalf("%s: decoded %v, want %v", text, expr.LogicOf(v), expr.LogicOf(want))
+			}
+			for _, v := range vars {
+				v.Value = g.Value(v.Value.Width(), v.Value.Signed())
+			}
+		}
+	})
+}
+
+// model computes the values of the nodes of a generated module on its own,
+// wires in order and registers at once on every clock. Like the simulator,
+// it holds X for the whole of a node which gets any X bit, and registers
+// start as X.
+type model struct {
+	values              map[string]expr.Value
+	inputs, wires, regs []*meta.Node
+}
+
+func newModel(m *meta.Mod) *model {
+	md := &model{values: make(map[string]expr.Value)}
+	for _, n := range m.Values {
+		md.values[n.Name] = n.Value()
+	}
+	md.inputs = m.Inputs[1:] // after the clock
+	md.wires = nodes(m, "W")
+	md.regs = nodes(m, "R")
+	for _, n := range md.regs {
+		md.values[n.Name] = unknown(n)
+	}
+	return md
+}
+
+// nodes returns the nodes of m named by prefix followed by a number.
+func nodes(m *meta.Mod, prefix string) []*meta.Node {
+	var list []*meta.Node
+	for i := 0; ; i++ {
+		n, ok := m.Values[fmt.Sprint(prefix, i)]
+		if !ok {
+			return list
+		}
+		list = append(list, n)
+	}
+}
+
+// unknown returns X with the type of n.
+func unknown(n *meta.Node) expr.Value {
+	v := n.Value()
+	return expr.Unknown(v.Width()).WithSign(v.Signed())
+}
+
+// set returns v assigned to n, which is X if any bit of v is.
+func set(n *meta.Node, v expr.Value) expr.Value {
+	if !expr.LogicOf(v).Known() {
+		return unknown(n)
+	}
+	x, err := meta.Convert(v, n.T)
+	if err != nil {
+		panic(err)
+	}
+	return meta.ValueOf(x)
+}
+
+// eval evaluates a copy of the expression of n with the values of the
+// model.
+func (md *model) eval(n *meta.Node) expr.Value {
+	e := expr.Rewrite(n.Expr, func(x expr.Expr) (expr.Expr, bool) {
+		if v, ok := x.(*expr.Var); ok {
+			return md.values[v.Name], true
+		}
+		return x, false
+	})
+	return set(n, e.Eval())
+}
+
+func (md *model) settle() {
+	for _, n := range md.wires {
+		md.values[n.Name] = md.eval(n)
+	}
+}
+
+func (md *model) clock() {
+	next := make([]expr.Value, len(md.regs))
+	for i, n := range md.regs {
+		next[i] = md.eval(n)
+	}
+	for i, n := range md.regs {
+		md.values[n.Name] = next[i]
+	}
+	md.settle()
+}
+
+// FuzzModule checks that the statements of the Verilog translation of a
+// generated module compute the same values as its nodes, then simulates it
+// for a few clock cycles with random inputs, which may hold X and Z, and
+// checks every node against a model of it.
+func FuzzModule(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, seed int64) {
+		g := New(seed, DefaultLimits)
+		m := g.Module("Random")
+		md := newModel(m)
+		checkVerilog(t, m)
+
+		clk := m.Values["Clk"]
+		now := time.Now()
+		s := sim.NewSimulator()
+		for cycle := 0; cycle < 4; cycle++ {
+			inputs := make([]expr.Value, len(md.inputs))
+			for i, n := range md.inputs {
+				v := n.Value()
+				inputs[i] = g.Value(v.Width(), v.Signed())
+				md.values[n.Name] = set(n, inputs[i])
+			}
+			go func() {
+				for i, n := range md.inputs {
+					s.Set(n, inputs[i], now)
+				}
+				s.Set(clk, true, now.Add(1))
+				s.Set(clk, false, now.Add(2))
+				s.End()
+			}()
+			s.Run()
+			now = now.Add(3)
+
+			md.settle()
+			md.clock()
+			for name, want := range md.values {
+				if name == "Clk" {
+					continue
+				}
+				if got := s.Value(m.Values[name]); !expr.Eq(got, want) {
+					t.Fatalf("cycle %d: %s = %v, want %v", cycle, name, expr.LogicOf(got), expr.LogicOf(want))
+				}
+			}
+		}
+	})
+}
+
+var (
+	assignLine = regexp.MustCompile(`^\tassign (\w+) = (.*);$`)
+	alwaysLine = regexp.MustCompile(`^\talways_ff @\(posedge Clk\) (\w+) <= (.*);$`)
+)
+
+// checkVerilog parses back the statements of the Verilog translation of m,
+// evaluates them by the rules of Verilog and compares them with the nodes
+// they are translated from.
+func checkVerilog(t *testing.T, m *meta.Mod) {
+	var buf bytes.Buffer
+	if err := verilog.GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	env := make(expr.MapEnv)
+	for name, n := range m.Values {
+		env[name] = expr.NewVar(name, meta.ValueOf(n.V))
+	}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		match := assignLine.FindStringSubmatch(line)
+		edge := false
+		if match == nil {
+			match = alwaysLine.FindStringSubmatch(line)
+			edge = true
+		}
+		if match == nil {
+			continue
+		}
+		n := m.Values[match[1]]
+		if n == nil || n.Update == nil || seen[n.Name] {
+			t.Fatalf("%s: unexpected statement", line)
+		}
+		seen[n.Name] = true
+		if register := strings.HasPrefix(n.Name, "R"); edge != register {
+			t.Fatalf("%s: wrong kind of statement", line)
+		}
+		e, err := expr.Parse(match[2], env)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		// the statement needs no more size casts, so Verilog computes
+		// the value of Eval
+		typ := expr.TypeOf(n.Value())
+		if diags := expr.Errors(expr.CheckAssign(typ, e)); len(diags) > 0 {
+			t.Fatalf("%s: %v", line, diags)
+		}
+		if sized := expr.Format(expr.Sized(typ, e)); sized != expr.Format(e) {
+			t.Fatalf("%s: Verilog computes %s", line, sized)
+		}
+		got, err := meta.Convert(e.Eval(), n.T)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := n.Update(); !expr.Eq(meta.ValueOf(got), meta.ValueOf(want)) {
+			t.Fatalf("%s: got %v, want %v", line, got, want)
+		}
+	}
+	for name, n := range m.Values {
+		if n.Update != nil && !seen[name] {
+			t.Fatalf("no statement for %s", name)
+		}
+	}
+}
@@ -0,0 +1,800 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+var (
+	ErrInvalidIdentifier = errors.New("invalid identifier")
+	ErrNoDependency      = errors.New("no dependency found")
+)
+
+// funcs are the functions which Always expressions may call.
+var funcs = make(map[string]reflect.Value)
+
+// Register makes fn callable by name from the expressions of Always. fn
+// must be a pure function returning a single value, since the simulator
+// calls it whenever the signals it is given may have changed. Calls cannot
+// be translated to Verilog.
+func Register(name string, fn interface{}) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.Type().NumOut() != 1 || v.Type().IsVariadic() {
+		panic(fmt.Errorf("%s is not a function returning a single value", name))
+	}
+	funcs[name] = v
+}
+
+// Always makes the signal recv compute the Go expression x. It is updated
+// whenever any of the signals is triggered or, if none is given, whenever
+// any signal read by x changes.
+//
+// The expression may use all Go unary and binary operators, parentheses,
+// integer literals in any base, true, false, the signals of m and calls to
+// the functions given to Register. Operators follow the Go semantics,
+// except that integers of different types may be mixed: the result has the
+// type of the widest operand, and it is signed only if both are, as in
+// Verilog. Bools are also accepted by the bitwise operators, as 1-bit
+// integers. Division by zero gives zero. The result is converted to the
+// type of recv.
+//
+// The expression tree kept in the Expr of recv evaluates to the same
+// values: operands are converted to the type of their operator with size
+// casts, constants are sized and divisions test for a zero divisor.
+//
+// An invalid expression returns an *expr.ParseError with the column of the
+// construct at fault.
+func (m *Mod) Always(recv string, x string, signals ...Signal) error {
+	recvN, ok := m.Values[recv]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidIdentifier, recv)
+	}
+	for _, signal := range signals {
+		if _, ok := m.Values[signal.Name]; !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidIdentifier, signal.Name)
+		}
+	}
+
+	fset := token.NewFileSet()
+	e, err := parser.ParseExprFrom(fset, "", x, 0)
+	if err != nil {
+		return parseError(fset, err)
+	}
+	a := newAssembler(m)
+	op, err := a.assemble(e)
+	if err != nil {
+		return parseError(fset, err)
+	}
+	update, tree, err := op.convert(recvN.T, e.Pos())
+	if err != nil {
+		return parseError(fset, err)
+	}
+	recvN.Update = update
+	// keep the expression tree for checking and code generation
+	recvN.Expr = tree
+
+	// if there is any explicit signal, use it and keep
+	// deps as data dependencies which never trigger an update
+	// otherwise, use deps as if it is combinational
+	if len(signals) == 0 {
+		signals = a.deps
+	} else {
+		for _, dep := range a.deps {
+			signals = append(signals, Signal{dep.Name, Noedge})
+		}
+	}
+	for _, signal := range signals {
+		Connect(m.Values[signal.Name], recvN, signal.Sensivity)
+	}
+	return nil
+}
+
+// env is the expr.Parse environment of the signals of a module.
+type env struct {
+	m    *Mod
+	vars map[string]*expr.Var
+}
+
+func (e env) Lookup(name string) (expr.Expr, bool) {
+	if v, ok := e.vars[name]; ok {
+		return v, true
+	}
+	n, ok := e.m.Values[name]
+	if !ok {
+		return nil, false
+	}
+	v := expr.NewVar(name, n.Value())
+	e.vars[name] = v
+	return v, true
+}
+
+// operand is an assembled Go expression.
+type operand struct {
+	t    reflect.Type // nil for untyped integer constants
+	c    *big.Int     // value of untyped constants
+	eval UpdateFunc
+	x    expr.Expr // nil if it cannot be translated
+}
+
+var (
+	boolType = reflect.TypeOf(false)
+	intType  = reflect.TypeOf(0)
+)
+
+func constant(c *big.Int, x expr.Expr) *operand {
+	return &operand{c: c, x: x}
+}
+
+func (op *operand) untyped() bool {
+	return op.t == nil
+}
+
+func isInt(t reflect.Type) bool {
+	return t != nil && integer(t.Kind())
+}
+
+func isSigned(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// fits reports whether c is a value of the integer type t.
+func fits(c *big.Int, t reflect.Type) bool {
+	bits := uint(t.Bits())
+	if isSigned(t) {
+		limit := new(big.Int).Lsh(big.NewInt(1), bits-1)
+		return c.Cmp(new(big.Int).Neg(limit)) >= 0 && c.Cmp(limit) < 0
+	}
+	return c.Sign() >= 0 && c.BitLen() <= int(bits)
+}
+
+// intValue returns the value of v, an integer.
+func intValue(v reflect.Value) *big.Int {
+	if isSigned(v.Type()) {
+		return big.NewInt(v.Int())
+	}
+	return new(big.Int).SetUint64(v.Uint())
+}
+
+// xType returns the type of the Verilog form of values of type t.
+func xType(t reflect.Type) expr.Type {
+	if t.Kind() == reflect.Bool {
+		return expr.Type{Width: 1}
+	}
+	return expr.Type{Width: uint(t.Bits()), Signed: isSigned(t)}
+}
+
+// convertX returns x, the Verilog form of an integer or a bool, converted
+// to type t as Go converts integers.
+func convertX(x expr.Expr, t reflect.Type) expr.Expr {
+	if x == nil {
+		return nil
+	}
+	xt, _ := expr.Check(x)
+	want := xType(t)
+	if xt.Width != want.Width {
+		x = expr.Size(want.Width, x)
+	}
+	if xt.Signed != want.Signed {
+		x = &expr.CastExpr{Expr: x, Signed: want.Signed}
+	}
+	return x
+}
+
+// constantX returns the Verilog literal of c, a value of the integer type t.
+func constantX(c *big.Int, t reflect.Type) expr.Expr {
+	if isSigned(t) {
+		return expr.NewSigned(uint(t.Bits()), c.Int64())
+	}
+	return expr.NewVector(uint(t.Bits()), c.Uint64())
+}
+
+// symbolic reports whether x reads a Var, such as a parameter.
+func symbolic(x expr.Expr) bool {
+	found := errors.New("found")
+	return expr.Walk(x, func(e expr.Expr, _ []expr.Expr) error {
+		if _, ok := e.(*expr.Var); ok {
+			return found
+		}
+		return nil
+	}) != nil
+}
+
+// typed returns op as a value of type t. Only untyped constants change
+// their type.
+func (op *operand) typed(t reflect.Type, pos token.Pos) (*operand, error) {
+	if !op.untyped() {
+		return op, nil
+	}
+	if !isInt(t) {
+		return nil, errorf(pos, "cannot use constant %v as %v", op.c, t)
+	}
+	if !fits(op.c, t) {
+		return nil, errorf(pos, "constant %v overflows %v", op.c, t)
+	}
+	v := reflect.New(t).Elem()
+	if isSigned(t) {
+		v.SetInt(op.c.Int64())
+	} else {
+		v.SetUint(op.c.Uint64())
+	}
+	// parameters keep their names, other constants are folded
+	x := op.x
+	if x != nil && symbolic(x) {
+		x = convertX(x, t)
+	} else if x != nil {
+		x = constantX(op.c, t)
+	}
+	return &operand{t: t, eval: func() reflect.Value { return v }, x: x}, nil
+}
+
+// convert returns the update function of a signal of type t computing op,
+// which converts integers as in an assignment, and its Verilog form.
+func (op *operand) convert(t reflect.Type, pos token.Pos) (UpdateFunc, expr.Expr, error) {
+	op, err := op.typed(t, pos)
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case op.t == t:
+		return op.eval, op.x, nil
+	case isInt(op.t) && isInt(t):
+		eval := op.eval
+		return func() reflect.Value { return eval().Convert(t) }, convertX(op.x, t), nil
+	case !op.t.AssignableTo(t):
+		return nil, nil, errorf(pos, "cannot use %v as %v", op.t, t)
+	}
+	eval := op.eval
+	return func() reflect.Value {
+		v := reflect.New(t).Elem()
+		v.Set(eval())
+		return v
+	}, op.x, nil
+}
+
+// posError is an error found at a position of the parsed source.
+type posError struct {
+	pos token.Pos
+	msg string
+}
+
+func (err *posError) Error() string {
+	return err.msg
+}
+
+func errorf(pos token.Pos, format string, args ...interface{}) error {
+	return &posError{pos, fmt.Sprintf(format, args...)}
+}
+
+// position returns the position of the first error found in a source
+// parsed into fset, and its message.
+func position(fset *token.FileSet, err error) (token.Position, string, bool) {
+	var list scanner.ErrorList
+	var perr *posError
+	switch {
+	case errors.As(err, &list) && len(list) > 0:
+		return list[0].Pos, list[0].Msg, true
+	case errors.As(err, &perr):
+		return fset.Position(perr.pos), perr.msg, true
+	}
+	return token.Position{}, "", false
+}
+
+// parseError converts err, found in an expression parsed into fset, to an
+// *expr.ParseError.
+func parseError(fset *token.FileSet, err error) error {
+	pos, msg, ok := position(fset, err)
+	if !ok {
+		return err
+	}
+	return &expr.ParseError{Col: pos.Column, Msg: msg}
+}
+
+// assembler builds the update functions of Go expressions.
+type assembler struct {
+	m    *Mod
+	env  env
+	deps []Signal
+	seen map[string]bool
+	b    *block // block being assembled, if any
+}
+
+func newAssembler(m *Mod) *assembler {
+	return &assembler{m: m, env: env{m, make(map[string]*expr.Var)}, seen: make(map[string]bool)}
+}
+
+// unsupported returns the error of a construct which cannot be assembled.
+func unsupported(n ast.Node) error {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+	return errorf(n.Pos(), "unsupported %s", name)
+}
+
+func (a *assembler) assemble(e ast.Expr) (*operand, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return a.assemble(e.X)
+	case *ast.Ident:
+		return a.ident(e)
+	case *ast.SelectorExpr:
+		if _, ok := signalName(e); ok {
+			return a.ident(e)
+		}
+	case *ast.BasicLit:
+		return a.literal(e)
+	case *ast.UnaryExpr:
+		x, err := a.assemble(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return unary(e, x)
+	case *ast.BinaryExpr:
+		x, err := a.assemble(e.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := a.assemble(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		return binary(e, x, y)
+	case *ast.CallExpr:
+		return a.call(e)
+	}
+	return nil, unsupported(e)
+}
+
+// signalName returns the name of the signal read by e, an identifier or
+// the port of an interface, such as Out.Valid.
+func signalName(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name, true
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok {
+			return x.Name + "." + e.Sel.Name, true
+		}
+	}
+	return "", false
+}
+
+func (a *assembler) ident(e ast.Expr) (*operand, error) {
+	name, _ := signalName(e)
+	n, ok := a.m.Values[name]
+	if p, isParam := a.m.param(name); !ok && isParam {
+		// an untyped constant, kept by name in the Verilog form
+		return constant(intValue(p.V), expr.NewVar(name, p.Value())), nil
+	}
+	if !ok {
+		switch name {
+		case "true", "false":
+			v := reflect.ValueOf(name == "true")
+			x := expr.Expr(expr.F)
+			if v.Bool() {
+				x = expr.T
+			}
+			return &operand{t: boolType, eval: func() reflect.Value { return v }, x: x}, nil
+		}
+		return nil, errorf(e.Pos(), "undefined: %s", name)
+	}
+	if !a.seen[name] {
+		a.seen[name] = true
+		a.deps = append(a.deps, Signal{name, Anyedge})
+	}
+	x, _ := a.env.Lookup(name)
+	x = convertX(x, n.T) // narrow signals are extended
+	eval := func() reflect.Value { return n.V }
+	if a.b != nil {
+		eval = a.b.reader(n)
+		if bx, ok := a.b.sym[name]; ok && a.b.blocking {
+			x = bx
+		}
+	}
+	return &operand{t: n.T, eval: eval, x: x}, nil
+}
+
+func (a *assembler) literal(e *ast.BasicLit) (*operand, error) {
+	c, ok := new(big.Int).SetString(e.Value, 0)
+	switch {
+	case e.Kind == token.CHAR:
+		r := []rune(strings.Trim(e.Value, "'"))
+		if len(r) != 1 {
+			return nil, errorf(e.Pos(), "unsupported character %s", e.Value)
+		}
+		return constant(big.NewInt(int64(r[0])), expr.NewVector(32, uint64(r[0]))), nil
+	case e.Kind != token.INT || !ok:
+		return nil, errorf(e.Pos(), "unsupported literal %s", e.Value)
+	}
+	x, err := expr.Parse(strings.Replace(e.Value, "_", "", -1), nil)
+	if err != nil {
+		return nil, errorf(e.Pos(), "%v", err)
+	}
+	return constant(c, x), nil
+}
+
+func (a *assembler) call(e *ast.CallExpr) (*operand, error) {
+	id, ok := e.Fun.(*ast.Ident)
+	if !ok {
+		return nil, errorf(e.Fun.Pos(), "only registered functions can be called")
+	}
+	fn, ok := funcs[id.Name]
+	if !ok {
+		return nil, errorf(id.Pos(), "undefined function: %s", id.Name)
+	}
+	t := fn.Type()
+	if len(e.Args) != t.NumIn() {
+		return nil, errorf(e.Lparen, "%s takes %d arguments, got %d", id.Name, t.NumIn(), len(e.Args))
+	}
+	args := make([]UpdateFunc, len(e.Args))
+	for i, arg := range e.Args {
+		op, err := a.assemble(arg)
+		if err != nil {
+			return nil, err
+		}
+		if op, err = op.typed(t.In(i), arg.Pos()); err != nil {
+			return nil, err
+		}
+		if !op.t.AssignableTo(t.In(i)) {
+			return nil, errorf(arg.Pos(), "cannot use %v as %v in call to %s", op.t, t.In(i), id.Name)
+		}
+		args[i] = op.eval
+	}
+	eval := func() reflect.Value {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			in[i] = arg()
+		}
+		return fn.Call(in)[0]
+	}
+	return &operand{t: t.Out(0), eval: eval}, nil
+}
+
+// unary applies a unary operator. The complement ^ of a bool is its
+// negation.
+func unary(e *ast.UnaryExpr, x *operand) (*operand, error) {
+	if x.untyped() {
+		c := new(big.Int)
+		switch e.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return constant(c.Neg(x.c), withX(x.x, expr.Neg)), nil
+		case token.XOR:
+			return constant(c.Not(x.c), withX(x.x, expr.BitNot)), nil
+		}
+		return nil, errorf(e.OpPos, "operator %s not defined on untyped constants", e.Op)
+	}
+
+	eval := x.eval
+	r := &operand{t: x.t}
+	switch {
+	case e.Op == token.NOT && x.t.Kind() == reflect.Bool,
+		e.Op == token.XOR && x.t.Kind() == reflect.Bool:
+		r.eval = func() reflect.Value { return reflect.ValueOf(!eval().Bool()).Convert(r.t) }
+		if e.Op == token.NOT {
+			r.x = withX(x.x, expr.Not)
+		} else {
+			r.x = withX(x.x, expr.BitNot)
+		}
+	case e.Op == token.ADD && isInt(x.t):
+		return x, nil
+	case e.Op == token.SUB && isInt(x.t):
+		r.eval = func() reflect.Value { return reflect.ValueOf(-toUint(eval())).Convert(r.t) }
+		r.x = withX(x.x, expr.Neg)
+	case e.Op == token.XOR && isInt(x.t):
+		r.eval = func() reflect.Value { return reflect.ValueOf(^toUint(eval())).Convert(r.t) }
+		r.x = withX(x.x, expr.BitNot)
+	default:
+		return nil, errorf(e.OpPos, "operator %s not defined on %v", e.Op, x.t)
+	}
+	return r, nil
+}
+
+// withX applies f to x if it has a Verilog form.
+func withX(x expr.Expr, f func(expr.Expr) *expr.UnaryExpr) expr.Expr {
+	if x == nil {
+		return nil
+	}
+	return f(x)
+}
+
+var binaryOps = map[token.Token]expr.Op{
+	token.ADD:  expr.OpAdd,
+	token.SUB:  expr.OpSub,
+	token.MUL:  expr.OpMul,
+	token.QUO:  expr.OpDiv,
+	token.REM:  expr.OpMod,
+	token.AND:  expr.OpBitAnd,
+	token.OR:   expr.OpBitOr,
+	token.XOR:  expr.OpBitXor,
+	token.SHL:  expr.OpShl,
+	token.SHR:  expr.OpShr,
+	token.LAND: expr.OpAnd,
+	token.LOR:  expr.OpOr,
+	token.EQL:  expr.OpEq,
+	token.NEQ:  expr.OpNe,
+	token.LSS:  expr.OpLt,
+	token.LEQ:  expr.OpLe,
+	token.GTR:  expr.OpGt,
+	token.GEQ:  expr.OpGe,
+}
+
+// binaryX returns the Verilog form of a binary expression of type t.
+func binaryX(op token.Token, t reflect.Type, x, y expr.Expr) expr.Expr {
+	if x == nil || y == nil {
+		return nil
+	}
+	switch {
+	case op == token.AND_NOT:
+		return expr.BitAnd(x, expr.BitNot(y))
+	case op == token.SHR && isSigned(t):
+		return expr.AShr(x, y)
+	}
+	return &expr.BinaryExpr{Expr1: x, Expr2: y, Op: binaryOps[op]}
+}
+
+func comparison(op token.Token) bool {
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return true
+	}
+	return false
+}
+
+func shift(op token.Token) bool {
+	return op == token.SHL || op == token.SHR
+}
+
+func binary(e *ast.BinaryExpr, x, y *operand) (*operand, error) {
+	if x.untyped() && y.untyped() {
+		return constantBinary(e, x, y)
+	}
+
+	var err error
+	if shift(e.Op) {
+		if x, err = x.typed(intType, e.X.Pos()); err != nil {
+			return nil, err
+		}
+		if y.untyped() && y.c.Sign() < 0 {
+			return nil, errorf(e.Y.Pos(), "negative shift count %v", y.c)
+		}
+		// the count is self-determined, so a constant keeps its form
+		count := y.x
+		if y, err = y.typed(reflect.TypeOf(uint(0)), e.Y.Pos()); err != nil {
+			return nil, err
+		}
+		if !isInt(x.t) || !isInt(y.t) {
+			return nil, errorf(e.OpPos, "invalid shift of %v by %v", x.t, y.t)
+		}
+		return shiftOp(e, x, y, count), nil
+	}
+
+	if x.untyped() {
+		x, err = x.typed(y.t, e.X.Pos())
+	} else {
+		y, err = y.typed(x.t, e.Y.Pos())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if x.t.Kind() == reflect.Bool && y.t.Kind() == reflect.Bool {
+		return boolOp(e, x, y)
+	}
+	if !isInt(x.t) || !isInt(y.t) {
+		return nil, errorf(e.OpPos, "operator %s not defined on %v and %v", e.Op, x.t, y.t)
+	}
+	if e.Op == token.LAND || e.Op == token.LOR {
+		return nil, errorf(e.OpPos, "operator %s not defined on integers", e.Op)
+	}
+	return intOp(e, x, y), nil
+}
+
+// boolOp applies a logical, bitwise or equality operator to bools.
+func boolOp(e *ast.BinaryExpr, x, y *operand) (*operand, error) {
+	var f func(a, b bool) bool
+	switch e.Op {
+	case token.LAND, token.AND:
+		f = func(a, b bool) bool { return a && b }
+	case token.LOR, token.OR:
+		f = func(a, b bool) bool { return a || b }
+	case token.XOR, token.NEQ:
+		f = func(a, b bool) bool { return a != b }
+	case token.EQL:
+		f = func(a, b bool) bool { return a == b }
+	case token.AND_NOT:
+		f = func(a, b bool) bool { return a && !b }
+	default:
+		return nil, errorf(e.OpPos, "operator %s not defined on bool", e.Op)
+	}
+	ex, ey := x.eval, y.eval
+	eval := func() reflect.Value { return reflect.ValueOf(f(ex().Bool(), ey().Bool())) }
+	return &operand{t: boolType, eval: eval, x: binaryX(e.Op, boolType, x.x, y.x)}, nil
+}
+
+// commonType returns the type in which integers of types x and y are
+// combined.
+func commonType(x, y reflect.Type) reflect.Type {
+	if x == y {
+		return x
+	}
+	if x.Bits() < y.Bits() {
+		x, y = y, x
+	}
+	if isSigned(x) && isSigned(y) {
+		return x
+	}
+	return unsignedTypes[x.Kind()]
+}
+
+func intOp(e *ast.BinaryExpr, x, y *operand) *operand {
+	t := commonType(x.t, y.t)
+	signed := isSigned(t)
+	ex, ey := x.eval, y.eval
+	op := e.Op
+	xx, yx := convertX(x.x, t), convertX(y.x, t)
+	r := &operand{t: t, x: binaryX(op, t, xx, yx)}
+	if (op == token.QUO || op == token.REM) && r.x != nil {
+		zero := constantX(new(big.Int), t)
+		r.x = &expr.IfExpr{Cond: expr.Equal(yx, zero), If: zero, Else: r.x}
+	}
+	if comparison(op) {
+		r.t = boolType
+		r.eval = func() reflect.Value {
+			a, b := toUint(ex().Convert(t)), toUint(ey().Convert(t))
+			return reflect.ValueOf(compare(op, cmp(a, b, signed)))
+		}
+		return r
+	}
+	r.eval = func() reflect.Value {
+		a, b := toUint(ex().Convert(t)), toUint(ey().Convert(t))
+		return reflect.ValueOf(arith(op, a, b, signed)).Convert(t)
+	}
+	return r
+}
+
+// cmp compares two integers given by their two's complement bits.
+func cmp(a, b uint64, signed bool) int {
+	switch {
+	case a == b:
+		return 0
+	case signed && int64(a) < int64(b), !signed && a < b:
+		return -1
+	}
+	return 1
+}
+
+// compare applies a comparison operator to the result c of cmp.
+func compare(op token.Token, c int) bool {
+	switch op {
+	case token.EQL:
+		return c == 0
+	case token.NEQ:
+		return c != 0
+	case token.LSS:
+		return c < 0
+	case token.LEQ:
+		return c <= 0
+	case token.GTR:
+		return c > 0
+	case token.GEQ:
+		return c >= 0
+	}
+	panic(op)
+}
+
+// arith applies an arithmetic or bitwise operator to two integers given by
+// their two's complement bits.
+func arith(op token.Token, a, b uint64, signed bool) uint64 {
+	switch op {
+	case token.ADD:
+		return a + b
+	case token.SUB:
+		return a - b
+	case token.MUL:
+		return a * b
+	case token.QUO, token.REM:
+		switch {
+		case b == 0:
+			return 0
+		case signed && op == token.QUO:
+			return uint64(int64(a) / int64(b))
+		case signed:
+			return uint64(int64(a) % int64(b))
+		case op == token.QUO:
+			return a / b
+		}
+		return a % b
+	case token.AND:
+		return a & b
+	case token.OR:
+		return a | b
+	case token.XOR:
+		return a ^ b
+	case token.AND_NOT:
+		return a &^ b
+	}
+	panic(op)
+}
+
+func shiftOp(e *ast.BinaryExpr, x, y *operand, count expr.Expr) *operand {
+	t := x.t
+	ex, ey := x.eval, y.eval
+	left := e.Op == token.SHL
+	eval := func() reflect.Value {
+		count := ey()
+		n := toUint(count)
+		if isSigned(count.Type()) && count.Int() < 0 {
+			n = ^uint64(0) // shifts everything out
+		}
+		v := ex()
+		if isSigned(t) {
+			if left {
+				return reflect.ValueOf(v.Int() << n).Convert(t)
+			}
+			return reflect.ValueOf(v.Int() >> n).Convert(t)
+		}
+		if left {
+			return reflect.ValueOf(v.Uint() << n).Convert(t)
+		}
+		return reflect.ValueOf(v.Uint() >> n).Convert(t)
+	}
+	return &operand{t: t, eval: eval, x: binaryX(e.Op, t, x.x, count)}
+}
+
+// constantBinary folds an operation on untyped constants.
+func constantBinary(e *ast.BinaryExpr, x, y *operand) (*operand, error) {
+	a, b := x.c, y.c
+	c := new(big.Int)
+	xx := binaryX(e.Op, intType, x.x, y.x)
+	switch e.Op {
+	case token.ADD:
+		c.Add(a, b)
+	case token.SUB:
+		c.Sub(a, b)
+	case token.MUL:
+		c.Mul(a, b)
+	case token.QUO, token.REM:
+		if b.Sign() == 0 {
+			return nil, errorf(e.Y.Pos(), "division by zero")
+		}
+		if e.Op == token.QUO {
+			c.Quo(a, b)
+		} else {
+			c.Rem(a, b)
+		}
+	case token.AND:
+		c.And(a, b)
+	case token.OR:
+		c.Or(a, b)
+	case token.XOR:
+		c.Xor(a, b)
+	case token.AND_NOT:
+		c.AndNot(a, b)
+	case token.SHL, token.SHR:
+		if b.Sign() < 0 || b.Cmp(big.NewInt(1024)) > 0 {
+			return nil, errorf(e.Y.Pos(), "invalid shift count %v", b)
+		}
+		if e.Op == token.SHL {
+			c.Lsh(a, uint(b.Uint64()))
+		} else {
+			c.Rsh(a, uint(b.Uint64()))
+		}
+	default:
+		if !comparison(e.Op) {
+			return nil, errorf(e.OpPos, "operator %s not defined on untyped constants", e.Op)
+		}
+		v := reflect.ValueOf(compare(e.Op, a.Cmp(b)))
+		return &operand{t: boolType, eval: func() reflect.Value { return v }, x: xx}, nil
+	}
+	return constant(c, xx), nil
+}
@@ -0,0 +1,160 @@
+package meta
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+type ALU struct {
+	Mod
+
+	A, B   uint8 "input"
+	S      int8  "input"
+	Sel    bool  "input"
+	Out    uint8 "output"
+	SOut   int16 "output"
+	Flag   bool  "output"
+	Parity bool  "output"
+}
+
+func alu() *ALU {
+	m := &ALU{}
+	Init(m)
+	return m
+}
+
+func parity(x uint8) bool {
+	p := false
+	for ; x != 0; x >>= 1 {
+		p = p != (x&1 == 1)
+	}
+	return p
+}
+
+func init() {
+	Register("parity", parity)
+}
+
+func TestAlways(t *testing.T) {
+	m := alu()
+	m.A, m.B, m.S, m.Sel = 0xf0, 0x0c, -8, true
+	for _, tc := range []struct {
+		recv, x string
+		want    interface{}
+	}{
+		{"Out", "A + B", uint8(0xfc)},
+		{"Out", "A - B", uint8(0xe4)},
+		{"Out", "A * 2", uint8(0xe0)},
+		{"Out", "A / B", uint8(20)},
+		{"Out", "A % B", uint8(0)},
+		{"Out", "A / (B - 12)", uint8(0)},
+		{"Out", "A & B | 1", uint8(1)},
+		{"Out", "A ^ 0xff", uint8(0x0f)},
+		{"Out", "A &^ 0b1010_0000", uint8(0x50)},
+		{"Out", "^A", uint8(0x0f)},
+		{"Out", "-B", uint8(0xf4)},
+		{"Out", "+B", uint8(0x0c)},
+		{"Out", "B << 4 >> 2", uint8(0x30)},
+		{"Out", "A + B<<1", uint8(0x08)}, // Go precedence
+		{"Out", "(A + B) << 1", uint8(0xf8)},
+		{"Out", "0o17 + 'a'", uint8(0x70)},
+		{"SOut", "S >> 1", int16(-4)},
+		{"SOut", "S * S", int16(64)},
+		{"SOut", "S / 3", int16(-2)},
+		{"SOut", "S + A", int16(0xe8)}, // unsigned, as in Verilog
+		{"SOut", "S + SOut*0 + A", int16(0xe8)},
+		{"SOut", "S % 0", int16(0)},
+		{"Flag", "A > B && Sel", true},
+		{"Flag", "S < 0 || !Sel", true},
+		{"Flag", "A == B", false},
+		{"Flag", "A != B", true},
+		{"Flag", "S >= -8", true},
+		{"Flag", "A <= 0xf0 && B < 13", true},
+		{"Flag", "Sel ^ true", false},
+		{"Flag", "^Sel | Sel &^ false", true},
+		{"Flag", "1 << 3 == 8", true},
+		{"Parity", "parity(A | B)", false},
+		{"Parity", "parity(B + 1)", true},
+	} {
+		if err := m.Always(tc.recv, tc.x); err != nil {
+			t.Fatal(tc.x, err)
+		}
+		n := m.Values[tc.recv]
+		if got := n.Update().Interface(); got != tc.want {
+			t.Fatalf("%s = %v, want %v", tc.x, got, tc.want)
+		}
+		// the expression tree computes the same values
+		if n.Expr == nil {
+			continue
+		}
+		if got, err := Convert(n.Expr.Eval(), n.T); err != nil || got.Interface() != tc.want {
+			t.Fatalf("%s = %v, want %v", expr.Format(n.Expr), got, tc.want)
+		}
+	}
+}
+
+func TestAlwaysExpr(t *testing.T) {
+	m := alu()
+	m.Always(`Out`, `(A + B) & ^B`)
+	if got := expr.Format(m.Values["Out"].Expr); got != "A + B & ~B" {
+		t.Fatal(got)
+	}
+	m.Always(`SOut`, `S >> 2`)
+	if got := expr.Format(m.Values["SOut"].Expr); got != "16'(S >>> 2)" {
+		t.Fatal(got)
+	}
+	// calls have no Verilog form
+	m.Always(`Parity`, `parity(A)`)
+	if m.Values["Parity"].Expr != nil {
+		t.Fatal(expr.Format(m.Values["Parity"].Expr))
+	}
+	// deps are connected once
+	if len(m.Values["Out"].Listen) != 2 {
+		t.Fatal(m.Values["Out"].Listen)
+	}
+}
+
+func TestAlwaysErrors(t *testing.T) {
+	m := alu()
+	for _, tc := range []struct {
+		x   string
+		col int
+	}{
+		{"A[0]", 1},
+		{"A + \"s\"", 5},
+		{"A + C", 5},
+		{"Sel && 1", 8},
+		{"A && B", 3},
+		{"Sel + Sel", 5},
+		{"A + 256", 5},
+		{"1 / 0", 5},
+		{"A + 1.5", 5},
+		{"foo(A)", 1},
+		{"parity(A, B)", 7},
+		{"parity(Sel)", 8},
+		{"A.B", 1},
+		{"A +", 4},
+		{"-Sel", 1},
+		{"!A", 1},
+	} {
+		err := m.Always("Out", tc.x)
+		var perr *expr.ParseError
+		if !errors.As(err, &perr) {
+			t.Fatal(tc.x, err)
+		}
+		if perr.Col != tc.col {
+			t.Fatalf("%s: %v at %d, want %d", tc.x, perr, perr.Col, tc.col)
+		}
+	}
+	if err := m.Always("Flag", "A"); err == nil {
+		t.Fatal("assigned uint8 to bool")
+	}
+	if err := m.Always("C", "A"); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal(err)
+	}
+	if err := m.Always("Out", "A", Pos("Clk")); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal(err)
+	}
+}
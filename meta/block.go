@@ -0,0 +1,376 @@
+package meta
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+// block is an always block being assembled or run.
+type block struct {
+	blocking bool
+	recvs    []*Node // in the order they are first assigned
+	exec     func()
+
+	// vals holds the values assigned by the current run
+	vals map[*Node]reflect.Value
+
+	// sym holds the expressions assigned to the signals so far and written
+	// the names of those assigned by the branch being assembled
+	sym     map[string]expr.Expr
+	written map[string]bool
+}
+
+// reader returns the function reading n from a statement. Assignments are
+// seen by the next statements only if they are blocking.
+func (b *block) reader(n *Node) UpdateFunc {
+	if !b.blocking {
+		return func() reflect.Value { return n.V }
+	}
+	return func() reflect.Value {
+		if v, ok := b.vals[n]; ok {
+			return v
+		}
+		return n.V
+	}
+}
+
+// update returns the update function of n, which runs the whole block. The
+// signals it does not assign keep their values.
+func (b *block) update(n *Node) UpdateFunc {
+	return func() reflect.Value {
+		b.vals = make(map[*Node]reflect.Value)
+		b.exec()
+		if v, ok := b.vals[n]; ok {
+			return v
+		}
+		return n.V
+	}
+}
+
+// AlwaysBlock makes the signals assigned by the Go statements of src compute
+// them, as a SystemVerilog always block. Each signal assigned keeps its
+// value on the paths which do not assign it.
+//
+// Statements are assignments to signals, including op= assignments and
+// ++ and --, if/else and switch. Expressions are those of Always.
+//
+// If any signal triggers on a clock edge, src is an always_ff block: the
+// assignments are non-blocking, so all expressions read the values the
+// signals had before the edge, and the signals assigned are updated at once.
+// Otherwise src is an always_comb block, which runs whenever any signal it
+// reads changes, and assignments are blocking: the next statements read the
+// values assigned.
+//
+// An invalid block returns an *expr.ParseError, with its column, wrapped in
+// an error giving its line.
+func (m *Mod) AlwaysBlock(src string, signals ...Signal) error {
+	blocking := true
+	for _, signal := range signals {
+		if _, ok := m.Values[signal.Name]; !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidIdentifier, signal.Name)
+		}
+		switch signal.Edge() {
+		case Posedge, Negedge:
+			blocking = false
+		}
+	}
+
+	// wrap src in a function literal, starting on its second line
+	fset := token.NewFileSet()
+	e, err := parser.ParseExprFrom(fset, "", "func() {\n"+src+"\n}", 0)
+	if err != nil {
+		return blockError(fset, err)
+	}
+	body := e.(*ast.FuncLit).Body
+	a := newAssembler(m)
+	b := &block{
+		blocking: blocking,
+		sym:      make(map[string]expr.Expr),
+		written:  make(map[string]bool),
+	}
+	a.b = b
+	if b.exec, err = a.stmts(body.List); err != nil {
+		return blockError(fset, err)
+	}
+
+	recvs := make(map[string]bool)
+	for _, n := range b.recvs {
+		n.Update = b.update(n)
+		n.Expr = b.sym[n.Name]
+		recvs[n.Name] = true
+	}
+
+	// as in Always, signals read are data dependencies of a clocked block
+	// and trigger a combinational one, unless they are assigned by it
+	var deps []Signal
+	for _, dep := range a.deps {
+		if len(signals) > 0 || recvs[dep.Name] {
+			dep.Sensivity = Noedge
+		}
+		deps = append(deps, dep)
+	}
+	for _, n := range b.recvs {
+		for _, signal := range signals {
+			sens := signal.Sensivity
+			if !blocking && sens.Edge() != Noedge {
+				sens |= Block // updated together
+			}
+			Connect(m.Values[signal.Name], n, sens)
+		}
+		for _, dep := range deps {
+			Connect(m.Values[dep.Name], n, dep.Sensivity)
+		}
+	}
+	return nil
+}
+
+// blockError converts err, found in a block parsed into fset, to an
+// *expr.ParseError wrapped with the line of the block.
+func blockError(fset *token.FileSet, err error) error {
+	pos, msg, ok := position(fset, err)
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("line %d: %w", pos.Line-1, &expr.ParseError{Col: pos.Column, Msg: msg})
+}
+
+func (a *assembler) stmts(list []ast.Stmt) (func(), error) {
+	execs := make([]func(), 0, len(list))
+	for _, s := range list {
+		exec, err := a.stmt(s)
+		if err != nil {
+			return nil, err
+		}
+		execs = append(execs, exec)
+	}
+	return func() {
+		for _, exec := range execs {
+			exec()
+		}
+	}, nil
+}
+
+func (a *assembler) stmt(s ast.Stmt) (func(), error) {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		return a.stmts(s.List)
+	case *ast.EmptyStmt:
+		return func() {}, nil
+	case *ast.AssignStmt:
+		return a.assign(s)
+	case *ast.IncDecStmt:
+		op := token.ADD_ASSIGN
+		if s.Tok == token.DEC {
+			op = token.SUB_ASSIGN
+		}
+		one := &ast.BasicLit{ValuePos: s.TokPos, Kind: token.INT, Value: "1"}
+		return a.assign(&ast.AssignStmt{Lhs: []ast.Expr{s.X}, TokPos: s.TokPos, Tok: op, Rhs: []ast.Expr{one}})
+	case *ast.IfStmt:
+		return a.ifStmt(s)
+	case *ast.SwitchStmt:
+		return a.switchStmt(s)
+	}
+	return nil, unsupported(s)
+}
+
+// assign assembles an assignment. All expressions are evaluated before any
+// signal is assigned, as in Go.
+func (a *assembler) assign(s *ast.AssignStmt) (func(), error) {
+	if s.Tok == token.DEFINE {
+		return nil, errorf(s.TokPos, "cannot declare variables in a block")
+	}
+	if len(s.Lhs) != len(s.Rhs) {
+		return nil, errorf(s.TokPos, "assignment mismatch: %d signals but %d values", len(s.Lhs), len(s.Rhs))
+	}
+
+	recvs := make([]*Node, len(s.Lhs))
+	updates := make([]UpdateFunc, len(s.Lhs))
+	xs := make([]expr.Expr, len(s.Lhs))
+	for i, lhs := range s.Lhs {
+		name, ok := signalName(lhs)
+		if !ok {
+			return nil, errorf(lhs.Pos(), "cannot assign to %s", types.ExprString(lhs))
+		}
+		n, ok := a.m.Values[name]
+		if !ok {
+			return nil, errorf(lhs.Pos(), "undefined: %s", name)
+		}
+		for _, in := range a.m.Inputs {
+			if in == n {
+				return nil, errorf(lhs.Pos(), "cannot assign to input %s", name)
+			}
+		}
+
+		rhs := s.Rhs[i]
+		if s.Tok != token.ASSIGN {
+			// x op= y is x = x op y
+			op := s.Tok - token.ADD_ASSIGN + token.ADD
+			rhs = &ast.BinaryExpr{X: lhs, OpPos: s.TokPos, Op: op, Y: rhs}
+		}
+		op, err := a.assemble(rhs)
+		if err != nil {
+			return nil, err
+		}
+		if updates[i], xs[i], err = op.convert(n.T, rhs.Pos()); err != nil {
+			return nil, err
+		}
+		recvs[i] = n
+	}
+
+	b := a.b
+	for i, n := range recvs {
+		if !b.assigned(n) {
+			b.recvs = append(b.recvs, n)
+		}
+		b.sym[n.Name] = xs[i]
+		b.written[n.Name] = true
+	}
+	return func() {
+		values := make([]reflect.Value, len(updates))
+		for i, update := range updates {
+			values[i] = update()
+		}
+		for i, n := range recvs {
+			b.vals[n] = values[i]
+		}
+	}, nil
+}
+
+// assigned reports whether n is assigned by any statement assembled so far.
+func (b *block) assigned(n *Node) bool {
+	for _, recv := range b.recvs {
+		if recv == n {
+			return true
+		}
+	}
+	return false
+}
+
+// branch assembles a branch of a conditional statement, returning the
+// expressions of the signals at its end and the signals it assigns.
+func (a *assembler) branch(s ast.Stmt) (func(), map[string]expr.Expr, map[string]bool, error) {
+	b := a.b
+	sym, written := b.sym, b.written
+	defer func() { b.sym, b.written = sym, written }()
+
+	b.sym = make(map[string]expr.Expr, len(sym))
+	for name, x := range sym {
+		b.sym[name] = x
+	}
+	b.written = make(map[string]bool)
+	if s == nil {
+		return func() {}, b.sym, b.written, nil
+	}
+	exec, err := a.stmt(s)
+	return exec, b.sym, b.written, err
+}
+
+func (a *assembler) ifStmt(s *ast.IfStmt) (func(), error) {
+	if s.Init != nil {
+		return nil, unsupported(s.Init)
+	}
+	cond, err := a.assemble(s.Cond)
+	if err != nil {
+		return nil, err
+	}
+	if cond.untyped() || cond.t.Kind() != reflect.Bool {
+		return nil, errorf(s.Cond.Pos(), "non-boolean condition in if statement")
+	}
+	then, thenSym, thenWritten, err := a.branch(s.Body)
+	if err != nil {
+		return nil, err
+	}
+	els, elseSym, elseWritten, err := a.branch(s.Else)
+	if err != nil {
+		return nil, err
+	}
+
+	// a signal assigned by either branch is a multiplexer of both
+	for name := range elseWritten {
+		thenWritten[name] = true
+	}
+	for name := range thenWritten {
+		a.b.written[name] = true
+		a.b.sym[name] = mux(cond.x, a.symOf(thenSym, name), a.symOf(elseSym, name))
+	}
+
+	eval := cond.eval
+	return func() {
+		if eval().Bool() {
+			then()
+		} else {
+			els()
+		}
+	}, nil
+}
+
+// symOf returns the expression of the signal name in sym, or the signal
+// itself if it is not assigned.
+func (a *assembler) symOf(sym map[string]expr.Expr, name string) expr.Expr {
+	if x, ok := sym[name]; ok {
+		return x
+	}
+	x, _ := a.env.Lookup(name)
+	return convertX(x, a.m.Values[name].T)
+}
+
+func mux(cond, x, y expr.Expr) expr.Expr {
+	if cond == nil || x == nil || y == nil {
+		return nil
+	}
+	return &expr.IfExpr{Cond: cond, If: x, Else: y}
+}
+
+// switchStmt assembles a switch statement as the chain of if statements
+// testing its cases in order.
+func (a *assembler) switchStmt(s *ast.SwitchStmt) (func(), error) {
+	if s.Init != nil {
+		return nil, unsupported(s.Init)
+	}
+	var chain ast.Stmt
+	for i := len(s.Body.List) - 1; i >= 0; i-- {
+		cc := s.Body.List[i].(*ast.CaseClause)
+		if cc.List == nil {
+			continue
+		}
+		var cond ast.Expr
+		for _, x := range cc.List {
+			if s.Tag != nil {
+				x = &ast.BinaryExpr{X: s.Tag, OpPos: x.Pos(), Op: token.EQL, Y: x}
+			}
+			if cond == nil {
+				cond = x
+			} else {
+				cond = &ast.BinaryExpr{X: cond, OpPos: x.Pos(), Op: token.LOR, Y: x}
+			}
+		}
+		body := &ast.BlockStmt{Lbrace: cc.Colon, List: cc.Body}
+		if chain == nil {
+			chain = a.defaultClause(s)
+		}
+		chain = &ast.IfStmt{If: cc.Case, Cond: cond, Body: body, Else: chain}
+	}
+	if chain == nil {
+		chain = a.defaultClause(s)
+	}
+	if chain == nil {
+		return func() {}, nil
+	}
+	return a.stmt(chain)
+}
+
+// defaultClause returns the body of the default clause of s, if any.
+func (a *assembler) defaultClause(s *ast.SwitchStmt) ast.Stmt {
+	for _, stmt := range s.Body.List {
+		if cc := stmt.(*ast.CaseClause); cc.List == nil {
+			return &ast.BlockStmt{Lbrace: cc.Colon, List: cc.Body}
+		}
+	}
+	return nil
+}
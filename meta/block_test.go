@@ -0,0 +1,161 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+type Ctrl struct {
+	Mod
+
+	Clk, Rst, En bool  "input"
+	Op           uint8 "input"
+	Count        uint8 "output"
+	Wrap         bool  "output"
+	X, Y         uint8 "output"
+}
+
+func ctrl() *Ctrl {
+	m := &Ctrl{}
+	Init(m)
+	return m
+}
+
+func TestAlwaysBlock(t *testing.T) {
+	m := ctrl()
+	err := m.AlwaysBlock(`
+		if Rst {
+			Count = 0
+			Wrap = false
+		} else if En {
+			Count++
+			Wrap = Count == 0xff
+		}`, Pos("Clk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, wrap := m.Values["Count"], m.Values["Wrap"]
+
+	// registers keep their values unless assigned
+	m.Count = 0xff
+	if v := count.Update().Uint(); v != 0xff {
+		t.Fatal(v)
+	}
+	m.En = true
+	// non-blocking: Wrap reads Count before the edge
+	if v, w := count.Update().Uint(), wrap.Update().Bool(); v != 0 || !w {
+		t.Fatal(v, w)
+	}
+	m.Rst = true
+	if v, w := count.Update().Uint(), wrap.Update().Bool(); v != 0 || w {
+		t.Fatal(v, w)
+	}
+
+	if got := expr.Format(count.Expr); got != "Rst ? 8'd0 : En ? Count + 8'd1 : Count" {
+		t.Fatal(got)
+	}
+	for _, e := range count.Listen {
+		if e.From.Name == "Clk" && e.Sensivity != Posedge|Block {
+			t.Fatal(e.Sensivity)
+		}
+		if e.From.Name != "Clk" && e.Sensivity != Noedge {
+			t.Fatal(e.From.Name, e.Sensivity)
+		}
+	}
+}
+
+func TestAlwaysBlockSwap(t *testing.T) {
+	m := ctrl()
+	if err := m.AlwaysBlock(`X = Y; Y = X`, Pos("Clk")); err != nil {
+		t.Fatal(err)
+	}
+	m.X, m.Y = 1, 2
+	if x, y := m.Values["X"].Update().Uint(), m.Values["Y"].Update().Uint(); x != 2 || y != 1 {
+		t.Fatal(x, y)
+	}
+
+	// blocking assignments are seen by the next statements
+	m = ctrl()
+	if err := m.AlwaysBlock(`X = Y; Y = X`); err != nil {
+		t.Fatal(err)
+	}
+	m.X, m.Y = 1, 2
+	if x, y := m.Values["X"].Update().Uint(), m.Values["Y"].Update().Uint(); x != 2 || y != 2 {
+		t.Fatal(x, y)
+	}
+}
+
+func TestAlwaysBlockComb(t *testing.T) {
+	m := ctrl()
+	err := m.AlwaysBlock(`
+		X = 0
+		switch Op {
+		case 0:
+			X = Count
+		case 1, 2:
+			X = Count << Op
+		default:
+			X = ^Count
+		}
+		Y = X + 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := m.Values["X"], m.Values["Y"]
+	m.Count = 3
+	for op, want := range []uint64{3, 6, 12, 0xfc} {
+		m.Op = uint8(op)
+		if v, w := x.Update().Uint(), y.Update().Uint(); v != want || w != want+1&0xff {
+			t.Fatal(op, v, w)
+		}
+	}
+	if got := expr.Format(y.Expr); got != "(Op == 8'd0 ? Count : Op == 8'd1 || Op == 8'd2 ? Count << Op : ~Count) + 8'd1" {
+		t.Fatal(got)
+	}
+
+	// signals read trigger the block, except those it assigns
+	var from []string
+	for _, e := range y.Listen {
+		if e.Sensivity == Anyedge {
+			from = append(from, e.From.Name)
+		}
+	}
+	if len(from) != 2 || from[0] != "Op" || from[1] != "Count" {
+		t.Fatal(from)
+	}
+}
+
+func TestAlwaysBlockErrors(t *testing.T) {
+	m := ctrl()
+	for _, tc := range []struct {
+		src       string
+		line, col int
+	}{
+		{"X = 1\nY = Z", 2, 5},
+		{"X = 1\n  Op = 2", 2, 3},
+		{"X := 1", 1, 3},
+		{"X, Y = 1", 1, 6},
+		{"if X { Y = 1 }", 1, 4},
+		{"for {}", 1, 1},
+		{"X = 1\nif Rst {\n\tY = true\n}", 3, 6},
+		{"switch Op { case 1: fallthrough\ndefault: }", 1, 21},
+		{"X = (", 2, 1},
+		{"X + 1", 1, 1},
+	} {
+		err := m.AlwaysBlock(tc.src)
+		var perr *expr.ParseError
+		if !errors.As(err, &perr) {
+			t.Fatal(tc.src, err)
+		}
+		var line int
+		if _, serr := fmt.Sscanf(err.Error(), "line %d:", &line); serr != nil || line != tc.line || perr.Col != tc.col {
+			t.Fatalf("%q: %v, want line %d col %d", tc.src, err, tc.line, tc.col)
+		}
+	}
+	if err := m.AlwaysBlock(`X = 1`, Pos("C")); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,10 @@
 package meta
 
-import "reflect"
+import (
+	"reflect"
+
+	"github.com/dakerfp/verigo/expr"
+)
 
 type Sensivity int
 
@@ -51,6 +55,37 @@ type Node struct {
 	Notify, Listen []*Edge
 	Update         UpdateFunc
 	Name           string
+	Expr           expr.Expr // expression computed by Update, if any
+	Mod            *Mod      // module declaring the node, if any
+	Driver         *Node     // node connected to this one by Mod.Wire
+	Width          uint      // bits of the signal if a width tag makes it narrower than T
+	Param          string    // parameter of Mod giving the width, if any
+}
+
+// Value returns the four-state value of n.
+func (n *Node) Value() expr.Value {
+	return narrow(ValueOf(n.V), n.Width)
+}
+
+// Fit returns v, a value assigned to n, as a value of the type of n
+// truncated to the width of n.
+func (n *Node) Fit(v reflect.Value) reflect.Value {
+	if n.Width == 0 {
+		return v
+	}
+	r, err := Convert(narrow(ValueOf(v), n.Width), n.T)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Path returns the hierarchical name of n, e.g. Mux4.ml.Out.
+func (n *Node) Path() string {
+	if n.Mod == nil {
+		return n.Name
+	}
+	return n.Mod.Path() + "." + n.Name
 }
 
 func Connect(from, to *Node, s Sensivity) {
@@ -0,0 +1,412 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+// The JSON form of a Mod holds its nodes and, recursively, its
+// submodules. Nodes are numbered across the whole design, inputs and
+// outputs first in the order of their fields, then the others by name, and
+// the edges between them are kept in the top module:
+//
+//	{"name": "And", "nodes": [
+//		{"id": 0, "name": "A", "dir": "input", "type": "bool", "value": ...},
+//		{"id": 1, "name": "B", "dir": "input", "type": "bool", "value": ...},
+//		{"id": 2, "name": "O", "dir": "output", "type": "bool", "value": ...,
+//		 "expr": ...}
+//	], "edges": [
+//		{"from": 0, "to": 2, "sensivity": "any"},
+//		{"from": 1, "to": 2, "sensivity": "any"}
+//	]}
+//
+// Parameters are listed with their values, and nodes sized by a parameter
+// name it, e.g. "param": "Width". Nodes wired by Mod.Wire name their driver
+// by its id, e.g. "driver": 0.
+//
+// Values and expressions are encoded by expr.Marshal. Update functions
+// cannot be encoded: Unmarshal rebuilds them from the expressions, reading
+// the nodes of the module named by their Vars, and from the drivers. Nodes
+// holding bools, integers or expr Values can be encoded, but not arrays nor
+// structs, and neither can the logic given by Mod.Assign or by functions
+// called from Mod.Always, which has no expression.
+type jsonMod struct {
+	Name     string      `json:"name"`
+	Instance string      `json:"instance,omitempty"`
+	Params   []jsonParam `json:"params,omitempty"`
+	Nodes    []jsonNode  `json:"nodes"`
+	Subs     []jsonMod   `json:"subs,omitempty"`
+	Edges    []jsonEdge  `json:"edges,omitempty"`
+}
+
+type jsonParam struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonNode struct {
+	ID     int             `json:"id"`
+	Name   string          `json:"name"`
+	Dir    string          `json:"dir,omitempty"`
+	Type   string          `json:"type"`
+	Width  uint            `json:"width,omitempty"`
+	Param  string          `json:"param,omitempty"`
+	Value  json.RawMessage `json:"value"`
+	Expr   json.RawMessage `json:"expr,omitempty"`
+	Driver *int            `json:"driver,omitempty"`
+}
+
+type jsonEdge struct {
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	Sensivity string `json:"sensivity"`
+}
+
+// jsonTypes are the types of the nodes which can be encoded.
+var jsonTypes = make(map[string]reflect.Type)
+
+func init() {
+	for _, v := range []interface{}{
+		false,
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		expr.T, expr.NewVector(1, 0), expr.Unknown(1),
+	} {
+		t := reflect.TypeOf(v)
+		jsonTypes[t.String()] = t
+	}
+	t := reflect.TypeOf((*expr.Value)(nil)).Elem()
+	jsonTypes[t.String()] = t
+}
+
+// Marshal returns the JSON encoding of m and its submodules. Every edge
+// and every wire must connect nodes of the design, and every node updated
+// by some logic must have an expression or a driver.
+func Marshal(m *Mod) ([]byte, error) {
+	enc := &encoder{ids: make(map[*Node]int)}
+	if err := enc.number(m); err != nil {
+		return nil, err
+	}
+	doc, err := enc.mod(m)
+	if err != nil {
+		return nil, err
+	}
+	doc.Edges, err = enc.edges()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+type encoder struct {
+	ids   map[*Node]int
+	nodes []*Node
+}
+
+// sortedNodes returns the nodes of m, inputs and outputs first, with the
+// direction of each one.
+func sortedNodes(m *Mod) ([]*Node, []string) {
+	var nodes []*Node
+	var dirs []string
+	seen := make(map[*Node]bool)
+	for _, n := range m.Inputs {
+		nodes, dirs = append(nodes, n), append(dirs, "input")
+		seen[n] = true
+	}
+	for _, n := range m.Outputs {
+		nodes, dirs = append(nodes, n), append(dirs, "output")
+		seen[n] = true
+	}
+	var names []string
+	for name, n := range m.Values {
+		if !seen[n] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nodes, dirs = append(nodes, m.Values[name]), append(dirs, "")
+	}
+	return nodes, dirs
+}
+
+// number gives their ids to the nodes of m and of its submodules, so that
+// drivers can be named before they are encoded.
+func (enc *encoder) number(m *Mod) error {
+	nodes, _ := sortedNodes(m)
+	for _, n := range nodes {
+		if m.Values[n.Name] != n {
+			return fmt.Errorf("meta: node %s of %s is not in its values", n.Name, m.Name)
+		}
+		enc.ids[n] = len(enc.nodes)
+		enc.nodes = append(enc.nodes, n)
+	}
+	for _, sub := range m.subs {
+		if err := enc.number(sub.Meta()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value returns the name of the type of n and the encoding of its value.
+func value(n *Node) (string, json.RawMessage, error) {
+	t := n.T.String()
+	if jsonTypes[t] != n.T {
+		return "", nil, fmt.Errorf("meta: cannot marshal %s of type %s", n.Name, t)
+	}
+	v, err := expr.Marshal(n.Value())
+	return t, v, err
+}
+
+func (enc *encoder) mod(m *Mod) (jsonMod, error) {
+	doc := jsonMod{Name: m.Name, Instance: m.Instance}
+	for _, p := range m.Params {
+		t, v, err := value(p)
+		if err != nil {
+			return doc, err
+		}
+		doc.Params = append(doc.Params, jsonParam{p.Name, t, v})
+	}
+	nodes, dirs := sortedNodes(m)
+	for i, n := range nodes {
+		t, v, err := value(n)
+		if err != nil {
+			return doc, err
+		}
+		jn := jsonNode{ID: enc.ids[n], Name: n.Name, Dir: dirs[i], Type: t, Width: n.Width, Param: n.Param, Value: v}
+		if n.Expr != nil {
+			if jn.Expr, err = expr.Marshal(n.Expr); err != nil {
+				return doc, err
+			}
+		}
+		if n.Update != nil && n.Expr == nil && n.Driver == nil {
+			return doc, fmt.Errorf("meta: cannot marshal the logic of %s, which has no expression", n.Name)
+		}
+		if n.Driver != nil {
+			id, ok := enc.ids[n.Driver]
+			if !ok {
+				return doc, fmt.Errorf("meta: wire from %s to %s leaves the module", n.Driver.Name, n.Name)
+			}
+			jn.Driver = &id
+		}
+		doc.Nodes = append(doc.Nodes, jn)
+	}
+	for _, sub := range m.subs {
+		jm, err := enc.mod(sub.Meta())
+		if err != nil {
+			return doc, err
+		}
+		doc.Subs = append(doc.Subs, jm)
+	}
+	return doc, nil
+}
+
+// edges returns the edges of the design in an order which keeps the
+// Notify and Listen lists of every node.
+func (enc *encoder) edges() ([]jsonEdge, error) {
+	var edges []*Edge
+	index := make(map[*Edge]int)
+	after := make(map[*Edge][]*Edge)
+	preds := make(map[*Edge]int)
+	chain := func(list []*Edge) {
+		for i, e := range list {
+			if _, ok := index[e]; !ok {
+				index[e] = len(edges)
+				edges = append(edges, e)
+			}
+			if i > 0 {
+				after[list[i-1]] = append(after[list[i-1]], e)
+				preds[e]++
+			}
+		}
+	}
+	for _, n := range enc.nodes {
+		chain(n.Listen)
+		chain(n.Notify)
+	}
+
+	var sorted []jsonEdge
+	done := make([]bool, len(edges))
+	for len(sorted) < len(edges) {
+		next := -1
+		for i, e := range edges {
+			if !done[i] && preds[e] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("meta: edges are not in the order they were connected")
+		}
+		e := edges[next]
+		done[next] = true
+		for _, succ := range after[e] {
+			preds[succ]--
+		}
+		from, ok1 := enc.ids[e.From]
+		to, ok2 := enc.ids[e.To]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("meta: edge from %s to %s leaves the module", e.From.Name, e.To.Name)
+		}
+		sorted = append(sorted, jsonEdge{from, to, e.Sensivity.String()})
+	}
+	return sorted, nil
+}
+
+// Unmarshal decodes a module encoded by Marshal. Nodes hold values of their
+// types which are not bound to any struct, and submodules are Mods.
+func Unmarshal(data []byte) (*Mod, error) {
+	var doc jsonMod
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	dec := &decoder{drivers: make(map[*Node]int)}
+	m, err := dec.mod(doc)
+	if err != nil {
+		return nil, err
+	}
+	nodes := dec.nodes
+	for _, n := range nodes {
+		id, ok := dec.drivers[n]
+		if !ok {
+			continue
+		}
+		if id < 0 || id >= len(nodes) {
+			return nil, fmt.Errorf("meta: driver %d of %s out of range", id, n.Name)
+		}
+		if err := drive(n, nodes[id]); err != nil {
+			return nil, fmt.Errorf("meta: %v", err)
+		}
+	}
+	for _, je := range doc.Edges {
+		if je.From < 0 || je.From >= len(nodes) || je.To < 0 || je.To >= len(nodes) {
+			return nil, fmt.Errorf("meta: edge from %d to %d out of range", je.From, je.To)
+		}
+		s, err := parseSensivity(je.Sensivity)
+		if err != nil {
+			return nil, err
+		}
+		Connect(nodes[je.From], nodes[je.To], s)
+	}
+	return m, nil
+}
+
+// decoder holds the nodes decoded so far, in the order of their ids, and
+// the ids of the drivers of those which are wired.
+type decoder struct {
+	nodes   []*Node
+	drivers map[*Node]int
+}
+
+func (dec *decoder) mod(doc jsonMod) (*Mod, error) {
+	m := &Mod{Name: doc.Name, Instance: doc.Instance, Values: make(map[string]*Node)}
+	for _, jp := range doc.Params {
+		t, v, err := decodeValue(jp.Type, jp.Value)
+		if err != nil {
+			return nil, fmt.Errorf("meta: param %s: %v", jp.Name, err)
+		}
+		m.Params = append(m.Params, &Node{T: t, V: v, Name: jp.Name, Mod: m})
+	}
+	for _, jn := range doc.Nodes {
+		if jn.ID != len(dec.nodes) {
+			return nil, fmt.Errorf("meta: node %s has id %d, want %d", jn.Name, jn.ID, len(dec.nodes))
+		}
+		n, err := decodeNode(jn)
+		if err != nil {
+			return nil, fmt.Errorf("meta: node %s: %v", jn.Name, err)
+		}
+		if _, ok := m.param(n.Param); n.Param != "" && !ok {
+			return nil, fmt.Errorf("meta: node %s has width %s, which is not a param of %s", n.Name, n.Param, m.Name)
+		}
+		if jn.Driver != nil {
+			dec.drivers[n] = *jn.Driver
+		}
+		n.Mod = m
+		if _, ok := m.Values[n.Name]; ok {
+			return nil, fmt.Errorf("meta: node %s of %s is repeated", n.Name, m.Name)
+		}
+		m.Values[n.Name] = n
+		switch jn.Dir {
+		case "":
+		case "input":
+			m.Inputs = append(m.Inputs, n)
+		case "output":
+			m.Outputs = append(m.Outputs, n)
+		default:
+			return nil, fmt.Errorf("meta: node %s has direction %q", jn.Name, jn.Dir)
+		}
+		dec.nodes = append(dec.nodes, n)
+	}
+	for _, n := range m.Values {
+		if n.Expr != nil {
+			m.SetExpr(n, n.Expr)
+		}
+	}
+	for _, jm := range doc.Subs {
+		sub, err := dec.mod(jm)
+		if err != nil {
+			return nil, err
+		}
+		m.Sub(sub)
+	}
+	return m, nil
+}
+
+// decodeValue returns the type named by typ and a value of it decoded from
+// data.
+func decodeValue(typ string, data json.RawMessage) (reflect.Type, reflect.Value, error) {
+	t, ok := jsonTypes[typ]
+	if !ok {
+		return nil, reflect.Value{}, fmt.Errorf("unknown type %s", typ)
+	}
+	x, err := expr.Unmarshal(data)
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+	value, ok := x.(expr.Value)
+	if !ok {
+		return nil, reflect.Value{}, fmt.Errorf("value is not constant")
+	}
+	v, err := Convert(value, t)
+	return t, v, err
+}
+
+func decodeNode(jn jsonNode) (*Node, error) {
+	t, v, err := decodeValue(jn.Type, jn.Value)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{T: t, V: v, Name: jn.Name, Width: jn.Width, Param: jn.Param}
+	if jn.Expr != nil {
+		if n.Expr, err = expr.Unmarshal(jn.Expr); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func parseSensivity(s string) (Sensivity, error) {
+	var sens Sensivity
+	if rest := strings.TrimPrefix(s, "block "); rest != s {
+		sens, s = Block, rest
+	}
+	switch s {
+	case "none":
+	case "pos":
+		sens |= Posedge
+	case "neg":
+		sens |= Negedge
+	case "any":
+		sens |= Anyedge
+	default:
+		return 0, fmt.Errorf("meta: unknown sensivity %q", s)
+	}
+	return sens, nil
+}
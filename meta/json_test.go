@@ -0,0 +1,170 @@
+package meta
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+type Counter struct {
+	Mod
+
+	Clk   bool "input"
+	Step  int8 "input"
+	Count int8 "output"
+	next  int8
+}
+
+func counter() *Counter {
+	m := &Counter{Step: -2, Count: 5}
+	Init(m)
+	m.Always(`next`, `Count + Step`)
+	m.Always(`Count`, `next`, Pos(`Clk`))
+	return m
+}
+
+func roundTrip(t *testing.T, m *Mod) *Mod {
+	t.Helper()
+	data, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err, string(data))
+	}
+	again, err := Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Fatalf("%s\n%s", data, again)
+	}
+	return d
+}
+
+func TestJSON(t *testing.T) {
+	m := counter()
+	d := roundTrip(t, m.Meta())
+	if d.Name != "Counter" || len(d.Inputs) != 2 || len(d.Outputs) != 1 || len(d.Values) != 4 {
+		t.Fatal(d)
+	}
+	if d.Inputs[1].Name != "Step" || d.Values["Step"].V.Int() != -2 {
+		t.Fatal(d.Inputs[1].Name, d.Values["Step"].V)
+	}
+
+	count := d.Values["Count"]
+	if len(count.Listen) != 2 || count.Listen[0].From.Name != "Clk" || count.Listen[0].Sensivity != Posedge ||
+		count.Listen[1].From.Name != "next" || count.Listen[1].Sensivity != Noedge {
+		t.Fatal(count.Listen)
+	}
+	if len(count.Notify) != 1 || count.Notify[0].To != d.Values["next"] {
+		t.Fatal(count.Notify)
+	}
+	if s := expr.Format(count.Expr); s != "next" {
+		t.Fatal(s)
+	}
+
+	// updates are rebuilt from the expressions
+	next := d.Values["next"]
+	if v := next.Update(); v.Int() != 3 {
+		t.Fatal(v)
+	}
+	count.V = count.Update()
+	if v := next.Update(); v.Int() != -2 {
+		t.Fatal(v)
+	}
+}
+
+func TestJSONSubmodules(t *testing.T) {
+	m := pipe()
+	d := roundTrip(t, m.Meta())
+	if len(d.subs) != 2 || d.subs[1].Meta().Name != "Consumer" || d.subs[1].Meta().Instance != "cons" ||
+		len(d.subs[0].Meta().Inputs) != 2 {
+		t.Fatal(d.subs)
+	}
+
+	// wires are rebuilt from the drivers
+	prod, cons := d.subs[0].Meta(), d.subs[1].Meta()
+	valid := cons.Values["In.Valid"]
+	if valid.Driver != prod.Values["Out.Valid"] || d.Values["Got"].Driver != cons.Values["Got"] {
+		t.Fatal(valid.Driver, d.Values["Got"].Driver)
+	}
+	prod.Values["Out.Valid"].V = reflect.ValueOf(true)
+	if v := valid.Update(); !v.Bool() {
+		t.Fatal(v)
+	}
+
+	// the muxes are assigned Go functions
+	mux := mux4()
+	Init(mux)
+	if _, err := Marshal(mux.Meta()); err == nil {
+		t.Fatal("marshaled an assigned function")
+	}
+}
+
+func TestJSONParams(t *testing.T) {
+	m := ring(6, 3)
+	d := roundTrip(t, m.Meta())
+	if len(d.Params) != 2 || d.Params[0].Name != "Width" || d.Params[1].V.Int() != 3 {
+		t.Fatal(d.Params)
+	}
+	if in := d.Values["In"]; in.Param != "Width" || in.Width != 6 {
+		t.Fatal(in.Param, in.Width)
+	}
+}
+
+func TestJSONOrder(t *testing.T) {
+	m := and()
+	a, b, o := m.Values["A"], m.Values["B"], m.Values["O"]
+	Connect(o, a, Block|Negedge)
+	Connect(b, a, Anyedge)
+	Connect(o, b, Noedge)
+
+	d := roundTrip(t, m.Meta())
+	da, do := d.Values["A"], d.Values["O"]
+	if len(da.Listen) != 2 || da.Listen[0].From != do || da.Listen[1].From.Name != "B" ||
+		da.Listen[0].Sensivity != Block|Negedge {
+		t.Fatal(da.Listen)
+	}
+	if len(do.Notify) != 2 || do.Notify[0].To != da || do.Notify[1].To.Name != "B" {
+		t.Fatal(do.Notify)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	type Pair struct {
+		Mod
+		P [2]uint8 "input"
+	}
+	m := &Pair{}
+	Init(m)
+	if _, err := Marshal(m.Meta()); err == nil {
+		t.Fatal("marshaled an array")
+	}
+
+	// Go functions cannot be encoded
+	a := alu()
+	if err := a.Always(`Parity`, `parity(A)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Marshal(a.Meta()); err == nil {
+		t.Fatal("marshaled a registered function")
+	}
+
+	for _, data := range []string{
+		`{"name": "M", "nodes": [{"id": 0, "name": "A", "type": "float64", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}]}`,
+		`{"name": "M", "nodes": [{"id": 1, "name": "A", "type": "bool", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}]}`,
+		`{"name": "M", "nodes": [{"id": 0, "name": "A", "type": "bool", "dir": "inout", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}]}`,
+		`{"name": "M", "nodes": [{"id": 0, "name": "A", "type": "bool", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}], "edges": [{"from": 0, "to": 1, "sensivity": "any"}]}`,
+		`{"name": "M", "nodes": [{"id": 0, "name": "A", "type": "bool", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}], "edges": [{"from": 0, "to": 0, "sensivity": "up"}]}`,
+		`{"name": "M", "nodes": [{"id": 0, "name": "A", "driver": 1, "type": "bool", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}]}`,
+		`{"name": "M", "nodes": [{"id": 0, "name": "A", "param": "W", "type": "bool", "value": {"root": 0, "nodes": [{"id": 0, "kind": "bool", "width": 1, "bits": "1"}]}}]}`,
+	} {
+		if _, err := Unmarshal([]byte(data)); err == nil {
+			t.Error(data)
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package meta
 
 import (
-	"errors"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
+	"math/big"
+	"math/rand"
 	"reflect"
-	"strconv"
+	"sort"
+	"strings"
+
+	"github.com/dakerfp/verigo/expr"
 )
 
 type Module interface {
@@ -16,23 +17,64 @@ type Module interface {
 }
 
 type Mod struct {
-	Name string
+	Name     string
+	Instance string // name of the field holding the module, e.g. Lanes[3]
 
+	parent  *Mod
 	subs    []Module
+	pending []func() // Wire and Assign calls made before Init
+	Params  []*Node  // fixed when the module is built, see Init
 	Values  map[string]*Node
 	Inputs  []*Node
 	Outputs []*Node
 }
 
+// direction returns the io of a field, given by a tag such as "input" or,
+// along with other keys, io:"input".
+func direction(tag reflect.StructTag) string {
+	if strings.Contains(string(tag), ":") {
+		return tag.Get("io")
+	}
+	return string(tag)
+}
+
+// Init builds the nodes of m from its fields, and those of its submodules.
+// Fields tagged "input" and "output" are ports, those tagged "interface"
+// or "flipped interface" hold bundles of ports, see initInterface, and
+// those tagged "submodule" hold submodules.
+//
+// Fields tagged "param" hold integer parameters, like those of a Verilog
+// module, which must be set before Init. The width tag of a port may name
+// one of them, e.g. width:"Width", and the expressions of Always and
+// AlwaysBlock may read them as constants, so that every instance of a
+// module type is built from the same definition.
+//
+// Unexported fields hold internal signals, which Always and AlwaysBlock may
+// read, but the simulator sets their nodes and not the fields themselves.
+//
+// Init panics with an error wrapping ErrUnsupportedType if a field holds a
+// type which TypeWidth does not support.
 func Init(m Module) {
 	meta := m.Meta()
-	// build it bottom up
-	for _, sub := range meta.subs {
-		Init(sub)
-	}
 	data := reflect.Indirect(reflect.ValueOf(m))
 	t := data.Type()
 	meta.Name = t.Name()
+	meta.Params = nil
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch direction(field.Tag) {
+		case "submodule":
+			meta.initSub(data.FieldByIndex(field.Index), field.Name)
+		case "param":
+			meta.addParam(data.FieldByIndex(field.Index), field.Name)
+		}
+	}
+	// build it bottom up, keeping the submodules already built
+	for _, sub := range meta.subs {
+		if sub.Meta().Values == nil {
+			Init(sub)
+		}
+	}
 	meta.Values = make(map[string]*Node)
 
 	for i := 0; i < t.NumField(); i++ {
@@ -42,52 +84,277 @@ func Init(m Module) {
 			continue
 		}
 
-		if string(field.Tag) == "submodule" {
-			// XXX: init automatically
-			continue
-		}
-
+		// unexported fields are read only, see above
 		v := data.FieldByIndex(field.Index)
-		t := v.Type()
-		n := &Node{T: t, V: v, Name: field.Name}
-		meta.Values[field.Name] = n
-
-		switch string(field.Tag) {
-		case "":
-			// ignore field
-		case "input":
-			meta.Inputs = append(meta.Inputs, n)
-		case "output":
-			meta.Outputs = append(meta.Outputs, n)
+		switch dir := direction(field.Tag); dir {
+		case "submodule", "param":
+			// built above
+		case "interface", "flipped interface":
+			meta.initInterface(v, field.Name, dir != "interface")
 		default:
-			panic(fmt.Errorf("io tag %q not supported in field %q", field.Tag.Get("io"), field.Name))
+			meta.addNode(v, field, field.Name, dir)
+		}
+	}
+
+	pending := meta.pending
+	meta.pending = nil
+	for _, call := range pending {
+		call()
+	}
+}
+
+// addParam records the parameter held by v, an integer.
+func (m *Mod) addParam(v reflect.Value, name string) {
+	if k := v.Kind(); !integer(k) || k == reflect.Uintptr {
+		panic(fmt.Errorf("param %s of %s: %w %v", name, m.Name, ErrUnsupportedType, v.Type()))
+	}
+	m.Params = append(m.Params, &Node{T: v.Type(), V: v, Name: name, Mod: m})
+}
+
+// param returns the parameter of m named name, if any.
+func (m *Mod) param(name string) (*Node, bool) {
+	for _, p := range m.Params {
+		if p.Name == name {
+			return p, true
 		}
+	}
+	return nil, false
+}
 
+// paramWidth returns the width of the field f given by the parameter p,
+// which must not exceed the width of its type.
+func paramWidth(f reflect.StructField, p *Node) (uint, error) {
+	w, err := TypeWidth(f.Type)
+	if err != nil {
+		return 0, err
+	}
+	n := intValue(p.V)
+	if !integer(f.Type.Kind()) || n.Sign() <= 0 || n.Cmp(big.NewInt(int64(w))) > 0 {
+		return 0, fmt.Errorf("%w %v: invalid width %s = %v", ErrUnsupportedType, f.Type, p.Name, n)
+	}
+	return uint(n.Uint64()), nil
+}
+
+// addNode builds the node of the field f, holding v, with the io dir. Its
+// width tag may name a parameter of m.
+func (m *Mod) addNode(v reflect.Value, f reflect.StructField, name, dir string) {
+	n := &Node{T: v.Type(), V: v, Name: name, Mod: m}
+	var width uint
+	var err error
+	if p, ok := m.param(widthTag(f)); ok {
+		width, err = paramWidth(f, p)
+		n.Param = p.Name
+	} else {
+		width, err = fieldWidth(f)
+	}
+	if err != nil {
+		panic(fmt.Errorf("field %s of %s: %w", name, m.Name, err))
+	}
+	if full, _ := TypeWidth(n.T); width < full {
+		n.Width = width
+	}
+	m.Values[name] = n
+
+	switch dir {
+	case "":
+		// ignore field
+	case "input":
+		m.Inputs = append(m.Inputs, n)
+	case "output":
+		m.Outputs = append(m.Outputs, n)
+	default:
+		panic(fmt.Errorf("io tag %q not supported in field %q", dir, name))
 	}
 }
 
+// initInterface builds the nodes of the interface held by v, a struct of
+// ports like those of a module, which is a bundle of signals. They are
+// named after the field holding it, e.g. Out.Valid. A flipped interface
+// swaps the directions of its ports, as the modport of the other side.
+func (m *Mod) initInterface(v reflect.Value, name string, flipped bool) {
+	t := v.Type()
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("interface %s of %s: %w %v", name, m.Name, ErrUnsupportedType, t))
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		dir := direction(f.Tag)
+		switch {
+		case dir != "input" && dir != "output":
+			panic(fmt.Errorf("port %s of interface %s has io tag %q", f.Name, name, dir))
+		case flipped && dir == "input":
+			dir = "output"
+		case flipped:
+			dir = "input"
+		}
+		m.addNode(v.Field(i), f, name+"."+f.Name, dir)
+	}
+}
+
+// initSub registers the submodules held by the field v, constructing the
+// nil ones. Slices and arrays hold a submodule in each element. Submodules
+// are usually unexported fields, which are made settable to construct them
+// and so that the simulator sets the exported fields of their signals.
+func (m *Mod) initSub(v reflect.Value, name string) {
+	v = settable(v)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			m.initSub(v.Index(i), fmt.Sprintf("%s[%d]", name, i))
+		}
+		return
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+	default:
+		v = v.Addr()
+	}
+	sub, ok := v.Interface().(Module)
+	if !ok {
+		panic(fmt.Errorf("submodule %s of type %v is not a module", name, v.Type()))
+	}
+	sub.Meta().Instance = name
+	for _, s := range m.subs {
+		if s.Meta() == sub.Meta() {
+			return
+		}
+	}
+	m.Sub(sub)
+}
+
 func (m *Mod) Meta() *Mod {
 	return m
 }
 
-func (m *Mod) assign(recv interface{}, f interface{}) {
+// Assign makes the field pointed by recv compute f, a func() T with T
+// convertible to the type of the field, which reads the fields of m but
+// never changes them. It cannot be translated to Verilog. recv is updated
+// whenever any of the signals is triggered. With no signals, f is probed
+// with random values of the bools and integers of m, which misses signals
+// compared with constants, and Assign panics with ErrNoDependency if none
+// is found. Assignments made before Init are done by it.
+func (m *Mod) Assign(recv interface{}, f interface{}, signals ...Signal) {
 	t := reflect.TypeOf(f)
-	if t.Kind() != reflect.Func {
-		panic("assign does not gets function")
+	if t.Kind() != reflect.Func || t.NumIn() > 0 || t.NumOut() != 1 {
+		panic(fmt.Errorf("assign needs a func() with a single result, got %v", t))
+	}
+	recvt := reftype(recv)
+	if !t.Out(0).ConvertibleTo(recvt) {
+		panic(fmt.Errorf("assign of %v to %v", t.Out(0), recvt))
 	}
+	if m.Values == nil {
+		m.pending = append(m.pending, func() { m.Assign(recv, f, signals...) })
+		return
+	}
+
+	n := m.fieldNode(recv)
+	fv := reflect.ValueOf(f)
+	n.Update = func() reflect.Value {
+		return fv.Call(nil)[0].Convert(n.T)
+	}
+	n.Expr = nil
+	if len(signals) > 0 {
+		for _, signal := range signals {
+			from, ok := m.Values[signal.Name]
+			if !ok {
+				panic(fmt.Errorf("%w: %s", ErrInvalidIdentifier, signal.Name))
+			}
+			Connect(from, n, signal.Sensivity)
+		}
+		return
+	}
+	deps := m.probe(n, fv)
+	if len(deps) == 0 {
+		panic(fmt.Errorf("%w for %s, list the signals it reads", ErrNoDependency, n.Name))
+	}
+	for _, dep := range deps {
+		Connect(dep, n, Anyedge)
+	}
+}
+
+// probes is the number of random evaluations made by probe.
+const probes = 64
 
-	if t.NumIn() > 0 {
-		panic("edge triggedered not yet supported") // XXX
+// probe returns the nodes of m on which the result of f depends, other
+// than recv. Only nodes holding bools and integers are probed, and they are
+// restored afterwards.
+func (m *Mod) probe(recv *Node, f reflect.Value) []*Node {
+	var names []string
+	for name := range m.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var nodes []*Node
+	for _, name := range names {
+		n := m.Values[name]
+		if k := n.T.Kind(); n != recv && n.V.CanSet() && (k == reflect.Bool || integer(k)) {
+			nodes = append(nodes, n)
+		}
 	}
 
-	if t.NumOut() != 1 {
-		panic("assign must return a single function")
+	saved := make([]reflect.Value, len(nodes))
+	for i, n := range nodes {
+		saved[i] = reflect.New(n.T).Elem()
+		saved[i].Set(n.V)
 	}
+	defer func() {
+		for i, n := range nodes {
+			n.V.Set(saved[i])
+		}
+	}()
 
-	recvt := reftype(recv)
-	rett := t.Out(0)
-	if !rett.ConvertibleTo(recvt) {
-		panic("assign to different types")
+	r := rand.New(rand.NewSource(1))
+	found := make([]bool, len(nodes))
+	for p := 0; p < probes; p++ {
+		for _, n := range nodes {
+			flip(n.V, r.Uint64())
+		}
+		want, ok := call(f)
+		if !ok {
+			continue // values f does not handle tell nothing
+		}
+		for i, n := range nodes {
+			if found[i] {
+				continue
+			}
+			mask := r.Uint64() | 1 // changes the value
+			flip(n.V, mask)
+			got, ok := call(f)
+			found[i] = !ok || !reflect.DeepEqual(got, want)
+			flip(n.V, mask)
+		}
+	}
+
+	var deps []*Node
+	for i, n := range nodes {
+		if found[i] {
+			deps = append(deps, n)
+		}
+	}
+	return deps
+}
+
+// call returns the result of f, a func() with a single result, and false
+// if f panics.
+func call(f reflect.Value) (v interface{}, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return f.Call(nil)[0].Interface(), true
+}
+
+// flip xors the bits of v, a bool or an integer, with mask.
+func flip(v reflect.Value, mask uint64) {
+	switch {
+	case v.Kind() == reflect.Bool:
+		v.SetBool(v.Bool() != (mask&1 == 1))
+	case isSigned(v.Type()):
+		v.SetInt(v.Int() ^ int64(mask))
+	default:
+		v.SetUint(v.Uint() ^ mask)
 	}
 }
 
@@ -106,103 +373,207 @@ func reftype(v interface{}) reflect.Type {
 }
 
 func (m *Mod) Sub(subs ...Module) {
+	for _, sub := range subs {
+		sub.Meta().parent = m
+	}
 	m.subs = append(m.subs, subs...)
 }
 
-func (m *Mod) Always(recv string, x string, signals ...Signal) {
-	if err := m.parseExpr(recv, signals, x); err != nil {
-		panic(err)
-	}
+// Parent returns the module of which m is a submodule, if any.
+func (m *Mod) Parent() *Mod {
+	return m.parent
 }
 
-var (
-	ErrInvalidIdentifier = errors.New("invalid identifier")
-)
+// Subs returns the submodules of m in the order they were added.
+func (m *Mod) Subs() []Module {
+	return m.subs
+}
 
-func (m *Mod) assembleExpr(e ast.Expr) (update UpdateFunc, dependsOn []Signal, err error) {
-	switch e.(type) {
-	case *ast.Ident:
-		identExpr := e.(*ast.Ident)
-		n, ok := m.Values[identExpr.Name]
-		if !ok {
-			err = ErrInvalidIdentifier
-		}
-		update = func() reflect.Value {
-			return n.V // XXX: test if closure closes in n
-		}
-		dependsOn = []Signal{
-			Signal{
-				Name:      identExpr.Name,
-				Sensivity: Anyedge,
-			},
-		}
-	case *ast.BinaryExpr:
-		binaryExpr := e.(*ast.BinaryExpr)
-		updateX, depX, errX := m.assembleExpr(binaryExpr.X)
-		if errX != nil {
-			err = errX
-			return
+// Path returns the hierarchical name of the instance m: the instance names
+// from the top module, which is named by its Instance or else by its Name,
+// joined by dots, e.g. Mux4.ml.
+func (m *Mod) Path() string {
+	if m.parent == nil {
+		if m.Instance != "" {
+			return m.Instance
 		}
-		updateY, depY, errY := m.assembleExpr(binaryExpr.Y)
-		if errY != nil {
-			err = errY
-			return
+		return m.Name
+	}
+	return m.parent.Path() + "." + m.Instance
+}
+
+// Lookup returns the node named by a path relative to m, such as
+// ml.mr.Out for the output Out of the instance mr of the instance ml, or
+// ml.Bus.Valid for the port Valid of its interface Bus.
+func (m *Mod) Lookup(path string) (*Node, bool) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		if n, ok := m.Values[strings.Join(names[i:], ".")]; ok {
+			return n, true
 		}
-		switch binaryExpr.Op {
-		case token.LAND:
-			update = func() reflect.Value {
-				x := updateX()
-				y := updateY()
-				return reflect.ValueOf(x.Bool() && y.Bool())
+		var next *Mod
+		for _, sub := range m.subs {
+			if sub.Meta().Instance == name {
+				next = sub.Meta()
+				break
 			}
-		case token.ADD:
-			update = func() reflect.Value {
-				x := updateX()
-				y := updateY()
-				return Add(x.Interface(), y.Interface())
-			}
-		default:
-			panic(binaryExpr.Op.String())
-		}
-
-		dependsOn = append(depX, depY...)
-	case *ast.BasicLit:
-		// basic lit is constant no need to update dependsOn list
-		basicLitExpr := e.(*ast.BasicLit)
-		switch basicLitExpr.Kind {
-		case token.INT:
-			var i int64
-			i, err = strconv.ParseInt(basicLitExpr.Value, 10, 64)
-			update = func() reflect.Value {
-				return reflect.ValueOf(i)
-			}
-		default:
-			panic(basicLitExpr)
 		}
-	default:
-		panic(reflect.TypeOf(e))
+		if next == nil {
+			return nil, false
+		}
+		m = next
 	}
-	return
+	return nil, false
 }
 
-func (m *Mod) parseExpr(recv string, signals []Signal, x string) (err error) {
-	var exp ast.Expr
-	exp, err = parser.ParseExpr(x)
-	if err != nil {
+// Walk calls fn for m and, depth first, for each of its submodules, in the
+// order they were added. It stops at the first error returned by fn.
+func (m *Mod) Walk(fn func(*Mod) error) error {
+	if err := fn(m); err != nil {
+		return err
+	}
+	for _, sub := range m.subs {
+		if err := sub.Meta().Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wire drives the field pointed by dst with the field pointed by src, like
+// a port connection. Both must be fields of m or of its submodules, holding
+// values of the same width. Fields holding interfaces of the same type are
+// wired port by port, each from the side which drives it: an input of m or
+// an output of a submodule. Wires made before Init are connected by it.
+func (m *Mod) Wire(dst, src interface{}) {
+	if m.Values == nil {
+		m.pending = append(m.pending, func() { m.Wire(dst, src) })
+		return
+	}
+	t := reftype(dst)
+	if t.Kind() == reflect.Struct && m.findNode(t, reflect.ValueOf(dst).Pointer()) == nil {
+		m.wireInterface(dst, src)
 		return
 	}
-	recvN := m.Values[recv]
-	update, deps, err := m.assembleExpr(exp)
-	recvN.Update = update
+	m.wire(m.fieldNode(dst), m.fieldNode(src))
+}
+
+func (m *Mod) wire(to, from *Node) {
+	if err := drive(to, from); err != nil {
+		panic(err)
+	}
+	Connect(from, to, Anyedge)
+}
 
-	// if there is any explicit signal, use it
-	// otherwise, use deps as if it is combinational
-	if len(signals) == 0 {
-		signals = deps
+// drive makes from the driver of to, whose Update copies the value of from.
+func drive(to, from *Node) error {
+	if to.Update != nil {
+		return fmt.Errorf("wire to %s, which is already driven", to.Name)
 	}
-	for _, signal := range signals {
-		n := m.Values[signal.Name]
-		Connect(n, recvN, signal.Sensivity)
+	same := to.T == from.T && to.Width == from.Width
+	if !same && to.Value().Width() != from.Value().Width() {
+		return fmt.Errorf("wire from %s of type %v to %s of type %v", from.Name, from.T, to.Name, to.T)
+	}
+	to.Driver = from
+	to.Update = func() reflect.Value {
+		if same {
+			return from.V
+		}
+		v, err := Convert(from.Value(), to.T)
+		if err != nil {
+			panic(err)
+		}
+		return v
+	}
+	return nil
+}
+
+// wireInterface wires the ports of the interfaces pointed by a and b.
+func (m *Mod) wireInterface(a, b interface{}) {
+	t := reftype(a)
+	if reftype(b) != t {
+		panic(fmt.Errorf("wire of interface %v to %v", reftype(b), t))
+	}
+	pa, pb := reflect.ValueOf(a).Pointer(), reflect.ValueOf(b).Pointer()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		na, nb := m.findNode(f.Type, pa+f.Offset), m.findNode(f.Type, pb+f.Offset)
+		if na == nil || nb == nil {
+			panic(fmt.Errorf("wire element of type %v is not an interface of %s", t, m.Name))
+		}
+		switch da, db := m.drives(na), m.drives(nb); {
+		case db && !da:
+			m.wire(na, nb)
+		case da && !db:
+			m.wire(nb, na)
+		default:
+			panic(fmt.Errorf("wire of %s and %s, which cannot drive one another", na.Path(), nb.Path()))
+		}
+	}
+}
+
+// drives reports whether n drives the nodes wired to it in m, being an
+// input of m or an output of a submodule.
+func (m *Mod) drives(n *Node) bool {
+	ports := n.Mod.Outputs
+	if n.Mod == m {
+		ports = m.Inputs
+	}
+	for _, p := range ports {
+		if p == n {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldNode returns the node of m or of its submodules bound to the field
+// pointed by ptr.
+func (m *Mod) fieldNode(ptr interface{}) *Node {
+	t := reftype(ptr)
+	n := m.findNode(t, reflect.ValueOf(ptr).Pointer())
+	if n == nil {
+		panic(fmt.Errorf("wire element of type %v is not a field of %s", t, m.Name))
+	}
+	return n
+}
+
+func (m *Mod) findNode(t reflect.Type, addr uintptr) *Node {
+	for _, n := range m.Values {
+		if n.T == t && n.V.CanAddr() && n.V.Addr().Pointer() == addr {
+			return n
+		}
+	}
+	for _, sub := range m.subs {
+		if n := sub.Meta().findNode(t, addr); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// SetExpr makes e the expression computed by n. The Update of n evaluates
+// e with the current values of the nodes of m named by its Vars, and
+// converts the result to the type of n.
+func (m *Mod) SetExpr(n *Node, e expr.Expr) {
+	var vars []*expr.Var
+	expr.Walk(e, func(x expr.Expr, _ []expr.Expr) error {
+		if v, ok := x.(*expr.Var); ok {
+			vars = append(vars, v)
+		}
+		return nil
+	})
+	n.Expr = e
+	n.Update = func() reflect.Value {
+		for _, v := range vars {
+			if src, ok := m.Values[v.Name]; ok {
+				v.Value = src.Value()
+			}
+		}
+		r, err := Convert(e.Eval(), n.T)
+		if err != nil {
+			panic(err)
+		}
+		return r
 	}
-	return err
 }
@@ -1,7 +1,10 @@
 package meta
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/dakerfp/verigo/expr"
 )
 
 // module mux2
@@ -22,7 +25,7 @@ type Mux2 struct {
 func mux2() *Mux2 {
 	m := &Mux2{}
 
-	m.assign(&m.Out, func() bool {
+	m.Assign(&m.Out, func() bool {
 		if True(m.Sel) {
 			return m.B
 		}
@@ -55,6 +58,103 @@ func TestMux2(t *testing.T) {
 	if len(meta.Outputs) != 1 {
 		t.Fail()
 	}
+
+	// the dependencies are found by probing, which keeps the values
+	out := meta.Values["Out"]
+	if len(out.Listen) != 3 || mux.A || !mux.B || !mux.Sel {
+		t.Fatal(out.Listen, mux.A, mux.B, mux.Sel)
+	}
+	for i, name := range []string{"A", "B", "Sel"} {
+		if e := out.Listen[i]; e.From.Name != name || e.Sensivity != Anyedge {
+			t.Fatal(e.From.Name, e.Sensivity)
+		}
+	}
+	if !out.Update().Bool() {
+		t.Fatal(mux.Out)
+	}
+	mux.Sel = false
+	if out.Update().Bool() {
+		t.Fatal(mux.Out)
+	}
+}
+
+type Cmp struct {
+	Mod
+
+	A, B uint8 "input"
+	Mode bool  "input"
+	Eq   bool  "output"
+	Out  int   "output"
+}
+
+func TestAssign(t *testing.T) {
+	m := &Cmp{}
+	Init(m)
+	m.Assign(&m.Out, m.output)
+	// equality with a constant is missed by probing
+	m.Assign(&m.Eq, func() bool { return m.A == 42 }, Signal{"A", Anyedge})
+
+	var deps []string
+	for _, e := range m.Values["Out"].Listen {
+		deps = append(deps, e.From.Name)
+	}
+	if len(deps) != 2 || deps[0] != "A" || deps[1] != "B" {
+		t.Fatal(deps)
+	}
+	if e := m.Values["Eq"].Listen; len(e) != 1 || e[0].From.Name != "A" {
+		t.Fatal(e)
+	}
+
+	m.A, m.B = 42, 3
+	if v := m.Values["Out"].Update().Int(); v != 5 {
+		t.Fatal(v)
+	}
+	if !m.Values["Eq"].Update().Bool() {
+		t.Fatal(m.A)
+	}
+	if m.Values["Out"].Expr != nil {
+		t.Fatal(m.Values["Out"].Expr)
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrNoDependency) {
+			t.Fatal(err)
+		}
+	}()
+	m.Assign(&m.Eq, func() bool { return m.Out == 1<<40 })
+}
+
+func TestAssignPanic(t *testing.T) {
+	m := &Cmp{}
+	Init(m)
+	m.A, m.B, m.Mode = 1, 2, true
+	// probing survives the values f rejects and leaves the signals alone
+	m.Assign(&m.Out, func() int {
+		if !m.Mode {
+			panic("mode not set")
+		}
+		return int(m.A / m.B)
+	})
+
+	var deps []string
+	for _, e := range m.Values["Out"].Listen {
+		deps = append(deps, e.From.Name)
+	}
+	if len(deps) != 3 || deps[0] != "A" || deps[1] != "B" || deps[2] != "Mode" {
+		t.Fatal(deps)
+	}
+	if m.A != 1 || m.B != 2 || !m.Mode {
+		t.Fatal(m.A, m.B, m.Mode)
+	}
+}
+
+// output counts the bits set in A and B.
+func (m *Cmp) output() uint8 {
+	var n uint8
+	for x := uint16(m.A)<<8 | uint16(m.B); x != 0; x >>= 1 {
+		n += uint8(x & 1)
+	}
+	return n
 }
 
 // module mux4
@@ -83,11 +183,17 @@ func mux4() *Mux4 {
 	m.ml = mux2()
 	m.mr = mux2()
 	m.mo = mux2()
-	m.Sub(m.ml, m.mr, m.mo) // XXX: use struct tag
 
-	// m.Wire(&m.ml.A, &m.A)
-	// m.Wire(&m.ml.B, &m.B)
-	// m.Wire(&m.ml.Sel, &m.Sel0)
+	m.Wire(&m.ml.A, &m.A)
+	m.Wire(&m.ml.B, &m.B)
+	m.Wire(&m.ml.Sel, &m.Sel0)
+	m.Wire(&m.mr.A, &m.C)
+	m.Wire(&m.mr.B, &m.D)
+	m.Wire(&m.mr.Sel, &m.Sel0)
+	m.Wire(&m.mo.A, &m.ml.Out)
+	m.Wire(&m.mo.B, &m.mr.Out)
+	m.Wire(&m.mo.Sel, &m.Sel1)
+	m.Wire(&m.Out, &m.mo.Out)
 
 	return m
 }
@@ -104,6 +210,142 @@ func TestMux4(t *testing.T) {
 	if len(meta.Outputs) != 1 {
 		t.Fatal()
 	}
+
+	sel0 := meta.Values["Sel0"]
+	if len(sel0.Notify) != 2 || sel0.Notify[0].To != mux.ml.Values["Sel"] || sel0.Notify[1].To != mux.mr.Values["Sel"] {
+		t.Fatal(sel0.Notify)
+	}
+	out := meta.Values["Out"]
+	if len(out.Listen) != 1 || out.Listen[0].From != mux.mo.Values["Out"] {
+		t.Fatal(out.Listen)
+	}
+
+	mux.Sel1 = true
+	if v := mux.mo.Values["Sel"].Update(); !v.Bool() {
+		t.Fatal(v)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	mux := mux4()
+	Init(mux)
+
+	n, ok := mux.Lookup("mr.Out")
+	if !ok || n != mux.mr.Values["Out"] || n.Path() != "Mux4.mr.Out" {
+		t.Fatal(n)
+	}
+	if n, ok := mux.Lookup("Sel1"); !ok || n.Path() != "Mux4.Sel1" {
+		t.Fatal(n)
+	}
+	for _, path := range []string{"mr", "mx.Out", "mr.Sel1", "mr.Out.A", ""} {
+		if _, ok := mux.Lookup(path); ok {
+			t.Error(path)
+		}
+	}
+
+	var paths []string
+	mux.Walk(func(m *Mod) error {
+		paths = append(paths, m.Path())
+		return nil
+	})
+	if len(paths) != 4 || paths[0] != "Mux4" || paths[3] != "Mux4.mo" {
+		t.Fatal(paths)
+	}
+
+	mux.Instance = "top"
+	if p := mux.ml.Values["A"].Path(); p != "top.ml.A" {
+		t.Fatal(p)
+	}
+}
+
+type Lane struct {
+	Mod
+
+	In  bool "input"
+	Out bool "output"
+}
+
+type Lanes struct {
+	Mod
+
+	In    bool     "input"
+	Lanes [3]*Lane "submodule"
+	spare []Lane   "submodule"
+	first *Lane    "submodule"
+}
+
+func TestSubmodules(t *testing.T) {
+	m := &Lanes{spare: make([]Lane, 2)}
+	m.Lanes[1] = &Lane{}
+	m.Sub(m.Lanes[1])
+	Init(m)
+
+	want := []string{"Lanes[1]", "Lanes[0]", "Lanes[2]", "spare[0]", "spare[1]", "first"}
+	if len(m.subs) != len(want) {
+		t.Fatal(len(m.subs))
+	}
+	for i, sub := range m.subs {
+		sm := sub.Meta()
+		if sm.Instance != want[i] || sm.Name != "Lane" || len(sm.Inputs) != 1 {
+			t.Fatal(i, sm.Instance, sm.Name)
+		}
+	}
+	if m.subs[3].Meta() != &m.spare[0].Mod || m.subs[5] != m.first {
+		t.Fatal("submodules are not the fields")
+	}
+	m.Wire(&m.Lanes[2].In, &m.In)
+	if in := m.Values["In"]; len(in.Notify) != 1 || in.Notify[0].To != m.Lanes[2].Values["In"] {
+		t.Fatal(in.Notify)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	type Bad struct {
+		Mod
+
+		sub *int "submodule"
+	}
+	Init(&Bad{})
+}
+
+func TestWire(t *testing.T) {
+	type Ints struct {
+		Mod
+
+		A int8  "input"
+		B uint8 "output"
+		C int16 "output"
+	}
+	m := &Ints{}
+	Init(m)
+
+	// wires made after Init are connected at once
+	m.Wire(&m.B, &m.A)
+	m.A = -1
+	if v := m.Values["B"].Update(); v.Uint() != 0xff {
+		t.Fatal(v)
+	}
+
+	for _, tc := range []struct {
+		dst, src interface{}
+	}{
+		{&m.C, &m.A},      // different widths
+		{&m.B, &m.A},      // driven twice
+		{&m.A, new(int8)}, // not a field
+		{m.A, &m.A},       // not a pointer
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("wire %T from %T: expected panic", tc.dst, tc.src)
+				}
+			}()
+			m.Wire(tc.dst, tc.src)
+		}()
+	}
 }
 
 // module And
@@ -142,6 +384,10 @@ func TestAnd(t *testing.T) {
 	if !v.Bool() {
 		t.Fatal(v)
 	}
+
+	if repr := expr.Format(sig.Expr); repr != "A && B" {
+		t.Fatal(repr)
+	}
 }
 
 // module DFF
@@ -170,3 +416,207 @@ func dff() *DFF {
 func TestDFF(t *testing.T) { // XXX: create proper test
 	_ = dff()
 }
+
+type Handshake struct {
+	Valid bool  "output"
+	Ready bool  "input"
+	Data  uint8 "output"
+}
+
+type Producer struct {
+	Mod
+
+	Next uint8     "input"
+	Out  Handshake "interface"
+}
+
+type Consumer struct {
+	Mod
+
+	In  Handshake "flipped interface"
+	Got uint8     "output"
+}
+
+type Pipe struct {
+	Mod
+
+	Next uint8    "input"
+	Got  uint8    "output"
+	prod Producer "submodule"
+	cons Consumer "submodule"
+}
+
+func pipe() *Pipe {
+	m := &Pipe{}
+	m.Wire(&m.prod.Next, &m.Next)
+	m.Wire(&m.cons.In, &m.prod.Out)
+	m.Wire(&m.Got, &m.cons.Got)
+	Init(m)
+	return m
+}
+
+func TestInterface(t *testing.T) {
+	m := pipe()
+	prod, cons := m.prod.Meta(), m.cons.Meta()
+	if len(prod.Inputs) != 2 || prod.Inputs[1].Name != "Out.Ready" || len(prod.Outputs) != 2 {
+		t.Fatal(prod.Inputs, prod.Outputs)
+	}
+	if len(cons.Inputs) != 2 || cons.Inputs[0].Name != "In.Valid" || cons.Inputs[1].Name != "In.Data" {
+		t.Fatal(cons.Inputs)
+	}
+
+	// each port is driven by the side which outputs it
+	valid, ok := m.Lookup("cons.In.Valid")
+	if !ok || valid.Driver != prod.Values["Out.Valid"] {
+		t.Fatal(valid)
+	}
+	ready, ok := m.Lookup("prod.Out.Ready")
+	if !ok || ready.Driver != cons.Values["In.Ready"] || ready.Path() != "Pipe.prod.Out.Ready" {
+		t.Fatal(ready)
+	}
+
+	// ports of interfaces are fields like any other
+	if err := prod.Always(`Out.Valid`, `Out.Data != 0 && !Out.Ready`); err != nil {
+		t.Fatal(err)
+	}
+	m.prod.Out.Data = 3
+	if !prod.Values["Out.Valid"].Update().Bool() {
+		t.Fatal(m.prod.Out)
+	}
+	if err := cons.AlwaysBlock(`if In.Valid { Got = In.Data }; In.Ready = !In.Valid`); err != nil {
+		t.Fatal(err)
+	}
+	cons.Values["In.Data"].V.SetUint(7)
+	if v := cons.Values["Got"].Update().Uint(); v != 0 {
+		t.Fatal(v)
+	}
+	m.cons.In.Valid = true
+	if v := cons.Values["Got"].Update().Uint(); v != 7 {
+		t.Fatal(v)
+	}
+}
+
+func TestInterfaceErrors(t *testing.T) {
+	type Scalar struct {
+		Mod
+		Bus uint8 "interface"
+	}
+	type Untagged struct {
+		Mod
+		Bus struct{ A bool } "interface"
+	}
+	type Twice struct {
+		Mod
+		A, B Handshake "interface"
+	}
+	for _, m := range []Module{&Scalar{}, &Untagged{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal(m)
+				}
+			}()
+			Init(m)
+		}()
+	}
+
+	// two outputs cannot be wired together
+	m := &Twice{}
+	Init(m)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("wired two outputs")
+		}
+	}()
+	m.Wire(&m.A, &m.B)
+}
+
+type Ring struct {
+	Mod
+
+	Width, Depth int    "param"
+	Clk          bool   "input"
+	In           uint64 `io:"input" width:"Width"`
+	Out          uint64 `io:"output" width:"Width"`
+	Ptr          uint8  "output"
+}
+
+func ring(width, depth int) *Ring {
+	m := &Ring{Width: width, Depth: depth}
+	Init(m)
+	if err := m.AlwaysBlock(`
+		if Ptr == Depth-1 {
+			Ptr = 0
+		} else {
+			Ptr++
+		}`, Pos("Clk")); err != nil {
+		panic(err)
+	}
+	if err := m.Always(`Out`, `In << (Width / 2)`); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestParams(t *testing.T) {
+	m := ring(6, 3)
+	if len(m.Params) != 2 || m.Params[0].Name != "Width" || m.Params[1].Name != "Depth" {
+		t.Fatal(m.Params)
+	}
+	if _, ok := m.Values["Width"]; ok {
+		t.Fatal("params are not signals")
+	}
+	in, out := m.Values["In"], m.Values["Out"]
+	if in.Width != 6 || in.Param != "Width" || out.Value().Width() != 6 {
+		t.Fatal(in.Width, in.Param)
+	}
+
+	m.In = 0x3f
+	if v := out.Fit(out.Update()).Uint(); v != 0x38 {
+		t.Fatal(v)
+	}
+	ptr := m.Values["Ptr"]
+	for _, want := range []uint64{1, 2, 0} {
+		v := ptr.Update()
+		if v.Uint() != want {
+			t.Fatal(v)
+		}
+		ptr.V.Set(v)
+	}
+	if got := expr.Format(ptr.Expr); got != "Ptr == $unsigned(8'(Depth - 1)) ? 8'd0 : Ptr + 8'd1" {
+		t.Fatal(got)
+	}
+	// params have no edges
+	if len(ptr.Listen) != 2 {
+		t.Fatal(ptr.Listen)
+	}
+
+	// another instance of the same type is specialised by its own params
+	if in := ring(12, 3).Values["In"]; in.Width != 12 {
+		t.Fatal(in.Width)
+	}
+}
+
+func TestParamErrors(t *testing.T) {
+	type Bool struct {
+		Mod
+		B bool "param"
+	}
+	type Wide struct {
+		Mod
+		W uint   "param"
+		A uint8  `io:"input" width:"W"`
+		B uint16 `io:"input" width:"Z"`
+	}
+	for _, m := range []Module{&Bool{}, &Wide{}, &Wide{W: 9}, &Wide{W: 8}} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, ErrUnsupportedType) {
+					t.Fatal(err)
+				}
+			}()
+			Init(m)
+		}()
+	}
+}
@@ -1,32 +1,282 @@
 package meta
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/dakerfp/verigo/expr"
 )
 
-type Logic uint // zero value represents X undefined
+type Logic = expr.Logic // zero value represents X undefined
 
 const (
-	X Logic = iota
-	T
-	F
-	Z
+	X = expr.LX
+	T = expr.L1
+	F = expr.L0
+	Z = expr.LZ
 )
 
+// Add sums two integers. As in Verilog, the sum is signed only if both
+// operands are signed and it has the type of the widest operand.
 func Add(a, b interface{}) reflect.Value {
 	if Width(a) < Width(b) {
 		return Add(b, a)
 	}
 
 	va := reflect.ValueOf(a)
-	switch va.Type().Kind() {
-	case reflect.Int64, reflect.Int:
-		vb := reflect.ValueOf(b)
-		return reflect.ValueOf(va.Int() + vb.Int())
-	default:
+	vb := reflect.ValueOf(b)
+	if !integer(va.Kind()) || !integer(vb.Kind()) {
 		panic(va)
 	}
-	return reflect.ValueOf(nil)
+	if Signed(a) && Signed(b) {
+		return reflect.ValueOf(va.Int() + vb.Int()).Convert(va.Type())
+	}
+	sum := reflect.ValueOf(toUint(va) + toUint(vb))
+	return sum.Convert(unsignedTypes[va.Kind()])
+}
+
+var unsignedTypes = map[reflect.Kind]reflect.Type{
+	reflect.Int:     reflect.TypeOf(uint(0)),
+	reflect.Int8:    reflect.TypeOf(uint8(0)),
+	reflect.Int16:   reflect.TypeOf(uint16(0)),
+	reflect.Int32:   reflect.TypeOf(uint32(0)),
+	reflect.Int64:   reflect.TypeOf(uint64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Uintptr: reflect.TypeOf(uintptr(0)),
+}
+
+func integer(k reflect.Kind) bool {
+	_, ok := unsignedTypes[k]
+	return ok
+}
+
+func toUint(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	}
+	return v.Uint()
+}
+
+// Signed reports whether v holds a two's complement number.
+func Signed(v interface{}) bool {
+	if ev, ok := v.(expr.Value); ok {
+		return ev.Signed()
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// ErrUnsupportedType is wrapped by the errors of types which signals
+// cannot hold.
+var ErrUnsupportedType = errors.New("unsupported type")
+
+var valueType = reflect.TypeOf((*expr.Value)(nil)).Elem()
+
+// TypeWidth returns the number of bits of a signal of type t. Signals hold
+//   - bools, of width 1,
+//   - integers, of the width of their type and signed if it is,
+//   - arrays of them, packed with the element 0 in the least significant
+//     bits, as in a SystemVerilog packed array,
+//   - structs of them, packed with the first field in the most significant
+//     bits, as in a SystemVerilog packed struct,
+//   - expr.Value types, holding four-state values of any width, for which
+//     TypeWidth returns 0.
+//
+// Arrays and structs are unsigned. A width tag, such as width:"12", makes an
+// integer field of a module or of a struct narrower than its type, and in a
+// module it may name a parameter instead, see Init. Its
+// values are truncated to that width and, if signed, sign extended from it.
+// The edges of a signal are those of its least significant bit.
+//
+// Other types return an error wrapping ErrUnsupportedType.
+func TypeWidth(t reflect.Type) (uint, error) {
+	if t == valueType || t.Implements(valueType) {
+		return 0, nil
+	}
+	switch k := t.Kind(); {
+	case k == reflect.Bool:
+		return 1, nil
+	case integer(k) && k != reflect.Uintptr:
+		return uint(t.Bits()), nil
+	case k == reflect.Array:
+		w, err := TypeWidth(t.Elem())
+		switch {
+		case err != nil:
+			return 0, fmt.Errorf("array of %w", err)
+		case w == 0 || t.Len() == 0:
+			return 0, fmt.Errorf("%w %v: arrays need fixed size elements", ErrUnsupportedType, t)
+		}
+		return w * uint(t.Len()), nil
+	case k == reflect.Struct:
+		var sum uint
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				return 0, fmt.Errorf("%w %v: field %s is unexported", ErrUnsupportedType, t, t.Field(i).Name)
+			}
+			w, err := fieldWidth(t.Field(i))
+			switch {
+			case err != nil:
+				return 0, fmt.Errorf("field %s of %w", t.Field(i).Name, err)
+			case w == 0:
+				return 0, fmt.Errorf("%w %v: structs need fixed size fields", ErrUnsupportedType, t)
+			}
+			sum += w
+		}
+		if sum == 0 {
+			return 0, fmt.Errorf("%w %v: empty struct", ErrUnsupportedType, t)
+		}
+		return sum, nil
+	}
+	return 0, fmt.Errorf("%w %v", ErrUnsupportedType, t)
+}
+
+// fieldWidth returns the number of bits of the signal held by f, which its
+// width tag may make narrower than its type.
+func fieldWidth(f reflect.StructField) (uint, error) {
+	w, err := TypeWidth(f.Type)
+	if err != nil {
+		return 0, err
+	}
+	tag := widthTag(f)
+	if tag == "" {
+		return w, nil
+	}
+	n, err := strconv.ParseUint(tag, 10, 0)
+	if err != nil || n == 0 || uint(n) > w || !integer(f.Type.Kind()) {
+		return 0, fmt.Errorf("%w %v: invalid width %q", ErrUnsupportedType, f.Type, tag)
+	}
+	return uint(n), nil
+}
+
+// widthTag returns the width tag of f, if any.
+func widthTag(f reflect.StructField) string {
+	if !strings.Contains(string(f.Tag), ":") {
+		return "" // a plain tag, such as "input"
+	}
+	return f.Tag.Get("width")
+}
+
+// narrow returns x truncated to w bits.
+func narrow(x expr.Value, w uint) expr.Value {
+	if w == 0 || w >= x.Width() {
+		return x
+	}
+	return expr.LogicOf(x).Slice(w-1, 0).WithSign(x.Signed())
+}
+
+// settable returns v, the field of an addressable struct, as a value which
+// can be set even if the field is unexported. It is only used for the
+// fields holding submodules, see initSub.
+func settable(v reflect.Value) reflect.Value {
+	if v.CanSet() {
+		return v
+	}
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+}
+
+// ValueOf returns the four-state value of a signal of a supported type.
+func ValueOf(v reflect.Value) expr.Value {
+	if v.CanInterface() {
+		if ev, ok := v.Interface().(expr.Value); ok {
+			return ev
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b := expr.Bool(v.Bool())
+		return &b
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return expr.NewSigned(uint(v.Type().Bits()), v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return expr.NewVector(uint(v.Type().Bits()), v.Uint())
+	case reflect.Array:
+		elems := make([]expr.Expr, v.Len())
+		for i := range elems {
+			elems[len(elems)-1-i] = ValueOf(v.Index(i))
+		}
+		return expr.Concat(elems...).Eval()
+	case reflect.Struct:
+		var fields []expr.Expr
+		for i := 0; i < v.NumField(); i++ {
+			w, err := fieldWidth(v.Type().Field(i))
+			if err != nil {
+				panic(err)
+			}
+			fields = append(fields, narrow(ValueOf(v.Field(i)), w))
+		}
+		return expr.Concat(fields...).Eval()
+	}
+	panic(fmt.Errorf("%v has no four-state value", v.Type()))
+}
+
+// Convert converts v to a value of type t, the inverse of ValueOf. As in
+// a Verilog assignment, signed values are sign extended and all values are
+// truncated to the width of t, so a bool takes the least significant bit.
+// X and Z bits read as 0.
+func Convert(v expr.Value, t reflect.Type) (reflect.Value, error) {
+	r := reflect.New(t).Elem()
+	x := v.Uint()
+	if w := v.Width(); v.Signed() && w < 64 && x>>(w-1)&1 != 0 {
+		x |= ^uint64(0) << w
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		r.SetBool(x&1 != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		r.SetInt(int64(x))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		r.SetUint(x)
+	case reflect.Array:
+		w, err := TypeWidth(t.Elem())
+		if err != nil {
+			return r, err
+		}
+		lv := expr.LogicOf(v)
+		for i := 0; i < t.Len(); i++ {
+			low := uint(i) * w
+			e, err := Convert(lv.Slice(low+w-1, low), t.Elem())
+			if err != nil {
+				return r, err
+			}
+			r.Index(i).Set(e)
+		}
+	case reflect.Struct:
+		off, err := TypeWidth(t)
+		if err != nil {
+			return r, err
+		}
+		lv := expr.LogicOf(v)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			w, _ := fieldWidth(f)
+			off -= w
+			bits := lv.Slice(off+w-1, off).WithSign(isSigned(f.Type))
+			e, err := Convert(bits, f.Type)
+			if err != nil {
+				return r, err
+			}
+			r.Field(i).Set(e)
+		}
+	default:
+		ev := reflect.ValueOf(v)
+		if !ev.Type().AssignableTo(t) {
+			return r, fmt.Errorf("cannot convert %T to %v", v, t)
+		}
+		r.Set(ev)
+	}
+	return r, nil
 }
 
 func True(v interface{}) bool {
@@ -43,13 +293,9 @@ func True(v interface{}) bool {
 	return false
 }
 
+// Width returns the number of bits of v, a value of a supported type.
 func Width(v interface{}) int {
-	t := reflect.TypeOf(v)
-	switch t.Kind() {
-	case reflect.Bool:
-		return 1
-	}
-	return t.Bits()
+	return int(ValueOf(reflect.ValueOf(v)).Width())
 }
 
 func Cat(values ...interface{}) []Logic {
@@ -1,7 +1,11 @@
 package meta
 
 import (
+	"errors"
+	"reflect"
 	"testing"
+
+	"github.com/dakerfp/verigo/expr"
 )
 
 func TestTrue(t *testing.T) {
@@ -34,6 +38,10 @@ func TestWidth(t *testing.T) {
 	if w := Width(int(42)); w != 64 {
 		t.Fatal(w)
 	}
+
+	if w := Width(expr.NewVector(512, 42)); w != 512 {
+		t.Fatal(w)
+	}
 }
 
 func TestCat(t *testing.T) {
@@ -49,3 +57,173 @@ func TestCat(t *testing.T) {
 		t.Fatal(l)
 	}
 }
+
+func TestAdd(t *testing.T) {
+	if v := Add(int8(-1), int64(2)); v.Kind() != reflect.Int64 || v.Int() != 1 {
+		t.Fatal(v)
+	}
+
+	// mixing signed and unsigned gives an unsigned sum
+	if v := Add(uint8(1), int8(-1)); v.Kind() != reflect.Uint8 || v.Uint() != 0 {
+		t.Fatal(v)
+	}
+	if v := Add(int16(-1), uint8(1)); v.Kind() != reflect.Uint16 || v.Uint() != 0 {
+		t.Fatal(v)
+	}
+	if v := Add(uint(1<<63), uint(1<<63)); v.Kind() != reflect.Uint || v.Uint() != 0 {
+		t.Fatal(v)
+	}
+}
+
+func TestSigned(t *testing.T) {
+	if !Signed(-1) || Signed(uint(1)) || Signed(true) {
+		t.Fatal()
+	}
+	if !Signed(expr.NewSigned(8, 1)) || Signed(expr.NewVector(8, 1)) {
+		t.Fatal()
+	}
+}
+
+type pixel struct {
+	R, G uint8
+	B    uint8 `width:"4"`
+	A    bool
+}
+
+func TestTypeWidth(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want uint
+	}{
+		{true, 1},
+		{int16(0), 16},
+		{[3]bool{}, 3},
+		{[2][3]uint8{}, 48},
+		{pixel{}, 21},
+		{[2]pixel{}, 42},
+		{expr.NewVector(9, 0), 0},
+	} {
+		w, err := TypeWidth(reflect.TypeOf(tc.v))
+		if err != nil || w != tc.want {
+			t.Fatal(reflect.TypeOf(tc.v), w, err)
+		}
+	}
+
+	type wide struct {
+		A int8 `width:"9"`
+	}
+	type dynamic struct {
+		V expr.Value
+	}
+	type hidden struct {
+		a bool
+	}
+	for _, v := range []interface{}{
+		"", 1.5, uintptr(0), []bool{}, map[int]bool{}, &struct{}{},
+		struct{}{}, [0]bool{}, [2]expr.Value{}, wide{}, dynamic{}, hidden{},
+	} {
+		if _, err := TypeWidth(reflect.TypeOf(v)); !errors.Is(err, ErrUnsupportedType) {
+			t.Fatal(reflect.TypeOf(v), err)
+		}
+	}
+
+	if w := Width(pixel{}); w != 21 {
+		t.Fatal(w)
+	}
+}
+
+func TestPacking(t *testing.T) {
+	p := pixel{R: 0x12, G: 0x34, B: 0x5, A: true}
+	if v := ValueOf(reflect.ValueOf(p)); v.Width() != 21 || v.Uint() != 0x12345<<1|1 {
+		t.Fatal(v.Width(), expr.LogicOf(v))
+	}
+	r, err := Convert(ValueOf(reflect.ValueOf(p)), reflect.TypeOf(p))
+	if err != nil || r.Interface() != p {
+		t.Fatal(r, err)
+	}
+
+	// the element 0 is the least significant
+	a := [3]int8{-1, 2, -3}
+	v := ValueOf(reflect.ValueOf(a))
+	if v.Signed() || v.Uint() != 0xfd02ff {
+		t.Fatal(expr.LogicOf(v))
+	}
+	if r, err := Convert(v, reflect.TypeOf(a)); err != nil || r.Interface() != a {
+		t.Fatal(r, err)
+	}
+
+	// a bool is the least significant bit, as in a Verilog assignment
+	for _, tc := range []struct {
+		v    expr.Value
+		want bool
+	}{
+		{expr.NewVector(2, 2), false},
+		{expr.NewVector(2, 3), true},
+		{expr.NewSigned(8, -2), false},
+		{expr.NewLogicVector(expr.L1, expr.LX), true},
+		{expr.NewLogicVector(expr.LX, expr.L1), false},
+	} {
+		if r, err := Convert(tc.v, reflect.TypeOf(false)); err != nil || r.Bool() != tc.want {
+			t.Fatal(tc.v, r, err)
+		}
+	}
+
+	// unknown bits read as 0
+	r, err = Convert(expr.Unknown(21), reflect.TypeOf(p))
+	if err != nil || r.Interface() != (pixel{}) {
+		t.Fatal(r, err)
+	}
+}
+
+type Narrow struct {
+	Mod
+
+	Addr  uint16 `io:"input" width:"12"`
+	Delta int8   `io:"output" width:"4"`
+	Bus   [2]pixel
+}
+
+func TestInitTypes(t *testing.T) {
+	m := &Narrow{Addr: 0xfabc, Delta: 0x0e}
+	Init(m)
+	addr, delta := m.Values["Addr"], m.Values["Delta"]
+	if len(m.Inputs) != 1 || m.Inputs[0] != addr || len(m.Outputs) != 1 || m.Outputs[0] != delta {
+		t.Fatal(m.Inputs, m.Outputs)
+	}
+	if addr.Width != 12 || m.Values["Bus"].Width != 0 {
+		t.Fatal(addr.Width)
+	}
+	if v := addr.Value(); v.Width() != 12 || v.Uint() != 0xabc {
+		t.Fatal(expr.LogicOf(v))
+	}
+	// narrow signed values are sign extended from their width
+	if v := delta.Value(); v.Width() != 4 || !v.Signed() {
+		t.Fatal(expr.LogicOf(v))
+	}
+	if v := delta.Fit(reflect.ValueOf(int8(0x1e))); v.Int() != -2 {
+		t.Fatal(v)
+	}
+	if v := addr.Fit(reflect.ValueOf(uint16(0x1234))); v.Uint() != 0x234 {
+		t.Fatal(v)
+	}
+
+	type Float struct {
+		Mod
+		F float64 "input"
+	}
+	type Wide struct {
+		Mod
+		W uint8 `io:"input" width:"9"`
+	}
+	for _, m := range []Module{&Float{}, &Wide{}} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, ErrUnsupportedType) {
+					t.Fatal(err)
+				}
+			}()
+			Init(m)
+		}()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/dakerfp/verigo/expr"
 	"github.com/dakerfp/verigo/meta"
 )
 
@@ -21,6 +22,10 @@ func (sig *signal) block() bool {
 type event struct {
 	sig *signal
 	ts  time.Time
+	set bool // value driven by the testbench
+
+	v reflect.Value // value set
+	x bool          // whether it is X
 }
 
 type Simulator struct {
@@ -28,16 +33,115 @@ type Simulator struct {
 	blocked   []event
 	now       time.Time
 	scheduler chan event
+
+	// unknown tracks which nodes hold X. Nodes not yet updated are
+	// unknown only if they are registers.
+	unknown map[*meta.Node]bool
+	exprs   map[*meta.Node]*copied // four-state copies of the expressions
+
+	trace   *Trace // waveform being recorded, if any
+	started bool   // whether combinational nodes were evaluated
 }
 
 func NewSimulator() *Simulator {
 	return &Simulator{
 		scheduler: make(chan event),
+		unknown:   make(map[*meta.Node]bool),
+		exprs:     make(map[*meta.Node]*copied),
+	}
+}
+
+// register reports whether n is updated on a clock edge.
+func register(n *meta.Node) bool {
+	for _, edge := range n.Listen {
+		switch edge.Sensivity.Edge() {
+		case meta.Posedge, meta.Negedge:
+			return true
+		}
+	}
+	return false
+}
+
+// Unknown reports whether n holds X. Registers start as X until they are
+// assigned from known values, either by the testbench or by their logic.
+func (sim *Simulator) Unknown(n *meta.Node) bool {
+	x, ok := sim.unknown[n]
+	if !ok {
+		return register(n)
+	}
+	return x
+}
+
+// evalUnknown tells whether an update of n produces X. The expression of n,
+// if any, is evaluated with the four-state values of the nodes it reads,
+// so that known operands mask X ones as in Verilog, e.g. an asserted reset
+// clears a register. Nodes with no expression, such as those given Go
+// functions by Mod.Assign, produce X when any other node they read from is
+// X, even where the function ignores it. Clock edges carry no data.
+func (sim *Simulator) evalUnknown(n *meta.Node) bool {
+	if n.Expr != nil && n.Mod != nil {
+		return !expr.LogicOf(sim.eval(n)).Known()
+	}
+	for _, edge := range n.Listen {
+		switch edge.Sensivity.Edge() {
+		case meta.Noedge, meta.Anyedge:
+			if edge.From != n && sim.Unknown(edge.From) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copied is a copy of the expression of a node, with Vars of its own for
+// the nodes of its module, so that the four-state values given to them do
+// not reach the expression kept by the node.
+type copied struct {
+	e    expr.Expr
+	vars []*expr.Var
+}
+
+// eval evaluates the expression of n with the four-state values of the
+// nodes of its module. Vars naming other values, such as parameters, keep
+// theirs.
+func (sim *Simulator) eval(n *meta.Node) expr.Value {
+	c, ok := sim.exprs[n]
+	if !ok {
+		c = &copied{}
+		vars := make(map[string]*expr.Var)
+		c.e = expr.Rewrite(n.Expr, func(x expr.Expr) (expr.Expr, bool) {
+			v, ok := x.(*expr.Var)
+			if !ok {
+				return x, false
+			}
+			if _, ok := n.Mod.Values[v.Name]; !ok {
+				return x, false
+			}
+			if vars[v.Name] == nil {
+				vars[v.Name] = expr.NewVar(v.Name, v.Value)
+				c.vars = append(c.vars, vars[v.Name])
+			}
+			return vars[v.Name], true
+		})
+		sim.exprs[n] = c
+	}
+	for _, v := range c.vars {
+		v.Value = sim.Value(n.Mod.Values[v.Name])
+	}
+	return c.e.Eval()
+}
+
+// Value returns the four-state value of n.
+func (sim *Simulator) Value(n *meta.Node) expr.Value {
+	v := n.Value()
+	if sim.Unknown(n) {
+		return expr.Unknown(v.Width()).WithSign(v.Signed())
 	}
+	return v
 }
 
 func (sim *Simulator) End() {
-	sim.scheduler <- event{nil, sim.now}
+	sim.scheduler <- event{ts: sim.now}
 }
 
 func (sim *Simulator) Run() {
@@ -48,6 +152,9 @@ func (sim *Simulator) Run() {
 				panic("should not close this channel")
 			}
 			if ev.sig != nil {
+				if !sim.started {
+					sim.start(ev.sig.n, ev.ts)
+				}
 				sim.putEvent(ev) // is a valid event
 				continue
 			}
@@ -61,31 +168,102 @@ func (sim *Simulator) Run() {
 	}
 }
 
+// start schedules at ts an update of every combinational node of the design
+// holding n, as Verilog evaluates continuous assignments at time zero. Nodes
+// which read no other node, or only nodes which do not change, would
+// otherwise keep their initial values.
+func (sim *Simulator) start(n *meta.Node, ts time.Time) {
+	sim.started = true
+	top := n.Mod
+	if top == nil {
+		return
+	}
+	for top.Parent() != nil {
+		top = top.Parent()
+	}
+	top.Walk(func(m *meta.Mod) error {
+		for _, n := range sortedNodes(m) {
+			if n.Update != nil && !register(n) {
+				sim.putEvent(event{sig: &signal{n, meta.Anyedge}, ts: ts})
+			}
+		}
+		return nil
+	})
+}
+
+// Set drives n with x at ts, as a testbench does. x is a value of the type
+// of n or an expr.Value, which may hold X. The logic of n, if any, computes
+// it again whenever the nodes it reads change.
 func (sim *Simulator) Set(n *meta.Node, x interface{}, ts time.Time) {
 	v := reflect.ValueOf(x)
-	n.Update = func() reflect.Value { return v }
+	unknown := false
+	if ev, ok := x.(expr.Value); ok && !v.Type().AssignableTo(n.V.Type()) {
+		var err error
+		if v, err = meta.Convert(ev, n.V.Type()); err != nil {
+			panic(err)
+		}
+		unknown = !expr.LogicOf(ev).Known()
+	}
 	sim.scheduler <- event{
-		&signal{n, meta.Anyedge},
-		ts,
+		sig: &signal{n, meta.Anyedge},
+		ts:  ts,
+		set: true,
+		v:   v,
+		x:   unknown,
 	}
 }
 
-func (sim *Simulator) updateNodeValue(n *meta.Node, v reflect.Value) {
-	if reflect.DeepEqual(n.V, v) { // XXX: implement Eq
+// lsb returns the least significant bit of v, which gives the edges of a
+// signal.
+func lsb(v reflect.Value) bool {
+	return meta.ValueOf(v).Uint()&1 == 1
+}
+
+// edges reports whether any node is triggered by the edges of n.
+func edges(n *meta.Node) bool {
+	for _, edge := range n.Notify {
+		switch edge.Sensivity.Edge() {
+		case meta.Posedge, meta.Negedge:
+			return true
+		}
+	}
+	return false
+}
+
+func (sim *Simulator) updateNodeValue(n *meta.Node, v reflect.Value, x bool) {
+	v = n.Fit(v)
+	if reflect.DeepEqual(n.V, v) && sim.Unknown(n) == x { // XXX: implement Eq
 		return
 	}
-	n.V = v
+	// an edge is a change of the least significant bit to a known value,
+	// from the other one or from X
+	var rise, fall bool
+	if edges(n) && !x {
+		was, is := lsb(n.V), lsb(v)
+		if sim.Unknown(n) {
+			was = !is
+		}
+		rise, fall = !was && is, was && !is
+	}
+	if n.V.CanSet() {
+		n.V.Set(v.Convert(n.V.Type())) // the field, which Go code may read
+	} else {
+		n.V = v
+	}
+	sim.unknown[n] = x
+	if sim.trace != nil {
+		sim.trace.record(sim.now, n, sim.Value(n))
+	}
 	for _, edge := range n.Notify {
-		posedge := v.Bool() // XXX: may fail
 		switch edge.Sensivity.Edge() {
 		case meta.Noedge:
 			continue
 		case meta.Posedge:
-			if !posedge {
+			if !rise {
 				continue
 			}
 		case meta.Negedge:
-			if posedge {
+			if !fall {
 				continue
 			}
 		case meta.Anyedge:
@@ -93,10 +271,21 @@ func (sim *Simulator) updateNodeValue(n *meta.Node, v reflect.Value) {
 		}
 		// XXX: Add delay
 		sig := &signal{edge.To, edge.Sensivity} // XXX
-		sim.putEvent(event{sig, sim.now})
+		sim.putEvent(event{sig: sig, ts: sim.now})
 	}
 }
 
+// detach returns a copy of v if it is a variable, such as the field of
+// another node, which would otherwise change along with it.
+func detach(v reflect.Value) reflect.Value {
+	if !v.CanAddr() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
+
 func (sim *Simulator) handleAnyEvent() (any bool) {
 	switch {
 	case len(sim.eventPool) > 0:
@@ -126,26 +315,31 @@ func (sim *Simulator) handleNextEvent() {
 	sim.eventPool = sim.eventPool[1:] // pop
 	sim.now = ev.ts                   // step simulation time
 
-	if ev.sig.block() {
+	n := ev.sig.n
+	switch {
+	case ev.set:
+		sim.updateNodeValue(n, ev.v, ev.x)
+	case ev.sig.block():
 		// append event in blocked queue
 		sim.blocked = append(sim.blocked, ev)
-	} else {
+	default:
 		// execute now
-		n := ev.sig.n
-		sim.updateNodeValue(n, n.Update())
+		sim.updateNodeValue(n, detach(n.Update()), sim.evalUnknown(n))
 	}
 }
 
 func (sim *Simulator) handleBlockedEvents() {
 	values := make([]reflect.Value, len(sim.blocked))
+	unknown := make([]bool, len(sim.blocked))
 	// eval
 	for i, ev := range sim.blocked {
-		values[i] = ev.sig.n.Update()
+		values[i] = detach(ev.sig.n.Update())
+		unknown[i] = sim.evalUnknown(ev.sig.n)
 	}
 	// update values and schedule next evs
 	for i, ev := range sim.blocked {
 		n := ev.sig.n
-		sim.updateNodeValue(n, values[i])
+		sim.updateNodeValue(n, values[i], unknown[i])
 	}
 	sim.blocked = nil
 }
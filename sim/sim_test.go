@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/dakerfp/verigo/expr"
 	"github.com/dakerfp/verigo/meta"
 )
 
@@ -228,3 +229,383 @@ func TestCounter(t *testing.T) { // XXX: create proper test
 		t.Fatal(c)
 	}
 }
+
+type Swap struct {
+	meta.Mod
+
+	Clk, Rst bool  "input"
+	X, Y     uint8 "output"
+}
+
+func TestAlwaysBlock(t *testing.T) {
+	m := &Swap{}
+	meta.Init(m)
+	err := m.AlwaysBlock(`
+		if Rst {
+			X, Y = 1, 2
+		} else {
+			X = Y
+			Y = X
+		}`, meta.Pos("Clk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := m.Meta()
+	clk, rst := mt.Values["Clk"], mt.Values["Rst"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	go func() {
+		sim.Set(rst, true, now)
+		sim.Set(clk, true, now.Add(1))
+		sim.Set(clk, false, now.Add(2))
+		sim.Set(rst, false, now.Add(3))
+		for i := 4; i < 9; i++ {
+			sim.Set(clk, i%2 == 0, now.Add(time.Duration(i))) // 3 clocks
+		}
+		sim.End()
+	}()
+	sim.Run()
+
+	// both registers are updated from the values before each edge
+	if x, y := mt.Values["X"].V.Uint(), mt.Values["Y"].V.Uint(); x != 2 || y != 1 {
+		t.Fatal(x, y)
+	}
+}
+
+type Mux struct {
+	meta.Mod
+
+	A, B, Sel bool "input"
+	Out       bool "output"
+}
+
+func TestAssign(t *testing.T) {
+	m := &Mux{}
+	m.Assign(&m.Out, func() bool {
+		if m.Sel {
+			return m.B
+		}
+		return m.A
+	})
+	meta.Init(m)
+	mt := m.Meta()
+	a, b, sel := mt.Values["A"], mt.Values["B"], mt.Values["Sel"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	go func() {
+		sim.Set(a, true, now)
+		sim.Set(b, false, now)
+		sim.Set(sel, false, now.Add(1))
+		sim.End()
+	}()
+	sim.Run()
+	// the closure reads the fields set by the simulator
+	if !m.A || !m.Out {
+		t.Fatal(m.A, m.Out)
+	}
+
+	go func() {
+		sim.Set(sel, true, now.Add(2))
+		sim.End()
+	}()
+	sim.Run()
+	if m.Out {
+		t.Fatal(m.Out)
+	}
+}
+
+type Phases struct {
+	meta.Mod
+
+	Phase uint8 "input"
+	Count uint8 `io:"output" width:"2"`
+}
+
+func TestVectorEdge(t *testing.T) {
+	m := &Phases{}
+	meta.Init(m)
+	m.Always(`Count`, `Count + 1`, meta.Pos("Phase"))
+	mt := m.Meta()
+	phase := mt.Values["Phase"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	go func() {
+		// the least significant bit rises 5 times
+		for i, v := range []uint8{1, 3, 2, 5, 4, 7, 6, 9, 8, 11} {
+			sim.Set(phase, v, now.Add(time.Duration(i)))
+		}
+		sim.End()
+	}()
+	sim.Run()
+
+	// 5 wraps to 1 in 2 bits
+	if m.Count != 1 {
+		t.Fatal(m.Count)
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	m := dff()
+	mt := m.Meta()
+	in := mt.Values["In"]
+	clk := mt.Values["Clk"]
+	out := mt.Values["Out"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	if !sim.Unknown(out) || sim.Unknown(in) {
+		t.Fatal(sim.Value(out), sim.Value(in))
+	}
+
+	// a clock edge while In is X keeps Out unknown
+	go func() {
+		sim.Set(in, expr.Unknown(1), now)
+		sim.Set(clk, false, now)
+		sim.Set(clk, true, now.Add(1))
+		sim.End()
+	}()
+	sim.Run()
+	if v := sim.Value(out); !expr.Eq(v, expr.Unknown(1)) {
+		t.Fatal(v)
+	}
+
+	go func() {
+		sim.Set(in, true, now.Add(2))
+		sim.Set(clk, false, now.Add(3))
+		sim.Set(clk, true, now.Add(4))
+		sim.End()
+	}()
+	sim.Run()
+	if v := sim.Value(out); !expr.Eq(v, expr.T) {
+		t.Fatal(v)
+	}
+
+	// a counter without reset never leaves X
+	c := counter()
+	count := c.Meta().Values["Count"]
+	clk = c.Meta().Values["Clk"]
+	sim = NewSimulator()
+	go func() {
+		for i := 0; i <= 4; i++ {
+			sim.Set(clk, i%2 == 1, now.Add(time.Duration(i)))
+		}
+		sim.End()
+	}()
+	sim.Run()
+	if v := sim.Value(count); !expr.Eq(v, expr.Unknown(64)) {
+		t.Fatal(v)
+	}
+
+	// until it is reset by the testbench
+	go func() {
+		sim.Set(count, 0, now.Add(5))
+		sim.End()
+	}()
+	sim.Run()
+	if v := sim.Value(count); !expr.Eq(v, expr.NewVector(64, 0)) {
+		t.Fatal(v)
+	}
+}
+
+type Consts struct {
+	meta.Mod
+
+	Clk   bool  "input"
+	Seven uint8 "output"
+	NClk  bool  "output"
+}
+
+func TestStart(t *testing.T) {
+	m := &Consts{}
+	meta.Init(m)
+	m.Always(`Seven`, `7`)
+	m.Always(`NClk`, `!Clk`)
+
+	// combinational nodes are evaluated before the first event, even if
+	// it changes nothing
+	sim := NewSimulator()
+	go func() {
+		sim.Set(m.Values["Clk"], false, time.Now())
+		sim.End()
+	}()
+	sim.Run()
+	if m.Seven != 7 || !m.NClk {
+		t.Fatal(m.Seven, m.NClk)
+	}
+}
+
+type Average struct {
+	meta.Mod
+
+	A, B     uint8 "input"
+	Sum, Avg uint8 "output"
+}
+
+func TestUnmarshal(t *testing.T) {
+	m := &Average{}
+	meta.Init(m)
+	m.Always(`Sum`, `A + B`)
+	m.Always(`Avg`, `(A + B) / 2`)
+	data, err := meta.Marshal(m.Meta())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := meta.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the decoded module wraps around as the Go code does
+	for _, mod := range []*meta.Mod{m.Meta(), d} {
+		sim := NewSimulator()
+		go func() {
+			now := time.Now()
+			sim.Set(mod.Values["A"], uint8(200), now)
+			sim.Set(mod.Values["B"], uint8(100), now)
+			sim.End()
+		}()
+		sim.Run()
+		if sum, avg := mod.Values["Sum"].V.Uint(), mod.Values["Avg"].V.Uint(); sum != 44 || avg != 22 {
+			t.Fatal(sum, avg)
+		}
+	}
+}
+
+type RstCounter struct {
+	meta.Mod
+
+	Clk, Rst bool  "input"
+	Count    uint8 "output"
+}
+
+func TestUnknownReset(t *testing.T) {
+	m := &RstCounter{}
+	meta.Init(m)
+	err := m.AlwaysBlock(`
+		if Rst {
+			Count = 0
+		} else {
+			Count++
+		}`, meta.Pos("Clk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := m.Meta()
+	clk, rst, count := mt.Values["Clk"], mt.Values["Rst"], mt.Values["Count"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	tick := func(rstv bool) {
+		go func() {
+			sim.Set(rst, rstv, now)
+			sim.Set(clk, false, now.Add(1))
+			sim.Set(clk, true, now.Add(2))
+			sim.End()
+		}()
+		sim.Run()
+		now = now.Add(3)
+	}
+
+	// counting from X gives X
+	tick(false)
+	if v := sim.Value(count); !expr.Eq(v, expr.Unknown(8)) {
+		t.Fatal(v)
+	}
+	// a synchronous reset clears it
+	tick(true)
+	if v := sim.Value(count); !expr.Eq(v, expr.NewVector(8, 0)) {
+		t.Fatal(v)
+	}
+	tick(false)
+	tick(false)
+	if v := sim.Value(count); !expr.Eq(v, expr.NewVector(8, 2)) {
+		t.Fatal(v)
+	}
+
+	// an X reset makes it unknown again
+	tick(false)
+	go func() {
+		sim.Set(rst, expr.Unknown(1), now)
+		sim.Set(clk, false, now.Add(1))
+		sim.Set(clk, true, now.Add(2))
+		sim.End()
+	}()
+	sim.Run()
+	if v := sim.Value(count); !expr.Eq(v, expr.Unknown(8)) {
+		t.Fatal(v)
+	}
+
+	// the expression of the node keeps its values
+	expr.Walk(count.Expr, func(x expr.Expr, _ []expr.Expr) error {
+		if v, ok := x.(*expr.Var); ok && !expr.LogicOf(v.Value).Known() {
+			t.Fatal(v.Name, v.Value)
+		}
+		return nil
+	})
+}
+
+type And2 struct {
+	meta.Mod
+
+	A, B bool "input"
+	O    bool "output"
+}
+
+func and2() *And2 {
+	m := &And2{}
+	meta.Init(m)
+	m.Always(`O`, `A && B`)
+	return m
+}
+
+type And3 struct {
+	meta.Mod
+
+	A, B, C bool "input"
+	O       bool "output"
+
+	ab, abc *And2 "submodule"
+}
+
+func and3() *And3 {
+	m := &And3{ab: and2(), abc: and2()}
+	m.Wire(&m.ab.A, &m.A)
+	m.Wire(&m.ab.B, &m.B)
+	m.Wire(&m.abc.A, &m.ab.O)
+	m.Wire(&m.abc.B, &m.C)
+	m.Wire(&m.O, &m.abc.O)
+	meta.Init(m)
+	return m
+}
+
+func TestHierarchy(t *testing.T) {
+	m := and3()
+	mt := m.Meta()
+	a, b, c := mt.Values["A"], mt.Values["B"], mt.Values["C"]
+	o := mt.Values["O"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	go func() {
+		sim.Set(a, true, now)
+		sim.Set(b, true, now)
+		sim.Set(c, true, now)
+		sim.End()
+	}()
+	sim.Run()
+	if !o.V.Bool() {
+		t.Fatal(o.V)
+	}
+
+	go func() {
+		sim.Set(b, false, now.Add(1))
+		sim.End()
+	}()
+	sim.Run()
+	if o.V.Bool() || m.ab.Values["O"].V.Bool() {
+		t.Fatal(o.V)
+	}
+}
@@ -0,0 +1,186 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/dakerfp/verigo/expr"
+	"github.com/dakerfp/verigo/meta"
+)
+
+// Change is a new value of a signal, at a time relative to the first
+// change recorded.
+type Change struct {
+	Time  time.Duration
+	Value expr.Value
+}
+
+type trace struct {
+	node    *meta.Node
+	id      string // VCD identifier
+	initial expr.Value
+	changes []Change
+}
+
+// Trace is the waveform of the signals of a module and of its submodules,
+// named by their paths.
+type Trace struct {
+	top     *meta.Mod
+	signals map[*meta.Node]*trace
+	paths   map[string]*trace
+	start   time.Time
+	started bool
+}
+
+// Record starts recording the changes of all the nodes of m and of its
+// submodules, returning the Trace holding them.
+func (sim *Simulator) Record(m meta.Module) *Trace {
+	tr := &Trace{
+		top:     m.Meta(),
+		signals: make(map[*meta.Node]*trace),
+		paths:   make(map[string]*trace),
+	}
+	tr.top.Walk(func(mod *meta.Mod) error {
+		for _, n := range sortedNodes(mod) {
+			t := &trace{node: n, id: vcdID(len(tr.signals)), initial: sim.Value(n)}
+			tr.signals[n] = t
+			tr.paths[n.Path()] = t
+		}
+		return nil
+	})
+	sim.trace = tr
+	return tr
+}
+
+func (tr *Trace) record(ts time.Time, n *meta.Node, v expr.Value) {
+	t, ok := tr.signals[n]
+	if !ok {
+		return
+	}
+	last := t.initial
+	if len(t.changes) > 0 {
+		last = t.changes[len(t.changes)-1].Value
+	}
+	if expr.LogicOf(last).String() == expr.LogicOf(v).String() {
+		return
+	}
+	if !tr.started {
+		tr.start, tr.started = ts, true
+	}
+	d := ts.Sub(tr.start)
+	if last := len(t.changes) - 1; last >= 0 && t.changes[last].Time == d {
+		t.changes[last].Value = v
+		return
+	}
+	t.changes = append(t.changes, Change{d, v})
+}
+
+// Changes returns the changes of the signal named by path, such as
+// Mux4.ml.Out, and its value when recording started.
+func (tr *Trace) Changes(path string) (expr.Value, []Change, bool) {
+	t, ok := tr.paths[path]
+	if !ok {
+		return nil, nil, false
+	}
+	return t.initial, t.changes, true
+}
+
+// vcdID returns the short identifier of the i-th signal of a VCD file,
+// written in base 94 with the printable ASCII characters.
+func vcdID(i int) string {
+	var id []byte
+	for {
+		id = append(id, byte('!'+i%94))
+		i /= 94
+		if i == 0 {
+			return string(id)
+		}
+		i--
+	}
+}
+
+func vcdValue(v expr.Value, id string) string {
+	bits := expr.LogicOf(v).String()
+	if len(bits) == 1 {
+		return bits + id
+	}
+	return "b" + bits + " " + id
+}
+
+// WriteVCD writes tr to w in the Value Change Dump format, with a scope
+// for each instance and times in nanoseconds.
+func (tr *Trace) WriteVCD(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "$timescale 1ns $end")
+	var scope func(m *meta.Mod, name string)
+	scope = func(m *meta.Mod, name string) {
+		fmt.Fprintf(bw, "$scope module %s $end\n", name)
+		for _, n := range sortedNodes(m) {
+			t := tr.signals[n]
+			fmt.Fprintf(bw, "$var wire %d %s %s $end\n", t.initial.Width(), t.id, n.Name)
+		}
+		for _, sub := range m.Subs() {
+			scope(sub.Meta(), sub.Meta().Instance)
+		}
+		fmt.Fprintln(bw, "$upscope $end")
+	}
+	name := tr.top.Instance
+	if name == "" {
+		name = tr.top.Name
+	}
+	scope(tr.top, name)
+	fmt.Fprintln(bw, "$enddefinitions $end")
+
+	var all []*trace
+	tr.top.Walk(func(m *meta.Mod) error {
+		for _, n := range sortedNodes(m) {
+			all = append(all, tr.signals[n])
+		}
+		return nil
+	})
+	fmt.Fprintln(bw, "#0")
+	fmt.Fprintln(bw, "$dumpvars")
+	for _, t := range all {
+		fmt.Fprintln(bw, vcdValue(t.initial, t.id))
+	}
+	fmt.Fprintln(bw, "$end")
+
+	// merge the changes of all signals in time order
+	next := make([]int, len(all))
+	for {
+		var now time.Duration = -1
+		for i, t := range all {
+			if next[i] < len(t.changes) && (now < 0 || t.changes[next[i]].Time < now) {
+				now = t.changes[next[i]].Time
+			}
+		}
+		if now < 0 {
+			break
+		}
+		fmt.Fprintf(bw, "#%d\n", now.Nanoseconds())
+		for i, t := range all {
+			if next[i] < len(t.changes) && t.changes[next[i]].Time == now {
+				fmt.Fprintln(bw, vcdValue(t.changes[next[i]].Value, t.id))
+				next[i]++
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// sortedNodes returns the nodes of m sorted by name.
+func sortedNodes(m *meta.Mod) []*meta.Node {
+	var names []string
+	for name := range m.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	nodes := make([]*meta.Node, len(names))
+	for i, name := range names {
+		nodes[i] = m.Values[name]
+	}
+	return nodes
+}
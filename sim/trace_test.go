@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dakerfp/verigo/expr"
+)
+
+func TestTrace(t *testing.T) {
+	m := and3()
+	mt := m.Meta()
+	a, b, c := mt.Values["A"], mt.Values["B"], mt.Values["C"]
+
+	now := time.Now()
+	sim := NewSimulator()
+	tr := sim.Record(m)
+	go func() {
+		sim.Set(a, true, now)
+		sim.Set(b, true, now)
+		sim.Set(c, true, now.Add(2))
+		sim.Set(b, false, now.Add(5))
+		sim.End()
+	}()
+	sim.Run()
+
+	v0, changes, ok := tr.Changes("And3.ab.O")
+	if !ok || !expr.Eq(v0, expr.F) || len(changes) != 2 ||
+		changes[0].Time != 0 || !expr.Eq(changes[0].Value, expr.T) ||
+		changes[1].Time != 5 || !expr.Eq(changes[1].Value, expr.F) {
+		t.Fatal(v0, changes)
+	}
+	if _, changes, _ := tr.Changes("And3.O"); len(changes) != 2 || changes[0].Time != 2 {
+		t.Fatal(changes)
+	}
+	if _, _, ok := tr.Changes("And3.ab"); ok {
+		t.Fatal("module traced as a signal")
+	}
+
+	var buf bytes.Buffer
+	if err := tr.WriteVCD(&buf); err != nil {
+		t.Fatal(err)
+	}
+	vcd := buf.String()
+	for _, s := range []string{
+		"$scope module And3 $end\n$var wire 1 ! A $end\n",
+		"$scope module ab $end\n$var wire 1 % A $end\n",
+		"$upscope $end\n$upscope $end\n$enddefinitions $end\n#0\n$dumpvars\n0!\n",
+		"#2\n1#\n1$\n1)\n1*\n#5\n0\"\n",
+	} {
+		if !strings.Contains(vcd, s) {
+			t.Fatalf("%q not in\n%s", s, vcd)
+		}
+	}
+}
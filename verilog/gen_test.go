@@ -1,8 +1,11 @@
 package verilog
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
+	"github.com/dakerfp/verigo/expr"
 	"github.com/dakerfp/verigo/meta"
 )
 
@@ -22,9 +25,445 @@ func mux2() *Mux2 {
 }
 
 func TestGenModule(t *testing.T) {
-	// m := mux2()
-	// err := GenerateVerilog(os.Stdout, m)
-	// if err != nil {
-	// 	panic(err)
-	// }
+	m := mux2()
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "\tassign Out = Sel && B || ~Sel && A;\n") {
+		t.Fatal(out)
+	}
+}
+
+type Adder struct {
+	meta.Mod
+
+	A   int8   "input"
+	B   uint16 "input"
+	En  bool   "input"
+	Sum int    "output"
+}
+
+func TestGenSigned(t *testing.T) {
+	m := &Adder{}
+	meta.Init(m)
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, decl := range []string{
+		"input logic signed [7:0] A",
+		"input logic [15:0] B",
+		"input logic En",
+		"output logic signed [63:0] Sum",
+	} {
+		if !strings.Contains(out, decl) {
+			t.Fatal(decl, out)
+		}
+	}
+}
+
+type RGB struct {
+	R, G, B uint8
+}
+
+type Framebuffer struct {
+	meta.Mod
+
+	Addr  uint16  `io:"input" width:"12"`
+	Delta int8    `io:"input" width:"4"`
+	Pixel RGB     "output"
+	Mask  [4]bool "output"
+}
+
+func TestGenTypes(t *testing.T) {
+	m := &Framebuffer{}
+	meta.Init(m)
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, decl := range []string{
+		"input logic [11:0] Addr",
+		"input logic signed [3:0] Delta",
+		"output logic [23:0] Pixel",
+		"output logic [3:0] Mask",
+	} {
+		if !strings.Contains(out, decl) {
+			t.Fatal(decl, out)
+		}
+	}
+}
+
+type Counter struct {
+	meta.Mod
+
+	Clk   bool  "input"
+	Step  uint8 "input"
+	Next  uint8 "output"
+	Count uint8 "output"
+	inc   uint8
+}
+
+func TestGenAssign(t *testing.T) {
+	m := &Counter{}
+	meta.Init(m)
+	m.Always(`inc`, `Step + 1`)
+	m.Always(`Next`, `Count + inc`)
+	m.Always(`Count`, `Next`, meta.Pos(`Clk`))
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+module Counter
+	(input logic Clk,
+	 input logic [7:0] Step,
+	 output logic [7:0] Next,
+	 output logic [7:0] Count);
+
+	logic [7:0] inc;
+
+	always_ff @(posedge Clk) Count <= Next;
+	assign Next = Count + inc;
+	assign inc = Step + 8'd1;
+
+endmodule : Counter
+`
+	if out := buf.String(); out != expected {
+		t.Fatal(out)
+	}
+}
+
+type Widen struct {
+	meta.Mod
+
+	A, B uint8  "input"
+	Out  uint16 "output"
+}
+
+func TestGenWidths(t *testing.T) {
+	m := &Widen{}
+	meta.Init(m)
+	mt := m.Meta()
+	a, b := expr.NewVar("A", expr.NewVector(8, 200)), expr.NewVar("B", expr.NewVector(8, 100))
+	mt.SetExpr(mt.Values["Out"], expr.Add(a, b))
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	// Verilog would add with the 16 bits of Out, keeping the carry
+	if out := buf.String(); !strings.Contains(out, "\tassign Out = 8'(A + B);\n") {
+		t.Fatal(out)
+	}
+}
+
+func TestGenDivide(t *testing.T) {
+	m := &Counter{}
+	meta.Init(m)
+	// Go gives 0 when dividing by zero, Verilog gives x
+	m.Always(`Next`, `Count / Step`)
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "\tassign Next = Step == 8'd0 ? 8'd0 : Count / Step;\n") {
+		t.Fatal(out)
+	}
+}
+
+type EnCounter struct {
+	meta.Mod
+
+	Clk, Rst, En bool  "input"
+	Count        uint8 "output"
+}
+
+func TestGenBlock(t *testing.T) {
+	m := &EnCounter{}
+	meta.Init(m)
+	err := m.AlwaysBlock(`
+		if Rst {
+			Count = 0
+		} else if En {
+			Count++
+		}`, meta.Pos(`Clk`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	stmt := "\talways_ff @(posedge Clk) Count <= Rst ? 8'd0 : En ? Count + 8'd1 : Count;\n"
+	if out := buf.String(); !strings.Contains(out, stmt) {
+		t.Fatal(out)
+	}
+}
+
+type And struct {
+	meta.Mod
+
+	A, B bool "input"
+	O    bool "output"
+}
+
+func and() *And {
+	m := &And{}
+	meta.Init(m)
+	m.Always(`O`, `A && B`)
+	return m
+}
+
+type And3 struct {
+	meta.Mod
+
+	A, B, C bool "input"
+	O       bool "output"
+
+	ab, abc *And "submodule"
+}
+
+func TestGenHierarchy(t *testing.T) {
+	m := &And3{ab: and(), abc: and()}
+	m.Wire(&m.ab.A, &m.A)
+	m.Wire(&m.ab.B, &m.B)
+	m.Wire(&m.abc.A, &m.ab.O)
+	m.Wire(&m.abc.B, &m.C)
+	m.Wire(&m.O, &m.abc.O)
+	meta.Init(m)
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+module And3
+	(input logic A,
+	 input logic B,
+	 input logic C,
+	 output logic O);
+
+	logic ab_O;
+	logic abc_O;
+
+	And ab(.A(A), .B(B), .O(ab_O));
+	And abc(.A(ab_O), .B(C), .O(abc_O));
+
+	assign O = abc_O;
+
+endmodule : And3
+
+module And
+	(input logic A,
+	 input logic B,
+	 output logic O);
+
+	assign O = A && B;
+
+endmodule : And
+`
+	if out := buf.String(); out != expected {
+		t.Fatal(out)
+	}
+
+	if p := m.abc.Values["O"].Path(); p != "And3.abc.O" {
+		t.Fatal(p)
+	}
+
+	// inputs are connected to signals or to outputs of other instances
+	m = &And3{ab: and(), abc: and()}
+	meta.Init(m)
+	m.Wire(&m.abc.A, &m.ab.A)
+	if err := GenerateVerilog(&buf, m); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// every instance of a type has the same declaration
+	or := &And{}
+	meta.Init(or)
+	or.Always(`O`, `A || B`)
+	m = &And3{ab: and(), abc: or}
+	meta.Init(m)
+	if err := GenerateVerilog(&buf, m); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+type Handshake struct {
+	Valid bool  "output"
+	Ready bool  "input"
+	Data  uint8 "output"
+}
+
+type Source struct {
+	meta.Mod
+
+	Clk bool      "input"
+	Out Handshake "interface"
+}
+
+type Sink struct {
+	meta.Mod
+
+	In  Handshake "flipped interface"
+	Got uint8     "output"
+}
+
+type Link struct {
+	meta.Mod
+
+	Clk bool   "input"
+	Got uint8  "output"
+	src Source "submodule"
+	dst Sink   "submodule"
+}
+
+func TestGenInterface(t *testing.T) {
+	m := &Link{}
+	m.Wire(&m.src.Clk, &m.Clk)
+	m.Wire(&m.dst.In, &m.src.Out)
+	m.Wire(&m.Got, &m.dst.Got)
+	meta.Init(m)
+	src, dst := m.src.Meta(), m.dst.Meta()
+	if err := src.AlwaysBlock(`
+		if Out.Ready {
+			Out.Data++
+		}
+		Out.Valid = true`, meta.Pos(`Clk`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.AlwaysBlock(`In.Ready = In.Valid; Got = In.Data`); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+module Link
+	(input logic Clk,
+	 output logic [7:0] Got);
+
+	logic src_Out_Valid;
+	logic [7:0] src_Out_Data;
+	logic dst_In_Ready;
+	logic [7:0] dst_Got;
+
+	Source src(.Clk(Clk), .Out_Ready(dst_In_Ready), .Out_Valid(src_Out_Valid), .Out_Data(src_Out_Data));
+	Sink dst(.In_Valid(src_Out_Valid), .In_Data(src_Out_Data), .In_Ready(dst_In_Ready), .Got(dst_Got));
+
+	assign Got = dst_Got;
+
+endmodule : Link
+
+module Source
+	(input logic Clk,
+	 input logic Out_Ready,
+	 output logic Out_Valid,
+	 output logic [7:0] Out_Data);
+
+	always_ff @(posedge Clk) Out_Data <= Out_Ready ? Out_Data + 8'd1 : Out_Data;
+	always_ff @(posedge Clk) Out_Valid <= 1'b1;
+
+endmodule : Source
+
+module Sink
+	(input logic In_Valid,
+	 input logic [7:0] In_Data,
+	 output logic In_Ready,
+	 output logic [7:0] Got);
+
+	assign Got = In_Data;
+	assign In_Ready = In_Valid;
+
+endmodule : Sink
+`
+	if out := buf.String(); out != expected {
+		t.Fatal(out)
+	}
+}
+
+type Delay struct {
+	meta.Mod
+
+	Width int    "param"
+	Clk   bool   "input"
+	D     uint32 `io:"input" width:"Width"`
+	Q     uint32 `io:"output" width:"Width"`
+}
+
+func delay(width int) *Delay {
+	m := &Delay{Width: width}
+	meta.Init(m)
+	m.Always(`Q`, `D << (Width - 8)`, meta.Pos(`Clk`))
+	return m
+}
+
+type Delays struct {
+	meta.Mod
+
+	Clk bool   "input"
+	A   uint8  "input"
+	B   uint16 `io:"input" width:"12"`
+	QA  uint8  "output"
+	QB  uint16 `io:"output" width:"12"`
+
+	da, db *Delay "submodule"
+}
+
+func TestGenParams(t *testing.T) {
+	m := &Delays{da: delay(8), db: delay(12)}
+	m.Wire(&m.da.Clk, &m.Clk)
+	m.Wire(&m.db.Clk, &m.Clk)
+	m.Wire(&m.da.D, &m.A)
+	m.Wire(&m.db.D, &m.B)
+	m.Wire(&m.QA, &m.da.Q)
+	m.Wire(&m.QB, &m.db.Q)
+	meta.Init(m)
+
+	var buf bytes.Buffer
+	if err := GenerateVerilog(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+module Delays
+	(input logic Clk,
+	 input logic [7:0] A,
+	 input logic [11:0] B,
+	 output logic [7:0] QA,
+	 output logic [11:0] QB);
+
+	logic [7:0] da_Q;
+	logic [11:0] db_Q;
+
+	Delay #(.Width(8)) da(.Clk(Clk), .D(A), .Q(da_Q));
+	Delay #(.Width(12)) db(.Clk(Clk), .D(B), .Q(db_Q));
+
+	assign QA = da_Q;
+	assign QB = db_Q;
+
+endmodule : Delays
+
+module Delay #(parameter Width = 8)
+	(input logic Clk,
+	 input logic [Width-1:0] D,
+	 output logic [Width-1:0] Q);
+
+	always_ff @(posedge Clk) Q <= 32'(D) << Width - 8;
+
+endmodule : Delay
+`
+	if out := buf.String(); out != expected {
+		t.Fatal(out)
+	}
 }
@@ -1,9 +1,13 @@
 package verilog
 
 import (
+	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"text/template"
 
+	"github.com/dakerfp/verigo/expr"
 	"github.com/dakerfp/verigo/meta"
 )
 
@@ -11,28 +15,294 @@ var verilogTemplate *template.Template
 
 func init() {
 
-	verilogTemplate = template.Must(template.New("verilog").Parse(`
-{{- define "inports"}}
-	{{- range $i, $n := $}}
-		{{- if $i}}	 {{end}}input {{$n.T}} {{$n.Name}}
-		{{- ",\n"}}
+	verilogTemplate = template.Must(template.New("verilog").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).Parse(`
+{{- define "ports"}}
+	{{- range $i, $p := $}}
+		{{- if $i}},
+	 {{end}}{{$p.Dir}} {{$p.Type}} {{$p.Name}}
 	{{- end}}
 {{- end}}
-{{- define "outports"}}
-	{{- range $i, $n := $}}
-		{{- if gt $i 0}}	 {{end}}output {{$n.T}} {{$n.Name}}
-		{{- ",\n"}}
-	{{- end}}
+module {{.Name}}
+	{{- if .Params}} #({{join .Params ", "}}){{end}}
+	({{template "ports" .Ports}});
+{{if .Wires}}
+{{range .Wires}}	{{.Type}} {{.Name}};
+{{end}}
+{{- end}}
+{{- if .Instances}}
+{{range .Instances}}	{{.Type}}{{if .Params}} #({{join .Params ", "}}){{end}} {{.Name}}({{join .Conns ", "}});
+{{end}}
+{{- end}}
+{{- if .Statements}}
+{{range .Statements}}
+	{{- if .Event}}	always_ff @({{.Event}}) {{.Target}} <= {{.Expr}};
+{{else}}	assign {{.Target}} = {{.Expr}};
+{{end}}
+{{- end}}
 {{- end}}
-modulename {{.Name}}
-	({{template "inports" .Inputs}}
-	 {{template "outports" .Outputs}}	);
-
 endmodule : {{.Name}}
 `))
 }
 
-func GenerateVerilog(w io.Writer, module meta.Module) error {
-	mod := module.Meta()
-	return verilogTemplate.Execute(w, mod)
+// dataType returns the Verilog type declaring the node, e.g.
+// logic signed [7:0] for an int8 field. The width of a node declared by mod
+// is given by its parameter, if any, e.g. logic [Width-1:0].
+func dataType(mod *meta.Mod, n *meta.Node) string {
+	v := n.Value()
+	t := "logic"
+	if v.Signed() {
+		t += " signed"
+	}
+	switch w := v.Width(); {
+	case n.Param != "" && n.Mod == mod:
+		t += fmt.Sprintf(" [%s-1:0]", n.Param)
+	case w > 1:
+		t += fmt.Sprintf(" [%d:0]", w-1)
+	}
+	return t
+}
+
+type port struct {
+	Dir, Type, Name string
+}
+
+type statement struct {
+	Target, Expr, Event string
+}
+
+// net is a signal declared in a module, either a node or an output of an
+// instance.
+type net struct {
+	Type, Name string
+}
+
+// instance is a submodule with the values of its parameters and the
+// connections of its ports.
+type instance struct {
+	Type, Name string
+	Params     []string
+	Conns      []string
+}
+
+type module struct {
+	Name       string
+	Params     []string
+	Ports      []port
+	Wires      []net
+	Instances  []instance
+	Statements []statement
+}
+
+// ident returns the Verilog identifier of an instance or of a signal, e.g.
+// Lanes_3 for Lanes[3] and Out_Valid for the port Valid of the interface
+// Out, as interfaces are flattened into their ports.
+func ident(name string) string {
+	return strings.NewReplacer("[", "_", "]", "", ".", "_").Replace(name)
+}
+
+// format returns the Verilog form of e.
+func format(e expr.Expr) string {
+	return expr.Format(expr.Rewrite(e, func(x expr.Expr) (expr.Expr, bool) {
+		if v, ok := x.(*expr.Var); ok && ident(v.Name) != v.Name {
+			return &expr.Var{Name: ident(v.Name), Value: v.Value, Free: v.Free}, true
+		}
+		return x, false
+	}))
+}
+
+// netName returns the name in the parent of mod of the signal connected to
+// the port n of mod.
+func netName(n *meta.Node) string {
+	return ident(n.Mod.Instance) + "_" + ident(n.Name)
+}
+
+// driver returns the signal of mod wired to n: a node of mod or an output
+// of one of its instances. Nodes driven from outside of mod have none.
+func driver(mod *meta.Mod, n *meta.Node) (string, bool, error) {
+	d := n.Driver
+	switch {
+	case d.Mod == nil || d.Mod == mod:
+		return ident(d.Name), true, nil
+	case d.Mod.Parent() == mod && output(d):
+		return netName(d), true, nil
+	case n.Mod == mod && (d.Mod == mod.Parent() || d.Mod.Parent() == mod.Parent()):
+		return "", false, nil // by the parent or a sibling
+	}
+	return "", false, fmt.Errorf("wire from %s to %s is not a port connection", d.Path(), n.Path())
+}
+
+func output(n *meta.Node) bool {
+	for _, o := range n.Mod.Outputs {
+		if o == n {
+			return true
+		}
+	}
+	return false
+}
+
+// newInstance connects the ports of the submodule sub of mod.
+func newInstance(mod, sub *meta.Mod) (instance, []net, error) {
+	inst := instance{Type: sub.Name, Name: ident(sub.Instance)}
+	for _, p := range sub.Params {
+		inst.Params = append(inst.Params, fmt.Sprintf(".%s(%v)", p.Name, p.V))
+	}
+	var nets []net
+	for _, n := range sub.Inputs {
+		conn := ""
+		if n.Driver != nil {
+			name, ok, err := driver(mod, n)
+			if err != nil {
+				return inst, nil, err
+			}
+			if ok {
+				conn = name
+			}
+		}
+		inst.Conns = append(inst.Conns, fmt.Sprintf(".%s(%s)", ident(n.Name), conn))
+	}
+	for _, n := range sub.Outputs {
+		nets = append(nets, net{dataType(mod, n), netName(n)})
+		inst.Conns = append(inst.Conns, fmt.Sprintf(".%s(%s)", ident(n.Name), netName(n)))
+	}
+	return inst, nets, nil
+}
+
+// event returns the event control of an edge triggered node, or an empty
+// string if n is combinational.
+func event(n *meta.Node) string {
+	var events []string
+	for _, e := range n.Listen {
+		switch e.Edge() {
+		case meta.Posedge:
+			events = append(events, "posedge "+ident(e.From.Name))
+		case meta.Negedge:
+			events = append(events, "negedge "+ident(e.From.Name))
+		}
+	}
+	return strings.Join(events, " or ")
+}
+
+func newModule(mod *meta.Mod) (*module, error) {
+	m := &module{Name: mod.Name}
+	for _, p := range mod.Params {
+		m.Params = append(m.Params, fmt.Sprintf("parameter %s = %v", p.Name, p.V))
+	}
+	ports := make(map[*meta.Node]bool)
+	for _, n := range mod.Inputs {
+		m.Ports = append(m.Ports, port{"input", dataType(mod, n), ident(n.Name)})
+		ports[n] = true
+	}
+	for _, n := range mod.Outputs {
+		m.Ports = append(m.Ports, port{"output", dataType(mod, n), ident(n.Name)})
+		ports[n] = true
+	}
+
+	var names []string
+	for name := range mod.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		n := mod.Values[name]
+		if n.Update == nil && len(n.Notify) == 0 {
+			continue // not part of the hardware
+		}
+		if !ports[n] {
+			m.Wires = append(m.Wires, net{dataType(mod, n), ident(name)})
+		}
+		if n.Update == nil {
+			continue
+		}
+		if n.Driver != nil {
+			d, ok, err := driver(mod, n)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				m.Statements = append(m.Statements, statement{ident(name), d, ""})
+			}
+			continue
+		}
+		if n.Expr == nil {
+			return nil, fmt.Errorf("%s: expression cannot be translated to Verilog", n.Path())
+		}
+		v := n.Value()
+		diags := expr.Errors(expr.CheckAssign(expr.TypeOf(v), n.Expr))
+		if len(diags) > 0 {
+			return nil, fmt.Errorf("%s: %v", n.Path(), diags[0])
+		}
+		x := expr.Sized(expr.TypeOf(v), n.Expr)
+		m.Statements = append(m.Statements, statement{ident(name), format(x), event(n)})
+	}
+
+	for _, sub := range mod.Subs() {
+		inst, nets, err := newInstance(mod, sub.Meta())
+		if err != nil {
+			return nil, err
+		}
+		m.Instances = append(m.Instances, inst)
+		m.Wires = append(m.Wires, nets...)
+	}
+	return m, nil
+}
+
+// GenerateVerilog writes the SystemVerilog declaration of top to w,
+// followed by the declarations of the types of its submodules. Combinational
+// nodes become continuous assignments and edge triggered ones always_ff
+// blocks. Submodules become instances named after their fields, whose
+// outputs are connected to signals named after the instance and the port,
+// e.g. ml_Out. Interfaces are flattened into their ports, e.g. Out_Valid
+// for the port Valid of the interface Out. Parameters are declared with the
+// values of the first instance of each type, and set by every instance.
+// Otherwise, all the instances of a type must have the same declaration.
+// Expressions are checked first, and an error is returned for any
+// expression which is invalid in Verilog. Size casts are added where Verilog
+// would evaluate an operator with more bits or another sign than the
+// simulation, see expr.Sized.
+func GenerateVerilog(w io.Writer, top meta.Module) error {
+	var names []string
+	first := make(map[string]*module)
+	bodies := make(map[string]string)
+	err := top.Meta().Walk(func(mod *meta.Mod) error {
+		m, err := newModule(mod)
+		if err != nil {
+			return err
+		}
+		f, ok := first[mod.Name]
+		if ok {
+			// another instance of the same type, which sets its own
+			// parameters
+			m.Params = f.Params
+		}
+		b, err := body(m)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			names = append(names, mod.Name)
+			first[mod.Name], bodies[mod.Name] = m, b
+		} else if b != bodies[mod.Name] {
+			return fmt.Errorf("%s: differs from the first instance of %s", mod.Path(), mod.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := io.WriteString(w, bodies[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// body returns the declaration of m.
+func body(m *module) (string, error) {
+	var buf strings.Builder
+	err := verilogTemplate.Execute(&buf, m)
+	return buf.String(), err
 }